@@ -3,11 +3,13 @@ package api
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/fulldump/box"
 	"github.com/fulldump/box/boxopenapi"
 
 	"github.com/fulldump/inceptiondb/api/apicollectionv1"
+	"github.com/fulldump/inceptiondb/cluster"
 	"github.com/fulldump/inceptiondb/service"
 	"github.com/fulldump/inceptiondb/statics"
 )
@@ -15,6 +17,7 @@ import (
 func Build(s service.Servicer, staticsDir, version string) *box.B { // TODO: remove datadir
 
 	b := box.NewBox()
+	startedAt := time.Now()
 
 	v1 := b.Resource("/v1")
 	v1.WithInterceptors(box.SetResponseHeader("Content-Type", "application/json"))
@@ -24,6 +27,68 @@ func Build(s service.Servicer, staticsDir, version string) *box.B { // TODO: rem
 			injectServicer(s),
 		)
 
+	v1.Resource("/databases").
+		WithActions(
+			box.Get(listDatabases(s)),
+			write(box.Post(createDatabase(s)).WithName("createDatabase")),
+		)
+
+	databases := v1.Resource("/databases/{databaseName}").
+		WithActions(
+			box.Get(getDatabase(s)),
+			write(box.ActionPost(setSettings(s)).WithName("setSettings")),
+			write(box.ActionPost(dropDatabase(s)).WithName("dropDatabase")),
+		)
+	apicollectionv1.BuildV1Collection(databases, s).
+		WithInterceptors(
+			injectDatabaseServicer(s),
+		)
+
+	v1.Resource("/apikeys").
+		WithActions(
+			box.Get(listKeys(s)),
+			write(box.Post(createKey(s)).WithName("createKey")),
+		)
+
+	v1.Resource("/apikeys/{keyId}").
+		WithActions(
+			box.Get(getKey(s)),
+			write(box.ActionPost(revokeKey(s)).WithName("revoke")),
+		)
+
+	v1.Resource("/audit").
+		WithActions(
+			box.Get(queryAudit(s)),
+		)
+
+	v1.Resource("/status").
+		WithActions(
+			box.Get(getStatus(s, version, startedAt)),
+		)
+
+	v1.Resource("/load").
+		WithActions(
+			box.Get(loadProgress(s)),
+		)
+
+	v1.Resource("/backup").
+		WithActions(
+			box.Post(backup(s)),
+		)
+
+	v1.Resource("/replication").
+		WithActions(
+			box.Get(replicationStatus(s)),
+			audited(box.ActionPost(promote(s)).WithName("promote")),
+		)
+
+	v1.Resource("/cluster").
+		WithActions(
+			box.Get(clusterStatus(s)),
+			box.ActionPost(clusterVote(s)).WithName(cluster.RPCVote),
+			box.ActionPost(clusterAppend(s)).WithName(cluster.RPCAppend),
+		)
+
 	b.Resource("/v1/*").
 		WithActions(box.AnyMethod(func(w http.ResponseWriter) interface{} {
 			w.WriteHeader(http.StatusNotImplemented)
@@ -38,6 +103,15 @@ func Build(s service.Servicer, staticsDir, version string) *box.B { // TODO: rem
 			return version
 		}))
 
+	b.Resource("/livez").
+		WithActions(box.Get(liveness()).WithName("liveness"))
+
+	b.Resource("/readyz").
+		WithActions(box.Get(readiness(s)).WithName("readiness"))
+
+	b.Resource("/metrics").
+		WithActions(box.Get(getMetrics(s)).WithName("metrics"))
+
 	spec := boxopenapi.Spec(b)
 	spec.Info.Title = "InceptionDB"
 	spec.Info.Description = "A durable in-memory database to store JSON documents."
@@ -67,6 +141,14 @@ func Build(s service.Servicer, staticsDir, version string) *box.B { // TODO: rem
 	return b
 }
 
+func write(a *box.A) *box.A {
+	return audited(a.WithAttribute(apicollectionv1.AttributeWrite, true))
+}
+
+func audited(a *box.A) *box.A {
+	return a.WithAttribute(apicollectionv1.AttributeAudit, true)
+}
+
 func injectServicer(s service.Servicer) box.I {
 	return func(next box.H) box.H {
 		return func(ctx context.Context) {
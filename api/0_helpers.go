@@ -3,11 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/fulldump/box"
 
+	"github.com/fulldump/inceptiondb/api/apicollectionv1"
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/cluster"
+	"github.com/fulldump/inceptiondb/collection"
 	"github.com/fulldump/inceptiondb/database"
 )
 
@@ -54,11 +60,8 @@ func InterceptorUnavailable(db *database.Database) box.I {
 	return func(next box.H) box.H {
 		return func(ctx context.Context) {
 
+			// While opening, collections are served as soon as they are loaded
 			status := db.GetStatus()
-			if status == database.StatusOpening {
-				box.SetError(ctx, fmt.Errorf("temporary unavailable: opening"))
-				return
-			}
 			if status == database.StatusClosing {
 				box.SetError(ctx, fmt.Errorf("temporary unavailable: closing"))
 				return
@@ -68,6 +71,93 @@ func InterceptorUnavailable(db *database.Database) box.I {
 	}
 }
 
+// InterceptorAuth rejects the requests to /v1 and /metrics without a valid
+// API key or token (see auth.Chain). Principals scoped to some databases and
+// collections can only use the resources marked with
+// apicollectionv1.AttributeScoped, and only the actions allowed to their role
+// there (see apicollectionv1.AttributeRole). Anything else needs an admin.
+func InterceptorAuth(authenticator auth.Authenticator) box.I {
+	return func(next box.H) box.H {
+		return func(ctx context.Context) {
+
+			r := box.GetRequest(ctx)
+			if !strings.HasPrefix(r.URL.Path, "/v1/") && r.URL.Path != "/metrics" {
+				next(ctx)
+				return
+			}
+
+			principal, err := auth.AuthenticateRequest(authenticator, r)
+			if err != nil {
+				box.SetError(ctx, err)
+				return
+			}
+
+			if info := getRequestInfo(ctx); info != nil {
+				info.principal = principal.Name
+			}
+
+			err = authorize(box.GetBoxContext(ctx), principal)
+			if err != nil {
+				box.SetError(ctx, err)
+				return
+			}
+
+			next(auth.SetPrincipal(ctx, principal))
+		}
+	}
+}
+
+func authorize(c *box.C, principal *auth.Principal) error {
+
+	if principal.Admin {
+		return nil
+	}
+	if c.Resource == nil || c.Resource.GetAttribute(apicollectionv1.AttributeScoped) != true {
+		return fmt.Errorf("%w: admin required", auth.ErrForbidden)
+	}
+
+	databaseName := c.Parameters["databaseName"]
+	if databaseName == "" {
+		databaseName = database.DefaultDatabase
+	}
+	collectionName := c.Parameters["collectionName"]
+	role := principal.Role(databaseName, collectionName)
+	if role == "" {
+		return fmt.Errorf("%w: '%s' has no access to '%s/%s'", auth.ErrForbidden, principal.Name, databaseName, collectionName)
+	}
+
+	if c.Action == nil {
+		return nil // box will reject it
+	}
+	required, _ := c.Action.GetAttribute(apicollectionv1.AttributeRole).(string)
+	if required == "" {
+		required = auth.RoleAdmin
+	}
+	if !auth.RoleIncludes(role, required) {
+		return fmt.Errorf("%w: '%s' is %s in '%s/%s', '%s' needs %s", auth.ErrForbidden, principal.Name, role, databaseName, collectionName, c.Action.Name, required)
+	}
+
+	return nil
+}
+
+// InterceptorReadOnly rejects the actions marked with
+// apicollectionv1.AttributeWrite while the database is read only
+func InterceptorReadOnly(db *database.Database) box.I {
+	return func(next box.H) box.H {
+		return func(ctx context.Context) {
+			action := box.GetBoxContext(ctx).Action
+			if action != nil && action.GetAttribute(apicollectionv1.AttributeWrite) == true {
+				reason := db.ReadOnly()
+				if reason != "" {
+					box.SetError(ctx, fmt.Errorf("%w: %s", database.ErrReadOnly, reason))
+					return
+				}
+			}
+			next(ctx)
+		}
+	}
+}
+
 func PrettyErrorInterceptor(next box.H) box.H {
 	return func(ctx context.Context) {
 
@@ -101,6 +191,106 @@ func PrettyErrorInterceptor(next box.H) box.H {
 			return
 		}
 
+		if errors.Is(err, auth.ErrUnauthorized) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="inceptiondb"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "send a valid API key in the X-Api-Key header or as a bearer token",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, auth.ErrForbidden) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "the API key is not allowed to do this, check its scopes and roles",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, database.ErrCollectionLoading) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "the collection is still being loaded, try again later",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, database.ErrReadOnly) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "this instance does not accept writes",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, database.ErrDatabaseNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "the database does not exist, see GET /v1/databases",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, database.ErrCollectionLimit) || errors.Is(err, collection.ErrDocumentLimit) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "a limit of the database was reached, see its settings",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, cluster.ErrLogFull) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "the cluster does not accept more writes, see ClusterMaxLogEntries",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, cluster.ErrNotLeader) || errors.Is(err, cluster.ErrLeadershipLost) || errors.Is(err, cluster.ErrCommitTimeout) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "the change could not be committed by the cluster, check GET /v1/cluster and try again",
+				},
+			})
+			return
+		}
+
+		if errors.Is(err, database.ErrCollectionQuarantined) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message":     err.Error(),
+					"description": "the collection could not be opened, fix it and use the :retry or :repair actions",
+				},
+			})
+			return
+		}
+
 		if _, ok := err.(*json.SyntaxError); ok {
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]interface{}{
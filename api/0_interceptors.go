@@ -1,40 +1,64 @@
 package api
 
 import (
+	"bufio"
 	"context"
-	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strings"
 	"time"
 
 	"github.com/fulldump/box"
+	"github.com/google/uuid"
+
+	"github.com/fulldump/inceptiondb/api/apicollectionv1"
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/logger"
 )
 
 func RecoverFromPanic(next box.H) box.H {
 	return func(ctx context.Context) {
 		defer func() {
 			if err := recover(); err != nil {
-				fmt.Println("ERROR:", err)
-				debug.PrintStack()
+				if err == http.ErrAbortHandler {
+					panic(err) // net/http closes the connection
+				}
+				slog.ErrorContext(ctx, "panic", "error", err, "stack", string(debug.Stack()))
 			}
 		}()
 		next(ctx)
 	}
 }
 
-func AccessLog(l *log.Logger) box.I {
+// AccessLog logs every request when it finishes, with its status code
+func AccessLog(l *slog.Logger) box.I {
 	return func(next box.H) box.H {
 		return func(ctx context.Context) {
-			r := box.GetRequest(ctx)
+			c := box.GetBoxContext(ctx)
+			r := c.Request
 			action := ""
-			if boxAction := box.GetBoxContext(ctx).Action; boxAction != nil {
-				action = boxAction.Name
+			if c.Action != nil {
+				action = c.Action.Name
 			}
+			sw := &statusResponseWriter{ResponseWriter: c.Response}
+			c.Response = sw
 			now := time.Now()
 			defer func() {
-				l.Println(now.UTC().Format(time.RFC3339Nano), formatRemoteAddr(r), r.Method, r.URL.String(), time.Since(now), action)
+				status := sw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				l.InfoContext(ctx, "access",
+					"remote_addr", formatRemoteAddr(r),
+					"method", r.Method,
+					"url", r.URL.String(),
+					"status", status,
+					"took", time.Since(now),
+					"action", action,
+				)
 			}()
 
 			next(ctx)
@@ -51,3 +75,155 @@ func formatRemoteAddr(r *http.Request) string {
 
 	return r.RemoteAddr[0:strings.LastIndex(r.RemoteAddr, ":")]
 }
+
+// peerAddr is the address of the connection, unlike formatRemoteAddr it can
+// not be chosen by the client
+func peerAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestInfo is filled by the interceptors for the ones before them
+type requestInfo struct {
+	principal string // set by InterceptorAuth
+}
+
+type requestInfoKey struct{}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// GetRequestId returns the id given by InterceptorRequestId, empty if there is
+// none
+func GetRequestId(ctx context.Context) string {
+	return logger.RequestId(ctx)
+}
+
+// InterceptorRequestId identifies every request with the X-Request-Id header
+// of the client (e.g. from a load balancer) or a new uuid, and returns it in
+// the response. The id is attached to the log lines of the request (see
+// package logger). It must be the first interceptor.
+func InterceptorRequestId(next box.H) box.H {
+	return func(ctx context.Context) {
+		id := box.GetRequest(ctx).Header.Get("X-Request-Id")
+		if !validRequestId(id) {
+			id = uuid.New().String()
+		}
+		box.GetResponse(ctx).Header().Set("X-Request-Id", id)
+		ctx = logger.WithRequestId(ctx, id)
+		next(context.WithValue(ctx, requestInfoKey{}, &requestInfo{}))
+	}
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// InterceptorAudit appends an entry to the audit log for every action marked
+// with apicollectionv1.AttributeAudit, including the rejected ones. It goes
+// after AccessLog to see the final status.
+func InterceptorAudit(l *audit.Log) box.I {
+	return func(next box.H) box.H {
+		return func(ctx context.Context) {
+
+			c := box.GetBoxContext(ctx)
+			if c.Action == nil || c.Action.GetAttribute(apicollectionv1.AttributeAudit) != true {
+				next(ctx)
+				return
+			}
+
+			r := box.GetRequest(ctx)
+			entry := &audit.Entry{
+				Time:         time.Now().UTC(),
+				RequestId:    GetRequestId(ctx),
+				RemoteAddr:   peerAddr(r),
+				ForwardedFor: r.Header.Get("X-Forwarded-For"),
+				Method:       r.Method,
+				Path:         r.URL.Path,
+				Action:       c.Action.Name,
+				Database:     c.Parameters["databaseName"],
+				Collection:   c.Parameters["collectionName"],
+			}
+			if entry.Collection != "" && entry.Database == "" {
+				entry.Database = database.DefaultDatabase
+			}
+
+			sw := &statusResponseWriter{ResponseWriter: c.Response}
+			c.Response = sw
+			next(audit.SetEntry(ctx, entry))
+
+			entry.Status = sw.status
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			if err := box.GetError(ctx); err != nil {
+				entry.Error = err.Error()
+			}
+			if info := getRequestInfo(ctx); info != nil {
+				entry.Principal = info.principal
+			}
+
+			err := l.Append(entry)
+			if err != nil {
+				slog.ErrorContext(ctx, "audit", "error", err)
+			}
+		}
+	}
+}
+
+// statusResponseWriter remembers the status code sent
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader ignores the calls after the first one, like box writing 204
+// after a handler that already sent its status
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack is used by :insertStream, that always answers 202 on the raw
+// connection
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.status == 0 {
+		w.status = http.StatusAccepted
+	}
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap allows http.ResponseController to flush or enable full duplex
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -3,40 +3,82 @@ package apicollectionv1
 import (
 	"github.com/fulldump/box"
 
+	"github.com/fulldump/inceptiondb/auth"
 	"github.com/fulldump/inceptiondb/service"
 )
 
+// AttributeWrite marks the actions that modify data, they are rejected by
+// read only instances (see api.InterceptorReadOnly)
+const AttributeWrite = "write"
+
+// AttributeScoped marks the collection resources, API keys scoped to the
+// collection can use them. The rest of /v1 needs an admin key (see
+// api.InterceptorAuth).
+const AttributeScoped = "scoped"
+
+// AttributeAudit marks the actions recorded in the audit log (see
+// api.InterceptorAudit): every write plus the maintenance actions
+const AttributeAudit = "audit"
+
+func write(a *box.A) *box.A {
+	return audited(a.WithAttribute(AttributeWrite, true))
+}
+
+func audited(a *box.A) *box.A {
+	return a.WithAttribute(AttributeAudit, true)
+}
+
+// AttributeRole is the role an API key needs in the collection to use the
+// action, see auth.Principal
+const AttributeRole = "role"
+
+func role(r string, a *box.A) *box.A {
+	return a.WithAttribute(AttributeRole, r)
+}
+
 // todo: rename to BuildV1Collection
 func BuildV1Collection(v1 *box.R, s service.Servicer) *box.R {
 
 	collections := v1.Resource("/collections").
 		WithActions(
-			box.Get(listCollections),
-			box.Post(createCollection),
-		)
+			role(auth.RoleReader, box.Get(listCollections)),
+			role(auth.RoleWriter, write(box.Post(createCollection))),
+		).
+		WithAttribute(AttributeScoped, true)
 
 	v1.Resource("/collections/{collectionName}").
 		WithActions(
-			box.Get(getCollection),
-			box.ActionPost(insert),
-			box.ActionPost(insertStream),     // todo: experimental!!
-			box.ActionPost(insertFullduplex), // todo: experimental!!
-			box.ActionPost(find),
-			box.ActionPost(remove),
-			box.ActionPost(patch),
-			box.ActionPost(dropCollection),
-			box.ActionPost(listIndexes),
-			box.ActionPost(createIndex),
-			box.ActionPost(dropIndex),
-			box.ActionPost(getIndex),
-			box.ActionPost(size),
-			box.ActionPost(setDefaults),
-		)
+			role(auth.RoleReader, box.Get(getCollection)),
+			role(auth.RoleWriter, write(box.ActionPost(insert))),
+			role(auth.RoleWriter, write(box.ActionPost(insertStream))),     // todo: experimental!!
+			role(auth.RoleWriter, write(box.ActionPost(insertFullduplex))), // todo: experimental!!
+			role(auth.RoleReader, box.ActionPost(find)),
+			role(auth.RoleWriter, write(box.ActionPost(remove))),
+			role(auth.RoleWriter, write(box.ActionPost(patch))),
+			role(auth.RoleAdmin, write(box.ActionPost(dropCollection))),
+			role(auth.RoleReader, box.ActionPost(listIndexes)),
+			role(auth.RoleAdmin, write(box.ActionPost(createIndex))),
+			role(auth.RoleAdmin, write(box.ActionPost(dropIndex))),
+			role(auth.RoleReader, box.ActionPost(getIndex)),
+			role(auth.RoleReader, box.ActionPost(size)),
+			role(auth.RoleAdmin, write(box.ActionPost(setDefaults))),
+			role(auth.RoleAdmin, audited(box.ActionPost(compact))),
+			role(auth.RoleAdmin, write(box.ActionPost(setDurability))),
+			role(auth.RoleAdmin, audited(box.ActionPost(rotateKey))),
+			role(auth.RoleAdmin, audited(box.ActionPost(retry))),
+			role(auth.RoleAdmin, audited(box.ActionPost(repair))),
+			role(auth.RoleReader, box.Action(watch)),
+			role(auth.RoleReader, box.Action(journal)),
+			role(auth.RoleReader, box.ActionPost(changes)),
+			role(auth.RoleWriter, write(box.ActionPost(push))),
+		).
+		WithAttribute(AttributeScoped, true)
 
 	v1.Resource("/collections/{collectionName}/documents/{documentId}").
 		WithActions(
-			box.Get(getDocument),
-		)
+			role(auth.RoleReader, box.Get(getDocument)),
+		).
+		WithAttribute(AttributeScoped, true)
 
 	return collections
 }
@@ -39,7 +39,7 @@ func traverse(requestBody []byte, col *collection.Collection, f func(row *collec
 
 		if hasFilter {
 			rowData := map[string]interface{}{}
-			json.Unmarshal(r.Payload, &rowData) // todo: handle error here?
+			json.Unmarshal(r.GetPayload(), &rowData) // todo: handle error here?
 
 			match, err := connor.Match(options.Filter, rowData)
 			if err != nil {
@@ -66,9 +66,9 @@ func traverse(requestBody []byte, col *collection.Collection, f func(row *collec
 		return nil
 	}
 
-	index, exists := col.Indexes[*options.Index]
+	index, exists := col.GetIndex(*options.Index)
 	if !exists {
-		return fmt.Errorf("index '%s' not found, available indexes %v", *options.Index, utils.GetKeys(col.Indexes))
+		return fmt.Errorf("index '%s' not found, available indexes %v", *options.Index, utils.GetKeys(col.GetIndexes()))
 	}
 
 	index.Traverse(requestBody, iterator)
@@ -78,12 +78,7 @@ func traverse(requestBody []byte, col *collection.Collection, f func(row *collec
 
 func traverseFullscan(col *collection.Collection, f func(row *collection.Row) bool) error {
 
-	for _, row := range col.Rows {
-		next := f(row)
-		if !next {
-			break
-		}
-	}
+	col.TraverseRows(f)
 
 	return nil
 }
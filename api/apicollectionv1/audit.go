@@ -0,0 +1,14 @@
+package apicollectionv1
+
+import (
+	"context"
+
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+// auditRow records the id of a changed document in the audit entry of the
+// request (if any)
+func auditRow(ctx context.Context, row *collection.Row) {
+	audit.AddDocuments(ctx, collection.DocumentKey(row.GetPayload()))
+}
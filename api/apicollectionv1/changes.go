@@ -0,0 +1,58 @@
+package apicollectionv1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+type changesInput struct {
+	Since int64 `json:"since"` // checkpoint of the previous page, zero for everything
+	Limit int   `json:"limit"`
+}
+
+// DefaultChangesLimit is the number of documents per page when no limit is given
+const DefaultChangesLimit = 1000
+
+// changes returns the documents inserted, updated or removed (tombstones) after
+// a checkpoint, for clients that keep an offline copy. A checkpoint older than
+// the removals forgotten by a compaction gets 410 Gone: the client must drop
+// its copy and start again from the beginning.
+func changes(ctx context.Context, w http.ResponseWriter, input *changesInput) (*collection.ChangesPage, error) {
+
+	s := GetServicer(ctx)
+	collectionName := box.GetUrlParameter(ctx, "collectionName")
+	col, err := s.GetCollection(collectionName)
+	if err == service.ErrorCollectionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	if input.Limit < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, fmt.Errorf("limit must be a positive integer")
+	}
+	if input.Limit == 0 {
+		input.Limit = DefaultChangesLimit
+	}
+
+	page, err := col.Changes(input.Since, input.Limit)
+	if errors.Is(err, collection.ErrChangesExpired) {
+		w.WriteHeader(http.StatusGone)
+		return nil, fmt.Errorf("checkpoint %d expired, start again from the beginning: %w", input.Since, err)
+	}
+	if err != nil {
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	return page, nil
+}
@@ -5,4 +5,10 @@ type CollectionResponse struct {
 	Total    int            `json:"total"`
 	Indexes  int            `json:"indexes"`
 	Defaults map[string]any `json:"defaults"`
+
+	Durability *durabilityBody `json:"durability,omitempty"`
+
+	// Only for quarantined collections
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
 }
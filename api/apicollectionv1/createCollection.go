@@ -2,45 +2,90 @@ package apicollectionv1
 
 import (
 	"context"
+	"errors"
 	"net/http"
 
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/database"
 	"github.com/fulldump/inceptiondb/service"
 )
 
 type createCollectionRequest struct {
-	Name     string         `json:"name"`
-	Defaults map[string]any `json:"defaults"`
+	Name       string          `json:"name"`
+	Defaults   map[string]any  `json:"defaults"`
+	Durability *durabilityBody `json:"durability"`
 }
 
-func newCollectionDefaults() map[string]any {
+// newCollectionDefaults returns the defaults of the database, if any
+func newCollectionDefaults(s service.Servicer) map[string]any {
+	if settings := s.DatabaseSettings(); settings != nil && settings.Defaults != nil {
+		defaults := make(map[string]any, len(settings.Defaults))
+		for k, v := range settings.Defaults {
+			defaults[k] = v
+		}
+		return defaults
+	}
 	return map[string]any{
 		"id": "uuid()",
 	}
 }
 
+// isLimitError is true if a limit of the database was reached, see
+// database.Settings
+func isLimitError(err error) bool {
+	return errors.Is(err, database.ErrCollectionLimit) || errors.Is(err, collection.ErrDocumentLimit)
+}
+
 func createCollection(ctx context.Context, w http.ResponseWriter, input *createCollectionRequest) (*CollectionResponse, error) {
 
 	s := GetServicer(ctx)
 
+	var durability *collection.DurabilityOptions
+	if input.Durability != nil {
+		var err error
+		durability, err = input.Durability.options()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, err
+		}
+	}
+
 	collection, err := s.CreateCollection(input.Name)
 	if err == service.ErrorCollectionAlreadyExists {
 		w.WriteHeader(http.StatusConflict)
 		return nil, err // todo: return custom error, with detailed description
 	}
+	if err == service.ErrorCollectionReserved {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, err
+	}
+	if isLimitError(err) {
+		w.WriteHeader(http.StatusForbidden)
+		return nil, err
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return nil, err // todo: wrap error?
 	}
 
 	if input.Defaults == nil {
-		input.Defaults = newCollectionDefaults()
+		input.Defaults = newCollectionDefaults(s)
 	}
 	collection.SetDefaults(input.Defaults)
 
+	if durability != nil {
+		err = collection.SetDurability(durability)
+		if err != nil {
+			return nil, err // todo: wrap error?
+		}
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	return &CollectionResponse{
 		Name:     input.Name,
-		Total:    len(collection.Rows),
-		Defaults: collection.Defaults,
+		Total:    collection.Len(),
+		Defaults: collection.GetDefaults(),
+
+		Durability: newDurabilityBody(collection.Durability()),
 	}, nil
 }
@@ -46,7 +46,7 @@ func createIndex(ctx context.Context, r *http.Request) (*listIndexesItem, error)
 		if err != nil {
 			return nil, err // todo: handle/wrap this properly
 		}
-		err = col.SetDefaults(newCollectionDefaults())
+		err = col.SetDefaults(newCollectionDefaults(s))
 		if err != nil {
 			return nil, err // todo: handle/wrap this properly
 		}
@@ -23,7 +23,7 @@ func dropIndex(ctx context.Context, w http.ResponseWriter, input *dropIndexReque
 		if err != nil {
 			return err // todo: handle/wrap this properly
 		}
-		err = col.SetDefaults(newCollectionDefaults())
+		err = col.SetDefaults(newCollectionDefaults(s))
 		if err != nil {
 			return err // todo: handle/wrap this properly
 		}
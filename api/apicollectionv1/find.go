@@ -34,7 +34,7 @@ func find(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	}
 
 	return traverse(requestBody, col, func(row *collection.Row) bool {
-		w.Write(row.Payload)
+		w.Write(row.GetPayload())
 		w.Write([]byte("\n"))
 		return true
 	})
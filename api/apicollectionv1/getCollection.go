@@ -21,11 +21,16 @@ func getCollection(ctx context.Context) (*CollectionResponse, error) {
 		// todo: wrap error
 		return nil, err
 	}
+	if err != nil {
+		return nil, err
+	}
 
 	return &CollectionResponse{
 		Name:     collectionName,
-		Total:    len(collection.Rows),
-		Indexes:  len(collection.Indexes),
-		Defaults: collection.Defaults,
+		Total:    collection.Len(),
+		Indexes:  len(collection.GetIndexes()),
+		Defaults: collection.GetDefaults(),
+
+		Durability: newDurabilityBody(collection.Durability()),
 	}, nil
 }
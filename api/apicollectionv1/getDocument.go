@@ -55,7 +55,7 @@ func getDocument(ctx context.Context) (*documentLookupResponse, error) {
 	}
 
 	document := map[string]any{}
-	if err := json.Unmarshal(row.Payload, &document); err != nil {
+	if err := json.Unmarshal(row.GetPayload(), &document); err != nil {
 		return nil, fmt.Errorf("decode document: %w", err)
 	}
 
@@ -77,7 +77,7 @@ func findRowByID(col *collection.Collection, documentID string) (*collection.Row
 		Value string `json:"value"`
 	}
 
-	for name, idx := range col.Indexes {
+	for name, idx := range col.GetIndexes() {
 		if idx == nil || idx.Index == nil {
 			continue
 		}
@@ -109,18 +109,21 @@ func findRowByID(col *collection.Collection, documentID string) (*collection.Row
 		}
 	}
 
-	for _, row := range col.Rows {
+	var found *collection.Row
+	col.TraverseRows(func(row *collection.Row) bool {
 		var item map[string]any
-		if err := json.Unmarshal(row.Payload, &item); err != nil {
-			continue
+		if err := json.Unmarshal(row.GetPayload(), &item); err != nil {
+			return true
 		}
 		value, exists := item["id"]
-		if !exists {
-			continue
-		}
-		if normalizeDocumentID(value) == normalizedID {
-			return row, &documentLookupSource{Type: "fullscan"}, nil
+		if exists && normalizeDocumentID(value) == normalizedID {
+			found = row
+			return false
 		}
+		return true
+	})
+	if found != nil {
+		return found, &documentLookupSource{Type: "fullscan"}, nil
 	}
 
 	return nil, nil, nil
@@ -22,7 +22,7 @@ func getIndex(ctx context.Context, input getIndexInput) (*listIndexesItem, error
 	}
 
 	name := input.Name
-	index, found := current.Indexes[name]
+	index, found := current.GetIndex(name)
 
 	if !found {
 		box.GetResponse(ctx).WriteHeader(http.StatusNotFound)
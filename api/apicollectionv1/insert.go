@@ -3,8 +3,8 @@ package apicollectionv1
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 
 	"github.com/fulldump/box"
@@ -28,7 +28,7 @@ func insert(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		if err != nil {
 			return err // todo: handle/wrap this properly
 		}
-		err = collection.SetDefaults(newCollectionDefaults())
+		err = collection.SetDefaults(newCollectionDefaults(s))
 		if err != nil {
 			return err // todo: handle/wrap this properly
 		}
@@ -71,7 +71,7 @@ func insert(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		}
 		if err != nil {
 			// TODO: handle error properly
-			fmt.Println("ERROR:", err.Error())
+			slog.WarnContext(ctx, "insert: decode", "error", err)
 			if i == 0 {
 				w.WriteHeader(http.StatusBadRequest)
 			}
@@ -80,12 +80,16 @@ func insert(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		row, err := collection.Insert(item)
 		if err != nil {
 			// TODO: handle error properly
-			if i == 0 {
+			if i == 0 && isLimitError(err) {
+				w.WriteHeader(http.StatusForbidden)
+			} else if i == 0 {
 				w.WriteHeader(http.StatusConflict)
 			}
 			return err
 		}
 
+		auditRow(ctx, row)
+
 		if i == 0 {
 			w.WriteHeader(http.StatusCreated)
 		}
@@ -100,7 +104,7 @@ func insert(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		// )
 
 		// ALT 3
-		w.Write(row.Payload)
+		w.Write(row.GetPayload())
 		w.Write([]byte("\n"))
 
 		// ALT 4
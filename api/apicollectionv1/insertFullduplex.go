@@ -3,8 +3,8 @@ package apicollectionv1
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 
 	"github.com/fulldump/box"
@@ -17,7 +17,7 @@ func insertFullduplex(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	wc := http.NewResponseController(w)
 	wcerr := wc.EnableFullDuplex()
 	if wcerr != nil {
-		fmt.Println("ERRRRRR", wcerr.Error())
+		slog.DebugContext(ctx, "insertFullduplex: enable full duplex", "error", wcerr)
 	}
 
 	s := GetServicer(ctx)
@@ -35,16 +35,12 @@ func insertFullduplex(ctx context.Context, w http.ResponseWriter, r *http.Reques
 
 	flusher, ok := w.(http.Flusher)
 	_ = flusher
-	if ok {
-		fmt.Println("FLUSHER!")
-	} else {
-		fmt.Println("NO FLUSHER")
-	}
+	slog.DebugContext(ctx, "insertFullduplex", "flusher", ok)
 
 	c := 0
 
 	defer func() {
-		fmt.Println("received for insert:", c)
+		slog.DebugContext(ctx, "insertFullduplex: received", "documents", c)
 	}()
 
 	for {
@@ -56,25 +52,26 @@ func insertFullduplex(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		}
 		if err != nil {
 			// TODO: handle error properly
-			fmt.Println("ERROR:", err.Error())
+			slog.WarnContext(ctx, "insertFullduplex: decode", "error", err)
 			// w.WriteHeader(http.StatusBadRequest)
 			return err
 		}
-		_, err = collection.Insert(item)
+		row, err := collection.Insert(item)
 		if err != nil {
 			// TODO: handle error properly
 			w.WriteHeader(http.StatusConflict)
 			return err
 		}
+		auditRow(ctx, row)
 		c++
-		// fmt.Println("item inserted")
+		// slog.DebugContext(ctx, "item inserted")
 		if ok {
 			// flusher.Flush()
 		}
 
 		err = jsonWriter.Encode(item)
 		if err != nil {
-			fmt.Println("ERROR:", err.Error())
+			slog.WarnContext(ctx, "insertFullduplex: encode", "error", err)
 		}
 	}
 
@@ -3,8 +3,8 @@ package apicollectionv1
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 
@@ -49,12 +49,13 @@ func insertStream(ctx context.Context, w http.ResponseWriter, r *http.Request) e
 			}
 			if err != nil {
 				// TODO: handle error properly
-				fmt.Println("ERROR:", err.Error())
+				slog.WarnContext(ctx, "insertStream: decode", "error", err)
 				// w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			_, err = collection.Insert(item)
+			row, err := collection.Insert(item)
 			if err == nil {
+				auditRow(ctx, row)
 				jsonWriter.Encode(item)
 			} else {
 				// TODO: handle error properly
@@ -0,0 +1,69 @@
+package apicollectionv1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fulldump/inceptiondb/replication"
+)
+
+// journal streams the raw journal commands to followers as JSON lines, see
+// package replication. The starting point is the same as in watch.
+func journal(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	col, watcher, err := newWatcher(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+
+	heartbeat := func() error {
+		err := e.Encode(&replication.Entry{
+			Position: watcher.Position(),
+			Head:     col.Head(),
+		})
+		rc.Flush()
+		return err
+	}
+
+	err = heartbeat()
+	if err != nil {
+		return nil // client is gone
+	}
+
+	for {
+		next, cancel := context.WithTimeout(ctx, replication.HeartbeatInterval)
+		change, err := watcher.Next(next)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			err = heartbeat()
+			if err != nil {
+				return nil // client is gone
+			}
+			continue
+		}
+		if err != nil {
+			return nil // the follower will reconnect
+		}
+
+		command := *change.Command
+		command.Checksum = 0
+		command.Length = 0
+		err = e.Encode(&replication.Entry{
+			Position: change.Position,
+			Command:  &command,
+		})
+		if err != nil {
+			return nil // client is gone
+		}
+		rc.Flush()
+	}
+}
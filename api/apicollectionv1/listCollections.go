@@ -3,6 +3,8 @@ package apicollectionv1
 import (
 	"context"
 	"net/http"
+
+	"github.com/fulldump/inceptiondb/database"
 )
 
 func listCollections(ctx context.Context, w http.ResponseWriter) ([]*CollectionResponse, error) {
@@ -13,9 +15,18 @@ func listCollections(ctx context.Context, w http.ResponseWriter) ([]*CollectionR
 	for name, collection := range s.ListCollections() {
 		response = append(response, &CollectionResponse{
 			Name:     name,
-			Total:    len(collection.Rows),
-			Indexes:  len(collection.Indexes),
-			Defaults: collection.Defaults,
+			Total:    collection.Len(),
+			Indexes:  len(collection.GetIndexes()),
+			Defaults: collection.GetDefaults(),
+
+			Durability: newDurabilityBody(collection.Durability()),
+		})
+	}
+	for _, quarantined := range s.ListQuarantined() {
+		response = append(response, &CollectionResponse{
+			Name:   quarantined.Name,
+			Status: database.CollectionQuarantined,
+			Error:  quarantined.Error,
 		})
 	}
 	return response, nil
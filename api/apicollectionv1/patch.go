@@ -43,7 +43,7 @@ func patch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		if hasFilter {
 
 			rowData := map[string]interface{}{}
-			json.Unmarshal(row.Payload, &rowData) // todo: handle error here?
+			json.Unmarshal(row.GetPayload(), &rowData) // todo: handle error here?
 
 			match, err := connor.Match(patch.Filter, rowData)
 			if err != nil {
@@ -62,8 +62,9 @@ func patch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 			// return err
 			return true // todo: OR return false?
 		}
+		auditRow(ctx, row)
 
-		e.Encode(row.Payload) // todo: handle err?
+		e.Encode(row.GetPayload()) // todo: handle err?
 
 		return true
 	})
@@ -0,0 +1,131 @@
+package apicollectionv1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+const (
+	PushInserted = "inserted"
+	PushUpdated  = "updated"
+	PushDeleted  = "deleted"
+	PushNotFound = "not_found"
+	PushError    = "error"
+)
+
+// pushInput takes the same changes returned by :changes
+type pushInput struct {
+	Changes []*collection.DocumentChange `json:"changes"`
+}
+
+type pushResult struct {
+	Id     string `json:"id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// push applies a batch of changes made by an offline client: upserts replace
+// the document with the same id (or insert it) and deletes remove it. Every
+// change is applied on its own, the result of each one is returned in the same
+// order.
+func push(ctx context.Context, w http.ResponseWriter, input *pushInput) ([]*pushResult, error) {
+
+	s := GetServicer(ctx)
+	collectionName := box.GetUrlParameter(ctx, "collectionName")
+	col, err := s.GetCollection(collectionName)
+	if err == service.ErrorCollectionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	results := make([]*pushResult, 0, len(input.Changes))
+	for _, change := range input.Changes {
+		result := &pushResult{Id: change.Id}
+		err := pushChange(col, change, result)
+		if err != nil {
+			result.Status = PushError
+			result.Error = err.Error()
+		} else if result.Status != PushNotFound {
+			audit.AddDocuments(ctx, result.Id)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+func pushChange(col *collection.Collection, change *collection.DocumentChange, result *pushResult) error {
+
+	switch change.Type {
+	case collection.ChangeUpsert:
+		document := map[string]any{}
+		err := json.Unmarshal(change.Document, &document)
+		if err != nil {
+			return fmt.Errorf("document must be an object: %w", err)
+		}
+		delete(document, collection.VersionsField) // the server ones are kept
+
+		var row *collection.Row
+		if id, exists := document["id"]; exists {
+			result.Id = normalizeDocumentID(id)
+			row, _, err = findRowByID(col, result.Id)
+			if err != nil {
+				return err
+			}
+		}
+		if row == nil {
+			row, err = col.Insert(document)
+			if err != nil {
+				return err
+			}
+			inserted := struct{ Id any }{}
+			json.Unmarshal(row.GetPayload(), &inserted)
+			if inserted.Id != nil {
+				result.Id = normalizeDocumentID(inserted.Id)
+			}
+			result.Status = PushInserted
+			return nil
+		}
+
+		row.PatchMutex.Lock()
+		defer row.PatchMutex.Unlock()
+		err = col.Replace(row, document)
+		if err != nil {
+			return err
+		}
+		result.Status = PushUpdated
+		return nil
+
+	case collection.ChangeDelete:
+		if change.Id == "" {
+			return fmt.Errorf("id is required")
+		}
+		row, _, err := findRowByID(col, change.Id)
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			result.Status = PushNotFound
+			return nil
+		}
+		err = col.Remove(row)
+		if err != nil {
+			return err
+		}
+		result.Status = PushDeleted
+		return nil
+	}
+
+	return fmt.Errorf("unknown change type '%s'", change.Type)
+}
@@ -43,8 +43,9 @@ func remove(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 			result = err
 			return false
 		}
+		auditRow(ctx, row)
 
-		w.Write(row.Payload)
+		w.Write(row.GetPayload())
 		w.Write([]byte("\n"))
 		return true
 	})
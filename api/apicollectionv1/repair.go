@@ -0,0 +1,33 @@
+package apicollectionv1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func repair(ctx context.Context, w http.ResponseWriter) (*collection.RepairStats, error) {
+
+	s := GetServicer(ctx)
+	collectionName := box.GetUrlParameter(ctx, "collectionName")
+
+	stats, err := s.RepairCollection(collectionName)
+	if err == service.ErrorCollectionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, err
+	}
+	if err == service.ErrorCollectionNotQuarantined {
+		w.WriteHeader(http.StatusConflict)
+		return nil, err
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,32 @@
+package apicollectionv1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func retry(ctx context.Context, w http.ResponseWriter) (*CollectionResponse, error) {
+
+	s := GetServicer(ctx)
+	collectionName := box.GetUrlParameter(ctx, "collectionName")
+
+	err := s.RetryCollection(collectionName)
+	if err == service.ErrorCollectionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, err
+	}
+	if err == service.ErrorCollectionNotQuarantined {
+		w.WriteHeader(http.StatusConflict)
+		return nil, err
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	return getCollection(ctx)
+}
@@ -0,0 +1,36 @@
+package apicollectionv1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func rotateKey(ctx context.Context, w http.ResponseWriter) (*collection.KeyRotationStats, error) {
+
+	s := GetServicer(ctx)
+	collectionName := box.GetUrlParameter(ctx, "collectionName")
+	col, err := s.GetCollection(collectionName)
+	if err == service.ErrorCollectionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	stats, err := col.RotateKey()
+	if err == collection.ErrCompactionInProgress {
+		w.WriteHeader(http.StatusConflict)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	return stats, nil
+}
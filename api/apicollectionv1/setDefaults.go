@@ -22,7 +22,7 @@ func setDefaults(ctx context.Context, w http.ResponseWriter, r *http.Request) er
 		if err != nil {
 			return err // todo: handle/wrap this properly
 		}
-		err = col.SetDefaults(newCollectionDefaults())
+		err = col.SetDefaults(newCollectionDefaults(s))
 		if err != nil {
 			return err // todo: handle/wrap this properly
 		}
@@ -31,7 +31,10 @@ func setDefaults(ctx context.Context, w http.ResponseWriter, r *http.Request) er
 		return err // todo: handle/wrap this properly
 	}
 
-	defaults := col.Defaults
+	defaults := map[string]any{}
+	for k, v := range col.GetDefaults() {
+		defaults[k] = v
+	}
 
 	err = json.NewDecoder(r.Body).Decode(&defaults)
 	if err != nil {
@@ -53,7 +56,7 @@ func setDefaults(ctx context.Context, w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 
-	err = json.NewEncoder(w).Encode(col.Defaults)
+	err = json.NewEncoder(w).Encode(col.GetDefaults())
 	if err != nil {
 		return err // todo: handle/wrap this properly
 	}
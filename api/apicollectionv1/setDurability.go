@@ -0,0 +1,77 @@
+package apicollectionv1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+type durabilityBody struct {
+	Mode     string `json:"mode"`
+	Interval string `json:"interval,omitempty"`
+}
+
+func newDurabilityBody(d *collection.DurabilityOptions) *durabilityBody {
+	if d == nil {
+		return nil
+	}
+	result := &durabilityBody{
+		Mode: d.Mode,
+	}
+	if d.Mode == collection.DurabilityInterval && d.Interval > 0 {
+		result.Interval = d.Interval.String()
+	}
+	return result
+}
+
+func (d *durabilityBody) options() (*collection.DurabilityOptions, error) {
+	result := &collection.DurabilityOptions{
+		Mode: d.Mode,
+	}
+	if d.Interval != "" {
+		interval, err := time.ParseDuration(d.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("durability interval: %w", err)
+		}
+		result.Interval = interval
+	}
+	return result, result.Validate()
+}
+
+// setDurability overrides the durability policy of a collection. An empty mode
+// restores the server default.
+func setDurability(ctx context.Context, w http.ResponseWriter, input *durabilityBody) (*durabilityBody, error) {
+
+	s := GetServicer(ctx)
+	collectionName := box.GetUrlParameter(ctx, "collectionName")
+	col, err := s.GetCollection(collectionName)
+	if err == service.ErrorCollectionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err // todo: handle/wrap this properly
+	}
+
+	var durability *collection.DurabilityOptions
+	if input.Mode != "" {
+		durability, err = input.options()
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, err
+		}
+	}
+
+	err = col.SetDurability(durability)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDurabilityBody(col.GetDurability()), nil
+}
@@ -2,10 +2,10 @@ package apicollectionv1
 
 import (
 	"context"
-	"os"
 
 	"github.com/fulldump/box"
 
+	"github.com/fulldump/inceptiondb/collection"
 	"github.com/fulldump/inceptiondb/utils"
 )
 
@@ -22,17 +22,19 @@ func size(ctx context.Context) (interface{}, error) {
 	result := map[string]interface{}{}
 
 	// Data memory
-	memory := utils.SizeOf(col.Rows)
+	rows := []*collection.Row{}
+	col.TraverseRows(func(row *collection.Row) bool {
+		rows = append(rows, row)
+		return true
+	})
+	memory := utils.SizeOf(rows)
 	result["memory"] = memory
 
 	// Disk
-	info, err := os.Stat(col.Filename)
-	if err == nil {
-		result["disk"] = info.Size()
-	}
+	result["disk"] = col.JournalSize()
 
 	// Indexes
-	for name, index := range col.Indexes {
+	for name, index := range col.GetIndexes() {
 		result["index."+name] = utils.SizeOf(index) - memory
 	}
 
@@ -0,0 +1,155 @@
+package apicollectionv1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+// watchKeepAlive is the time between comments sent to keep idle connections
+// open through proxies
+var watchKeepAlive = 15 * time.Second
+
+type watchEvent struct {
+	Position  int64           `json:"position"`
+	Uuid      string          `json:"uuid"`
+	Timestamp int64           `json:"timestamp"`
+	RowId     int64           `json:"row_id,omitempty"`
+	Document  json.RawMessage `json:"document,omitempty"` // insert
+	Diff      json.RawMessage `json:"diff,omitempty"`     // patch
+	Index     json.RawMessage `json:"index,omitempty"`    // index and drop_index
+}
+
+// watch streams the changes of a collection as Server-Sent Events. It starts
+// after the query parameter after_uuid, after_position or the Last-Event-ID
+// header (the uuid of the last event received), by default only new changes
+// are sent.
+func watch(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+	_, watcher, err := newWatcher(ctx, w, r)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	for {
+		next, cancel := context.WithTimeout(ctx, watchKeepAlive)
+		change, err := watcher.Next(next)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			rc.Flush()
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil // client is gone
+		}
+		if err != nil {
+			data, _ := json.Marshal(map[string]string{"message": err.Error()})
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+			rc.Flush()
+			return nil
+		}
+
+		name, event := newWatchEvent(change)
+		if event == nil {
+			continue
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.Uuid, name, data)
+		if err != nil {
+			return nil // client is gone
+		}
+		rc.Flush()
+	}
+}
+
+// newWatchEvent returns nil for commands that are not sent to watchers
+func newWatchEvent(change *collection.Change) (string, *watchEvent) {
+
+	command := change.Command
+	event := &watchEvent{
+		Position:  change.Position,
+		Uuid:      command.Uuid,
+		Timestamp: command.Timestamp,
+		RowId:     command.RowId,
+	}
+
+	switch command.Name {
+	case "insert":
+		event.Document = command.Payload
+	case "patch":
+		params := struct {
+			Diff json.RawMessage `json:"diff"`
+		}{}
+		json.Unmarshal(command.Payload, &params)
+		event.Diff = params.Diff
+	case "remove":
+	case "index", "drop_index":
+		event.Index = command.Payload
+	default:
+		return "", nil
+	}
+
+	return command.Name, event
+}
+
+// newWatcher opens a watcher on the collection of the url, starting at the
+// point given by the request (see watch)
+func newWatcher(ctx context.Context, w http.ResponseWriter, r *http.Request) (*collection.Collection, *collection.Watcher, error) {
+
+	s := GetServicer(ctx)
+	collectionName := box.GetUrlParameter(ctx, "collectionName")
+	col, err := s.GetCollection(collectionName)
+	if err == service.ErrorCollectionNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err // todo: handle/wrap this properly
+	}
+
+	options := &collection.WatchOptions{
+		AfterUuid: r.URL.Query().Get("after_uuid"),
+	}
+	if options.AfterUuid == "" {
+		options.AfterUuid = r.Header.Get("Last-Event-ID")
+	}
+	if position := r.URL.Query().Get("after_position"); position != "" && options.AfterUuid == "" {
+		options.Replay = true
+		options.AfterPosition, err = strconv.ParseInt(position, 10, 64)
+		if err != nil || options.AfterPosition < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, nil, fmt.Errorf("after_position must be a positive integer")
+		}
+	}
+
+	watcher, err := col.Watch(options)
+	if errors.Is(err, collection.ErrWatchPositionNotFound) {
+		w.WriteHeader(http.StatusGone) // probably compacted
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err // todo: handle/wrap this properly
+	}
+
+	return col, watcher, nil
+}
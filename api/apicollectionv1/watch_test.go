@@ -0,0 +1,51 @@
+package apicollectionv1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+func TestNewWatchEvent(t *testing.T) {
+
+	col := newTestCollection(t)
+
+	watcher, err := col.Watch(nil)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer watcher.Close()
+
+	row, _ := col.Insert(map[string]any{"id": "doc-1"})
+	col.Patch(row, map[string]any{"name": "Alice"})
+	col.Index("by-id", &collection.IndexMapOptions{Field: "id"})
+	col.Remove(row)
+
+	expected := []struct {
+		name  string
+		field string
+	}{
+		{"insert", `{"id":"doc-1"}`},
+		{"patch", `{"name":"Alice"}`},
+		{"index", `{"name":"by-id","type":"map","options":{"field":"id","sparse":false}}`},
+		{"remove", ``},
+	}
+	for i, e := range expected {
+		change, err := watcher.Next(context.Background())
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		name, event := newWatchEvent(change)
+		if name != e.name {
+			t.Fatalf("event %d: expected '%s', got '%s'", i, e.name, name)
+		}
+		field := string(event.Document) + string(event.Diff) + string(event.Index)
+		if field != e.field {
+			t.Fatalf("event %d: unexpected content %s", i, field)
+		}
+		if name != "index" && event.RowId != row.Id {
+			t.Fatalf("event %d: expected row %d, got %d", i, row.Id, event.RowId)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+// KeyResponse never includes the hash, and the secret only on creation
+type KeyResponse struct {
+	Id        string       `json:"id"`
+	Name      string       `json:"name"`
+	Subject   string       `json:"subject,omitempty"`
+	Admin     bool         `json:"admin,omitempty"`
+	Scopes    []auth.Scope `json:"scopes"`
+	CreatedAt time.Time    `json:"created_at"`
+	Key       string       `json:"key,omitempty"`
+}
+
+type createKeyRequest struct {
+	Name    string       `json:"name"`
+	Subject string       `json:"subject"` // of a client certificate, the key has no secret
+	Admin   bool         `json:"admin"`
+	Scopes  []auth.Scope `json:"scopes"`
+}
+
+func newKeyResponse(key *auth.Key) *KeyResponse {
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []auth.Scope{}
+	}
+	return &KeyResponse{
+		Id:        key.Id,
+		Name:      key.Name,
+		Subject:   key.Subject,
+		Admin:     key.Admin,
+		Scopes:    scopes,
+		CreatedAt: key.CreatedAt,
+	}
+}
+
+func listKeys(s service.Servicer) any {
+	return func(w http.ResponseWriter) ([]*KeyResponse, error) {
+		keys, err := s.ListKeys()
+		if err == service.ErrorAuthDisabled {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result := make([]*KeyResponse, len(keys))
+		for i, key := range keys {
+			result[i] = newKeyResponse(key)
+		}
+		return result, nil
+	}
+}
+
+// createKey returns the secret of the new key, it cannot be recovered later
+func createKey(s service.Servicer) any {
+	return func(w http.ResponseWriter, input *createKeyRequest) (*KeyResponse, error) {
+		key, secret, err := s.CreateKey(input.Name, input.Subject, input.Admin, input.Scopes)
+		if err == service.ErrorAuthDisabled {
+			w.WriteHeader(http.StatusNotFound)
+			return nil, err
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, err
+		}
+		w.WriteHeader(http.StatusCreated)
+		result := newKeyResponse(key)
+		result.Key = secret
+		return result, nil
+	}
+}
+
+func getKey(s service.Servicer) any {
+	return func(ctx context.Context, w http.ResponseWriter) (*KeyResponse, error) {
+		key, err := s.GetKey(box.GetUrlParameter(ctx, "keyId"))
+		if err == service.ErrorAuthDisabled || err == service.ErrorKeyNotFound {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newKeyResponse(key), nil
+	}
+}
+
+func revokeKey(s service.Servicer) any {
+	return func(ctx context.Context, w http.ResponseWriter) error {
+		err := s.RevokeKey(box.GetUrlParameter(ctx, "keyId"))
+		if err == service.ErrorAuthDisabled || err == service.ErrorKeyNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+// defaultAuditLimit is used when the query has no limit
+const defaultAuditLimit = 1000
+
+// queryAudit returns the audit entries matching the query parameters since,
+// until (RFC3339), principal, action, database, collection, document and
+// limit, newest first
+func queryAudit(s service.Servicer) any {
+	return func(w http.ResponseWriter, r *http.Request) ([]*audit.Entry, error) {
+
+		query := r.URL.Query()
+		filter := &audit.Filter{
+			Principal:  query.Get("principal"),
+			Action:     query.Get("action"),
+			Database:   query.Get("database"),
+			Collection: query.Get("collection"),
+			Document:   query.Get("document"),
+			Limit:      defaultAuditLimit,
+		}
+
+		var err error
+		for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			value := query.Get(param)
+			if value == "" {
+				continue
+			}
+			*t, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return nil, fmt.Errorf("%s must be a RFC3339 time", param)
+			}
+		}
+		if limit := query.Get("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil || filter.Limit <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return nil, fmt.Errorf("limit must be a positive integer")
+			}
+		}
+
+		entries, err := s.QueryAudit(filter)
+		if err == service.ErrorAuditDisabled {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+}
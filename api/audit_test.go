@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/fulldump/apitest"
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func TestAudit(t *testing.T) {
+
+	// Setup
+	db := database.NewDatabase(&database.Config{Dir: t.TempDir()})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+	l, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	biff.AssertNil(err)
+	defer l.Close()
+	s := service.NewService(db)
+	keys := auth.NewKeys(db, "admin-secret")
+	s.SetKeys(keys)
+	s.SetAudit(l)
+	b := Build(s, "", "test")
+	b.WithInterceptors(
+		InterceptorRequestId,
+		InterceptorAudit(l),
+		RecoverFromPanic,
+		PrettyErrorInterceptor,
+		InterceptorAuth(keys),
+	)
+	a := apitest.NewWithHandler(b)
+
+	// Run
+	a.Request("POST", "/v1/collections").
+		WithHeader("X-Api-Key", "admin-secret").
+		WithBodyJson(service.JSON{"name": "users"}).Do()
+	resp := a.Request("POST", "/v1/collections/users:insert").
+		WithHeader("X-Api-Key", "admin-secret").
+		WithHeader("X-Request-Id", "req-1").
+		WithHeader("X-Forwarded-For", "10.6.6.6, 10.0.0.1").
+		WithBodyJson(service.JSON{"id": "u1"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+	biff.AssertEqual(resp.Header.Get("X-Request-Id"), "req-1")
+	a.Request("POST", "/v1/collections/users:remove").
+		WithHeader("X-Api-Key", "admin-secret").
+		WithBodyJson(service.JSON{"filter": service.JSON{"id": "u1"}}).Do()
+	a.Request("POST", "/v1/collections/users:insert").
+		WithBodyJson(service.JSON{"id": "u2"}).Do() // rejected
+	a.Request("POST", "/v1/collections/users:compact").
+		WithHeader("X-Api-Key", "admin-secret").Do()
+	a.Request("POST", "/v1/collections/users:find").
+		WithHeader("X-Api-Key", "admin-secret").
+		WithBodyJson(service.JSON{}).Do() // not audited
+
+	// Check
+	resp = a.Request("GET", "/v1/audit?collection=users").WithHeader("X-Api-Key", "admin-secret").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+	entries := resp.BodyJson().([]any)
+	biff.AssertEqual(len(entries), 4) // newest first
+
+	compact := entries[0].(map[string]any)
+	biff.AssertEqual(compact["action"], "compact")
+	biff.AssertEqual(compact["principal"], "admin")
+
+	rejected := entries[1].(map[string]any)
+	biff.AssertEqual(rejected["status"], json.Number("401"))
+	biff.AssertNil(rejected["principal"])
+	biff.AssertNil(rejected["documents"])
+
+	remove := entries[2].(map[string]any)
+	biff.AssertEqual(remove["action"], "remove")
+	biff.AssertEqual(remove["documents"], []any{"u1"})
+
+	insert := entries[3].(map[string]any)
+	biff.AssertEqual(insert["request_id"], "req-1")
+	biff.AssertEqual(insert["remote_addr"], "127.0.0.1") // not the one sent by the client
+	biff.AssertEqual(insert["forwarded_for"], "10.6.6.6, 10.0.0.1")
+	biff.AssertEqual(insert["principal"], "admin")
+	biff.AssertEqual(insert["action"], "insert")
+	biff.AssertEqual(insert["database"], database.DefaultDatabase)
+	biff.AssertEqual(insert["documents"], []any{"u1"})
+	biff.AssertEqual(insert["status"], json.Number("201"))
+
+	resp = a.Request("GET", "/v1/audit?document=u1&limit=1").WithHeader("X-Api-Key", "admin-secret").Do()
+	entries = resp.BodyJson().([]any)
+	biff.AssertEqual(len(entries), 1)
+	biff.AssertEqual(entries[0].(map[string]any)["action"], "remove")
+
+	resp = a.Request("GET", "/v1/audit?since=yesterday").WithHeader("X-Api-Key", "admin-secret").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusBadRequest)
+}
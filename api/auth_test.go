@@ -0,0 +1,198 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fulldump/apitest"
+	"github.com/fulldump/biff"
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func TestAuth(t *testing.T) {
+
+	// Setup
+	db, s, b := newTestInstance(t)
+	defer db.Stop()
+	keys := auth.NewKeys(db, "admin-secret")
+	s.SetKeys(keys)
+	b.WithInterceptors(InterceptorAuth(keys))
+	a := apitest.NewWithHandler(b)
+
+	// Check: no key
+	resp := a.Request("GET", "/v1/collections").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusUnauthorized)
+	biff.AssertEqual(resp.Header.Get("WWW-Authenticate"), `Bearer realm="inceptiondb"`)
+
+	resp = a.Request("GET", "/release").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+
+	// Run: the admin creates a scoped key
+	resp = a.Request("POST", "/v1/apikeys").
+		WithHeader("Authorization", "Bearer admin-secret").
+		WithBodyJson(service.JSON{
+			"name":   "ci",
+			"scopes": []service.JSON{{"database": "default", "collection": "users"}},
+		}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+	created := resp.BodyJson().(service.JSON)
+	secret := created["key"].(string)
+
+	// Check: scopes
+	resp = a.Request("POST", "/v1/collections/users:insert").
+		WithHeader("X-Api-Key", secret).
+		WithBodyJson(service.JSON{"id": "1"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+
+	resp = a.Request("POST", "/v1/collections/orders:insert").
+		WithHeader("X-Api-Key", secret).
+		WithBodyJson(service.JSON{"id": "1"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+
+	resp = a.Request("GET", "/v1/apikeys").WithHeader("X-Api-Key", secret).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+
+	// Check: the keys collection is hidden
+	resp = a.Request("GET", "/v1/collections").WithHeader("X-Api-Key", "admin-secret").Do()
+	biff.AssertEqual(len(resp.BodyJson().([]any)), 1)
+
+	resp = a.Request("GET", "/v1/collections/"+auth.KeysCollection).WithHeader("X-Api-Key", "admin-secret").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusNotFound)
+
+	// Check: revoke
+	resp = a.Request("POST", "/v1/apikeys/"+created["id"].(string)+":revoke").WithHeader("X-Api-Key", "admin-secret").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusNoContent)
+
+	resp = a.Request("POST", "/v1/collections/users:find").WithHeader("X-Api-Key", secret).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusUnauthorized)
+}
+
+func TestAuth_Roles(t *testing.T) {
+
+	// Setup
+	db, s, b := newTestInstance(t)
+	defer db.Stop()
+	keys := auth.NewKeys(db, "admin-secret")
+	s.SetKeys(keys)
+	b.WithInterceptors(InterceptorAuth(keys))
+	a := apitest.NewWithHandler(b)
+	a.Request("POST", "/v1/collections/users:insert").
+		WithHeader("X-Api-Key", "admin-secret").
+		WithBodyJson(service.JSON{"id": "1"}).Do()
+
+	newKey := func(role string) string {
+		_, secret, err := keys.Create(role, false, []auth.Scope{{Database: "default", Collection: "users", Role: role}})
+		biff.AssertNil(err)
+		return secret
+	}
+	reader := newKey(auth.RoleReader)
+	writer := newKey(auth.RoleWriter)
+
+	// Check: reader
+	resp := a.Request("POST", "/v1/collections/users:find").
+		WithHeader("X-Api-Key", reader).
+		WithBodyJson(service.JSON{}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+
+	resp = a.Request("GET", "/v1/collections/users/documents/1").WithHeader("X-Api-Key", reader).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+
+	resp = a.Request("POST", "/v1/collections/users:insert").
+		WithHeader("X-Api-Key", reader).
+		WithBodyJson(service.JSON{"id": "2"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+
+	// Check: writer
+	resp = a.Request("POST", "/v1/collections/users:insert").
+		WithHeader("X-Api-Key", writer).
+		WithBodyJson(service.JSON{"id": "2"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+
+	resp = a.Request("POST", "/v1/collections/users:createIndex").
+		WithHeader("X-Api-Key", writer).
+		WithBodyJson(service.JSON{"name": "by-id", "type": "map", "field": "id"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+
+	resp = a.Request("POST", "/v1/collections/users:dropCollection").WithHeader("X-Api-Key", writer).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+
+	// Check: admin
+	resp = a.Request("POST", "/v1/collections/users:dropCollection").WithHeader("X-Api-Key", "admin-secret").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusNoContent)
+}
+
+func TestAuth_ClientCertificate(t *testing.T) {
+
+	// Setup
+	db, s, b := newTestInstance(t)
+	defer db.Stop()
+	keys := auth.NewKeys(db, "admin-secret")
+	s.SetKeys(keys)
+	b.WithInterceptors(InterceptorAuth(keys))
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, _ := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDer)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientDer, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "billing", Organization: []string{"Acme"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &clientKey.PublicKey, caKey)
+
+	server := httptest.NewUnstartedServer(box.Box2Http(b))
+	server.TLS = &tls.Config{
+		ClientCAs:  x509.NewCertPool(),
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	server.TLS.ClientCAs.AddCert(ca)
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{clientDer},
+		PrivateKey:  clientKey,
+	}}
+	insert := func() int {
+		resp, err := client.Post(server.URL+"/v1/collections/users:insert", "application/json", strings.NewReader(`{"id":"1"}`))
+		biff.AssertNil(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Check: unknown subject
+	biff.AssertEqual(insert(), http.StatusUnauthorized)
+
+	// Run
+	_, _, err := s.CreateKey("billing", "CN=billing,O=Acme", false, []auth.Scope{{Database: "default", Collection: "users", Role: auth.RoleWriter}})
+	biff.AssertNil(err)
+
+	// Check
+	biff.AssertEqual(insert(), http.StatusCreated)
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/fulldump/inceptiondb/service"
+)
+
+// backup streams a tar archive, see database.Backup
+func backup(s service.Servicer) any {
+	return func(ctx context.Context, w http.ResponseWriter) error {
+
+		filename := fmt.Sprintf("inceptiondb-%s.tar", time.Now().UTC().Format("20060102T150405Z"))
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+		archive := &writeCounter{ResponseWriter: w}
+		_, err := s.Backup(archive)
+		if err != nil && archive.written == 0 {
+			return err
+		}
+		if err != nil {
+			// the archive is already half sent, abort the connection so the
+			// client does not take it as complete
+			slog.ErrorContext(ctx, "backup", "error", err)
+			panic(http.ErrAbortHandler)
+		}
+
+		return nil
+	}
+}
+
+type writeCounter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
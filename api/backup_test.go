@@ -0,0 +1,48 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fulldump/biff"
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+type failingBackup struct {
+	service.Servicer
+}
+
+func (f *failingBackup) Backup(w io.Writer) (*database.BackupManifest, error) {
+	w.Write([]byte("partial archive"))
+	return nil, errors.New("disk failure")
+}
+
+func TestBackup_FailsMidStream(t *testing.T) {
+
+	// Setup
+	db, s, _ := newTestInstance(t)
+	defer db.Stop()
+	b := Build(&failingBackup{Servicer: s}, "", "test")
+	b.WithInterceptors(
+		RecoverFromPanic,
+		PrettyErrorInterceptor,
+	)
+	server := httptest.NewServer(box.Box2Http(b))
+	defer server.Close()
+
+	// Run
+	resp, err := http.Post(server.URL+"/v1/backup", "", nil)
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	// Check: the client cannot take the archive as complete
+	biff.AssertNotNil(err)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fulldump/inceptiondb/cluster"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func clusterStatus(s service.Servicer) any {
+	return func(w http.ResponseWriter) (*cluster.Status, error) {
+		status, err := s.ClusterStatus()
+		if err == service.ErrorClusterDisabled {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return status, err
+	}
+}
+
+// clusterVote and clusterAppend are the raft RPCs between the nodes, see
+// cluster.HTTPTransport
+func clusterVote(s service.Servicer) any {
+	return func(w http.ResponseWriter, request *cluster.VoteRequest) (*cluster.VoteResponse, error) {
+		response, err := s.ClusterVote(request)
+		if err == service.ErrorClusterDisabled {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return response, err
+	}
+}
+
+func clusterAppend(s service.Servicer) any {
+	return func(w http.ResponseWriter, request *cluster.AppendRequest) (*cluster.AppendResponse, error) {
+		response, err := s.ClusterAppend(request)
+		if err == service.ErrorClusterDisabled {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		return response, err
+	}
+}
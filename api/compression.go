@@ -51,3 +51,12 @@ func (w gzipResponseWriter) EnableFullDuplex() error {
 	wc := http.NewResponseController(w.ResponseWriter)
 	return wc.EnableFullDuplex()
 }
+
+// Flush sends the compressed data written so far, needed by streaming
+// responses like :watch
+func (w gzipResponseWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
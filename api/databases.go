@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/api/apicollectionv1"
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+type DatabaseResponse struct {
+	Name        string `json:"name"`
+	Collections int    `json:"collections"`
+	*database.Settings
+}
+
+type createDatabaseRequest struct {
+	Name string `json:"name"`
+	database.Settings
+}
+
+func newDatabaseResponse(db *database.Database) *DatabaseResponse {
+	collections := 0
+	for name := range db.ListCollections() {
+		if !database.IsSystemCollection(name) {
+			collections++
+		}
+	}
+	return &DatabaseResponse{
+		Name:        db.Name(),
+		Collections: collections,
+		Settings:    db.Settings(),
+	}
+}
+
+func listDatabases(s service.Servicer) any {
+	return func() []*DatabaseResponse {
+		result := []*DatabaseResponse{}
+		for _, db := range s.ListDatabases() {
+			result = append(result, newDatabaseResponse(db))
+		}
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Name < result[j].Name
+		})
+		return result
+	}
+}
+
+func createDatabase(s service.Servicer) any {
+	return func(w http.ResponseWriter, input *createDatabaseRequest) (*DatabaseResponse, error) {
+		db, err := s.CreateDatabase(input.Name, &input.Settings)
+		if errors.Is(err, database.ErrDatabaseAlreadyExists) || errors.Is(err, database.ErrDatabasesNotReplicated) {
+			w.WriteHeader(http.StatusConflict)
+			return nil, err
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, err
+		}
+		w.WriteHeader(http.StatusCreated)
+		return newDatabaseResponse(db), nil
+	}
+}
+
+func getDatabase(s service.Servicer) any {
+	return func(ctx context.Context) (*DatabaseResponse, error) {
+		db, err := s.GetDatabase(box.GetUrlParameter(ctx, "databaseName"))
+		if err != nil {
+			return nil, err
+		}
+		return newDatabaseResponse(db), nil
+	}
+}
+
+// setSettings replaces the defaults and limits of a logical database
+func setSettings(s service.Servicer) any {
+	return func(ctx context.Context, w http.ResponseWriter, input *database.Settings) (*DatabaseResponse, error) {
+		db, err := s.GetDatabase(box.GetUrlParameter(ctx, "databaseName"))
+		if err != nil {
+			return nil, err
+		}
+		err = db.SetSettings(input)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return nil, err
+		}
+		return newDatabaseResponse(db), nil
+	}
+}
+
+func dropDatabase(s service.Servicer) any {
+	return func(ctx context.Context, w http.ResponseWriter) error {
+		name := box.GetUrlParameter(ctx, "databaseName")
+		if name == database.DefaultDatabase {
+			w.WriteHeader(http.StatusBadRequest)
+			return errors.New("the default database can not be dropped")
+		}
+		err := s.DropDatabase(name)
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// injectDatabaseServicer serves the collections API of the logical database
+// in the url
+func injectDatabaseServicer(s service.Servicer) box.I {
+	return func(next box.H) box.H {
+		return func(ctx context.Context) {
+			db, err := s.Database(box.GetUrlParameter(ctx, "databaseName"))
+			if err != nil {
+				box.SetError(ctx, err)
+				return
+			}
+			next(apicollectionv1.SetServicer(ctx, db))
+		}
+	}
+}
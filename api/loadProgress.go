@@ -0,0 +1,12 @@
+package api
+
+import (
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func loadProgress(s service.Servicer) any {
+	return func() *database.LoadProgress {
+		return s.LoadProgress()
+	}
+}
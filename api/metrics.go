@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/metrics"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+type requestsKey struct{}
+
+// InterceptorMetrics counts every request and its duration in requests, that
+// are exposed in /metrics. It goes after AccessLog to see the final status.
+func InterceptorMetrics(requests *metrics.Requests) box.I {
+	return func(next box.H) box.H {
+		return func(ctx context.Context) {
+
+			c := box.GetBoxContext(ctx)
+			sw := &statusResponseWriter{ResponseWriter: c.Response}
+			c.Response = sw
+
+			t0 := time.Now()
+			next(context.WithValue(ctx, requestsKey{}, requests))
+
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			path, action := "", ""
+			if c.Resource != nil {
+				path = resourcePath(c.Resource)
+			}
+			if c.Action != nil && !c.Action.Bound {
+				action = c.Action.Name
+			}
+			requests.Observe(c.Request.Method, path, action, status, time.Since(t0))
+		}
+	}
+}
+
+// resourcePath returns the pattern of r, e.g. /v1/collections/{collectionName}
+func resourcePath(r *box.R) string {
+	parts := []string{}
+	for ; r != nil && r.Parent != nil; r = r.Parent {
+		parts = append([]string{r.Path}, parts...)
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+func getMetrics(s service.Servicer) any {
+	return func(ctx context.Context, w http.ResponseWriter) error {
+
+		w.Header().Set("Content-Type", metrics.ContentType)
+		mw := metrics.NewWriter(w)
+		if requests, ok := ctx.Value(requestsKey{}).(*metrics.Requests); ok {
+			requests.Write(mw)
+		}
+		metrics.WriteDatabases(mw, s.ListDatabases())
+		metrics.WriteRuntime(mw)
+
+		return mw.Flush()
+	}
+}
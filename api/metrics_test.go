@@ -0,0 +1,43 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fulldump/apitest"
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/metrics"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func TestMetrics(t *testing.T) {
+
+	// Setup
+	db, _, b := newTestInstance(t)
+	defer db.Stop()
+	b.WithInterceptors(InterceptorMetrics(metrics.NewRequests()))
+	a := apitest.NewWithHandler(b)
+	a.Request("POST", "/v1/collections/users:insert").
+		WithBodyJson(service.JSON{"id": "1"}).Do()
+	a.Request("POST", "/v1/collections/users:createIndex").
+		WithBodyJson(service.JSON{"name": "by-id", "type": "map", "field": "id"}).Do()
+
+	// Run
+	resp := a.Request("GET", "/metrics").Do()
+
+	// Check
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+	biff.AssertEqual(resp.Header.Get("Content-Type"), metrics.ContentType)
+	body, _ := io.ReadAll(resp.Body)
+	for _, line := range []string{
+		`inceptiondb_http_requests_total{method="POST",path="/v1/collections/{collectionName}",action="insert",code="201"} 1`,
+		`inceptiondb_collection_rows{database="default",collection="users"} 1`,
+		`inceptiondb_index_entries{database="default",collection="users",index="by-id"} 1`,
+		`go_goroutines `,
+	} {
+		biff.AssertTrue(strings.Contains(string(body), line))
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fulldump/inceptiondb/replication"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func replicationStatus(s service.Servicer) any {
+	return func() *replication.Status {
+		return s.ReplicationStatus()
+	}
+}
+
+// promote turns a follower into a leader, see replication.Follower.Promote
+func promote(s service.Servicer) any {
+	return func(w http.ResponseWriter) (*replication.Status, error) {
+		err := s.Promote()
+		if err == service.ErrorNotFollower {
+			w.WriteHeader(http.StatusConflict)
+			return nil, err
+		}
+		if err != nil {
+			return nil, err
+		}
+		return s.ReplicationStatus(), nil
+	}
+}
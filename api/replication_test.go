@@ -0,0 +1,246 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fulldump/apitest"
+	"github.com/fulldump/biff"
+	"github.com/fulldump/box"
+
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/replication"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func newTestInstance(t *testing.T) (*database.Database, *service.Service, *box.B) {
+
+	db := database.NewDatabase(&database.Config{
+		Dir: t.TempDir(),
+	})
+	biff.AssertNil(db.Load())
+
+	s := service.NewService(db)
+	b := Build(s, "", "test")
+	b.WithInterceptors(
+		InterceptorUnavailable(db),
+		RecoverFromPanic,
+		PrettyErrorInterceptor,
+		InterceptorReadOnly(db),
+	)
+
+	return db, s, b
+}
+
+func TestReplication(t *testing.T) {
+
+	// Setup
+	leaderDb, _, leaderBox := newTestInstance(t)
+	defer leaderDb.Stop()
+	leaderServer := httptest.NewServer(box.Box2Http(leaderBox))
+	defer leaderServer.Close()
+	leader := apitest.NewWithBase(leaderServer.URL)
+
+	leader.Request("POST", "/v1/collections").WithBodyJson(service.JSON{"name": "users"}).Do()
+	leader.Request("POST", "/v1/collections/users:insert").WithBodyJson(service.JSON{"id": "1"}).Do()
+
+	followerDb, followerService, followerBox := newTestInstance(t)
+	defer followerDb.Stop()
+	follower := apitest.NewWithHandler(followerBox)
+
+	// Run
+	f := replication.NewFollower(followerDb, leaderServer.URL)
+	f.Interval = 50 * time.Millisecond
+	followerService.SetFollower(f)
+	f.Start()
+	defer f.Stop()
+	leader.Request("POST", "/v1/collections/users:insert").WithBodyJson(service.JSON{"id": "2"}).Do()
+
+	// Check
+	total := 0
+	for i := 0; i < 100 && total != 2; i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp := follower.Request("GET", "/v1/collections/users").Do()
+		users := struct{ Total int }{}
+		json.Unmarshal(resp.BodyBytes(), &users)
+		total = users.Total
+	}
+	biff.AssertEqual(total, 2)
+
+	resp := follower.Request("POST", "/v1/collections/users:insert").WithBodyJson(service.JSON{"id": "3"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+
+	resp = follower.Request("GET", "/v1/replication").Do()
+	biff.AssertEqual(resp.BodyJson().(service.JSON)["role"], replication.RoleFollower)
+
+	// Promote
+	resp = follower.Request("POST", "/v1/replication:promote").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+	biff.AssertEqual(resp.BodyJson().(service.JSON)["role"], replication.RoleLeader)
+
+	resp = follower.Request("POST", "/v1/collections/users:insert").WithBodyJson(service.JSON{"id": "3"}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+}
+
+func TestReplication_Databases(t *testing.T) {
+
+	// Setup
+	leaderDb, _, leaderBox := newTestInstance(t)
+	defer leaderDb.Stop()
+	leaderServer := httptest.NewServer(box.Box2Http(leaderBox))
+	defer leaderServer.Close()
+	leader := apitest.NewWithBase(leaderServer.URL)
+
+	leader.Request("POST", "/v1/databases").WithBodyJson(service.JSON{"name": "team", "max_documents": 10}).Do()
+	leader.Request("POST", "/v1/databases/team/collections/users:insert").WithBodyJson(service.JSON{"id": "1"}).Do()
+
+	followerDb, followerService, followerBox := newTestInstance(t)
+	defer followerDb.Stop()
+	follower := apitest.NewWithHandler(followerBox)
+
+	// Run
+	f := replication.NewFollower(followerDb, leaderServer.URL)
+	f.Interval = 50 * time.Millisecond
+	followerService.SetFollower(f)
+	f.Start()
+	defer f.Stop()
+
+	// Check
+	total := 0
+	for i := 0; i < 100 && total != 1; i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp := follower.Request("GET", "/v1/databases/team/collections/users").Do()
+		users := struct{ Total int }{}
+		json.Unmarshal(resp.BodyBytes(), &users)
+		total = users.Total
+	}
+	biff.AssertEqual(total, 1)
+
+	resp := follower.Request("GET", "/v1/databases/team").Do()
+	biff.AssertEqual(resp.BodyJson().(service.JSON)["max_documents"], json.Number("10"))
+
+	leader.Request("POST", "/v1/databases/team:dropDatabase").Do()
+	for i := 0; i < 100 && resp.StatusCode == http.StatusOK; i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp = follower.Request("GET", "/v1/databases/team").Do()
+	}
+	biff.AssertEqual(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestReplication_Auth(t *testing.T) {
+
+	// Setup
+	leaderDb, leaderService, leaderBox := newTestInstance(t)
+	defer leaderDb.Stop()
+	leaderKeys := auth.NewKeys(leaderDb, "leader-secret")
+	leaderService.SetKeys(leaderKeys)
+	leaderBox.WithInterceptors(InterceptorAuth(leaderKeys))
+	leaderServer := httptest.NewServer(box.Box2Http(leaderBox))
+	defer leaderServer.Close()
+	leader := apitest.NewWithBase(leaderServer.URL)
+	leader.Request("POST", "/v1/collections/users:insert").
+		WithHeader("X-Api-Key", "leader-secret").
+		WithBodyJson(service.JSON{"id": "1"}).Do()
+
+	followerDb, followerService, followerBox := newTestInstance(t)
+	defer followerDb.Stop()
+	followerKeys := auth.NewKeys(followerDb, "follower-secret")
+	followerService.SetKeys(followerKeys)
+	followerBox.WithInterceptors(InterceptorAuth(followerKeys))
+	follower := apitest.NewWithHandler(followerBox)
+	_, secret, err := followerKeys.Create("reader", false, []auth.Scope{{Database: "default", Collection: "users", Role: auth.RoleReader}})
+	biff.AssertNil(err)
+
+	// Run
+	f := replication.NewFollower(followerDb, leaderServer.URL)
+	f.Interval = 50 * time.Millisecond
+	f.Client = auth.NewClient("leader-secret")
+	followerService.SetFollower(f)
+	f.Start()
+	defer f.Stop()
+
+	// Check
+	total := 0
+	for i := 0; i < 100 && total != 1; i++ {
+		time.Sleep(20 * time.Millisecond)
+		resp := follower.Request("GET", "/v1/collections/users").WithHeader("X-Api-Key", secret).Do()
+		users := struct{ Total int }{}
+		json.Unmarshal(resp.BodyBytes(), &users)
+		total = users.Total
+	}
+	biff.AssertEqual(total, 1)
+
+	time.Sleep(3 * f.Interval) // more discoveries
+	_, err = followerDb.GetCollection(auth.KeysCollection)
+	biff.AssertNil(err) // not dropped
+	_, err = followerKeys.Authenticate(secret)
+	biff.AssertNil(err)
+}
+
+func TestMultiPrimary(t *testing.T) {
+
+	// Setup
+	newPrimary := func(node string) (*database.Database, *service.Service, *httptest.Server) {
+		db, s, b := newTestInstance(t)
+		db.Config.Clock = collection.NewHLC(node)
+		return db, s, httptest.NewServer(box.Box2Http(b))
+	}
+	dbA, serviceA, serverA := newPrimary("a")
+	defer dbA.Stop()
+	defer serverA.Close()
+	dbB, serviceB, serverB := newPrimary("b")
+	defer dbB.Stop()
+	defer serverB.Close()
+	a := apitest.NewWithBase(serverA.URL)
+	b := apitest.NewWithBase(serverB.URL)
+
+	primaryA := replication.NewPrimary(dbA, []string{serverB.URL})
+	primaryA.Interval = 50 * time.Millisecond
+	serviceA.SetPrimary(primaryA)
+	primaryA.Start()
+	defer primaryA.Stop()
+	primaryB := replication.NewPrimary(dbB, []string{serverA.URL})
+	primaryB.Interval = 50 * time.Millisecond
+	serviceB.SetPrimary(primaryB)
+	primaryB.Start()
+	defer primaryB.Stop()
+
+	find := func(api *apitest.Apitest) service.JSON {
+		resp := api.Request("POST", "/v1/collections/users:find").WithBodyJson(service.JSON{}).Do()
+		doc := service.JSON{}
+		json.Unmarshal(resp.BodyBytes(), &doc)
+		delete(doc, collection.VersionsField)
+		return doc
+	}
+	waitEqual := func(expected service.JSON) {
+		for i := 0; i < 100; i++ {
+			if reflect.DeepEqual(find(a), expected) && reflect.DeepEqual(find(b), expected) {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		biff.AssertEqual(find(a), expected)
+		biff.AssertEqual(find(b), expected)
+	}
+
+	a.Request("POST", "/v1/collections").WithBodyJson(service.JSON{"name": "users"}).Do()
+	a.Request("POST", "/v1/collections/users:insert").WithBodyJson(service.JSON{"id": "1", "name": "Alice"}).Do()
+	waitEqual(service.JSON{"id": "1", "name": "Alice"})
+
+	// Run: both primaries accept writes
+	a.Request("POST", "/v1/collections/users:patch").WithBodyJson(service.JSON{"patch": service.JSON{"name": "Alicia"}}).Do()
+	resp := b.Request("POST", "/v1/collections/users:patch").WithBodyJson(service.JSON{"patch": service.JSON{"age": 30}}).Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+
+	// Check
+	waitEqual(service.JSON{"id": "1", "name": "Alicia", "age": float64(30)})
+
+	resp = a.Request("GET", "/v1/replication").Do()
+	biff.AssertEqual(resp.BodyJson().(service.JSON)["role"], replication.RolePrimary)
+}
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/replication"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+type StatusResponse struct {
+	Status      string              `json:"status"` // of the default database
+	Ready       bool                `json:"ready"`
+	ReadOnly    string              `json:"read_only,omitempty"` // why writes are rejected
+	Version     string              `json:"version"`
+	StartedAt   time.Time           `json:"started_at"`
+	Uptime      time.Duration       `json:"uptime"`
+	Replication *ReplicationRole    `json:"replication"`
+	Memory      *MemoryStatus       `json:"memory"`
+	Unflushed   int64               `json:"unflushed"` // journal bytes of all the collections
+	Collections []*CollectionStatus `json:"collections"`
+}
+
+// ReplicationRole tells how the instance replicates, mode is standalone,
+// follower, primary (multi-primary) or cluster
+type ReplicationRole struct {
+	Mode   string `json:"mode"`
+	Role   string `json:"role"`
+	Leader string `json:"leader,omitempty"`
+}
+
+type MemoryStatus struct {
+	Alloc     uint64 `json:"alloc"`
+	HeapInuse uint64 `json:"heap_inuse"`
+	Sys       uint64 `json:"sys"`
+	NumGC     uint32 `json:"num_gc"`
+}
+
+// CollectionStatus has the load state of the collection (see
+// database.CollectionLoad), rows and unflushed bytes only when it is ready
+type CollectionStatus struct {
+	Database  string `json:"database"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Rows      int    `json:"rows"`
+	Unflushed int64  `json:"unflushed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// getStatus reports the state of the whole instance, startedAt is when the
+// api was built
+func getStatus(s service.Servicer, version string, startedAt time.Time) any {
+	return func() *StatusResponse {
+
+		databases := s.ListDatabases()
+		root := databases[database.DefaultDatabase]
+
+		m := &runtime.MemStats{}
+		runtime.ReadMemStats(m)
+
+		status := &StatusResponse{
+			Status:      root.GetStatus(),
+			Ready:       ready(s),
+			ReadOnly:    root.ReadOnly(),
+			Version:     version,
+			StartedAt:   startedAt,
+			Uptime:      time.Since(startedAt),
+			Replication: replicationRole(s),
+			Memory: &MemoryStatus{
+				Alloc:     m.Alloc,
+				HeapInuse: m.HeapInuse,
+				Sys:       m.Sys,
+				NumGC:     m.NumGC,
+			},
+			Collections: []*CollectionStatus{},
+		}
+
+		for name, db := range databases {
+			collections := map[string]*CollectionStatus{}
+			for _, load := range db.LoadProgress().Collections {
+				collections[load.Name] = &CollectionStatus{
+					Status: load.Status,
+					Error:  load.Error,
+				}
+			}
+			for collectionName, col := range db.ListCollections() {
+				stats := col.Stats()
+				collections[collectionName] = &CollectionStatus{
+					Status:    database.CollectionReady,
+					Rows:      stats.Rows,
+					Unflushed: stats.Unflushed,
+				}
+				status.Unflushed += stats.Unflushed
+			}
+			for collectionName, c := range collections {
+				if database.IsSystemCollection(collectionName) {
+					continue
+				}
+				c.Database = name
+				c.Name = collectionName
+				status.Collections = append(status.Collections, c)
+			}
+		}
+		sort.Slice(status.Collections, func(i, j int) bool {
+			a, b := status.Collections[i], status.Collections[j]
+			if a.Database != b.Database {
+				return a.Database < b.Database
+			}
+			return a.Name < b.Name
+		})
+
+		return status
+	}
+}
+
+func replicationRole(s service.Servicer) *ReplicationRole {
+
+	if node, err := s.ClusterStatus(); err == nil {
+		return &ReplicationRole{Mode: "cluster", Role: node.Role, Leader: node.Leader}
+	}
+
+	r := s.ReplicationStatus()
+	role := &ReplicationRole{Mode: r.Role, Role: r.Role, Leader: r.Leader}
+	if r.Role == replication.RoleLeader {
+		role.Mode = "standalone" // maybe a promoted follower
+	}
+	return role
+}
+
+// ready is true once the databases are loaded (the default one loads the
+// rest before it is operating), quarantined collections do not prevent it
+func ready(s service.Servicer) bool {
+	return s.LoadProgress().Status == database.StatusOperating
+}
+
+// liveness only tells the process is serving http, it is not affected by the
+// load of the databases (that can take long)
+func liveness() any {
+	return func() map[string]string {
+		return map[string]string{"status": "alive"}
+	}
+}
+
+// readiness fails with 503 until the databases are loaded, so load balancers
+// and orchestrators do not send traffic before
+func readiness(s service.Servicer) any {
+	return func(w http.ResponseWriter) map[string]string {
+		progress := s.LoadProgress()
+		if progress.Status != database.StatusOperating {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return map[string]string{"status": progress.Status}
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fulldump/apitest"
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/service"
+)
+
+func TestStatus(t *testing.T) {
+
+	// Setup
+	db, _, b := newTestInstance(t)
+	defer db.Stop()
+	a := apitest.NewWithHandler(b)
+	a.Request("POST", "/v1/collections/users:insert").
+		WithBodyJson(service.JSON{"id": "1"}).Do()
+
+	// Run
+	resp := a.Request("GET", "/v1/status").Do()
+
+	// Check
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+	status := resp.BodyJson().(service.JSON)
+	biff.AssertEqual(status["status"], database.StatusOperating)
+	biff.AssertEqual(status["ready"], true)
+	biff.AssertEqual(status["version"], "test")
+	biff.AssertEqual(status["replication"], map[string]any{"mode": "standalone", "role": "leader"})
+	biff.AssertNotNil(status["memory"])
+	biff.AssertEqual(status["collections"], []any{
+		map[string]any{
+			"database":  "default",
+			"name":      "users",
+			"status":    database.CollectionReady,
+			"rows":      json.Number("1"),
+			"unflushed": status["unflushed"],
+		},
+	})
+
+	resp = a.Request("GET", "/livez").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+
+	resp = a.Request("GET", "/readyz").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+}
+
+func TestStatus_Loading(t *testing.T) {
+
+	// Setup
+	db := database.NewDatabase(&database.Config{Dir: t.TempDir()}) // not loaded
+	a := apitest.NewWithHandler(Build(service.NewService(db), "", "test"))
+
+	// Run
+	resp := a.Request("GET", "/readyz").Do()
+
+	// Check
+	biff.AssertEqual(resp.StatusCode, http.StatusServiceUnavailable)
+	biff.AssertEqual(resp.BodyJson(), map[string]any{"status": database.StatusOpening})
+
+	resp = a.Request("GET", "/livez").Do()
+	biff.AssertEqual(resp.StatusCode, http.StatusOK)
+}
@@ -0,0 +1,211 @@
+// Package audit keeps an append-only log of the mutating operations, one JSON
+// Entry per line, recording who changed what and when. The HTTP layer creates
+// an Entry per request (see api.InterceptorAudit) and the handlers add the
+// ids of the documents they touch with AddDocuments.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// MaxDocuments is the number of document ids kept in an Entry, the rest are
+// only counted
+var MaxDocuments = 1000
+
+type Entry struct {
+	Time           time.Time `json:"time"`
+	RequestId      string    `json:"request_id"`
+	Principal      string    `json:"principal,omitempty"`     // empty without authentication
+	RemoteAddr     string    `json:"remote_addr"`             // of the connection
+	ForwardedFor   string    `json:"forwarded_for,omitempty"` // X-Forwarded-For header, set by the client or the proxies
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Action         string    `json:"action"`
+	Database       string    `json:"database,omitempty"`
+	Collection     string    `json:"collection,omitempty"`
+	Documents      []string  `json:"documents,omitempty"`
+	DocumentsCount int       `json:"documents_count,omitempty"`
+	Status         int       `json:"status"`
+	Error          string    `json:"error,omitempty"`
+
+	mutex sync.Mutex
+}
+
+type entryKey struct{}
+
+func SetEntry(ctx context.Context, e *Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, e)
+}
+
+// AddDocuments records the ids of the documents changed by the request, empty
+// ids are only counted. It does nothing if the request is not audited.
+func AddDocuments(ctx context.Context, ids ...string) {
+	e, ok := ctx.Value(entryKey{}).(*Entry)
+	if !ok {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, id := range ids {
+		e.DocumentsCount++
+		if id != "" && len(e.Documents) < MaxDocuments {
+			e.Documents = append(e.Documents, id)
+		}
+	}
+}
+
+type Log struct {
+	filename string
+	file     *os.File
+	mutex    *sync.Mutex
+}
+
+// Open creates the file if it does not exist, entries are always appended
+func Open(filename string) (*Log, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{
+		filename: filename,
+		file:     file,
+		mutex:    &sync.Mutex{},
+	}, nil
+}
+
+// Append writes e in a single write, so a crash can only lose or truncate the
+// last line
+func (l *Log) Append(e *Entry) error {
+
+	e.mutex.Lock()
+	data, err := json.Marshal(e)
+	e.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.file.Write(data)
+	return err
+}
+
+func (l *Log) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.file.Close()
+}
+
+type Filter struct {
+	Since      time.Time
+	Until      time.Time
+	Principal  string
+	Action     string
+	Database   string
+	Collection string
+	Document   string
+	Limit      int // zero means no limit, otherwise the newest entries are kept
+}
+
+func (f *Filter) match(e *Entry) bool {
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	if f.Principal != "" && e.Principal != f.Principal {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	if f.Database != "" && e.Database != f.Database {
+		return false
+	}
+	if f.Collection != "" && e.Collection != f.Collection {
+		return false
+	}
+	if f.Document != "" {
+		for _, id := range e.Documents {
+			if id == f.Document {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Query returns the entries matching filter, newest first. The file is read
+// backwards, with a limit it stops once it has the newest ones.
+func (l *Log) Query(filter *Filter) ([]*Entry, error) { // todo: index by time
+
+	file, err := os.Open(l.filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	result := []*Entry{}
+	err = readLinesBackwards(file, func(line []byte) bool {
+		e := &Entry{}
+		err := json.Unmarshal(line, e)
+		if err != nil {
+			return true // torn line
+		}
+		if filter.match(e) {
+			result = append(result, e)
+		}
+		return filter.Limit <= 0 || len(result) < filter.Limit
+	})
+
+	return result, err
+}
+
+// readLinesBackwards calls f with every line of file, from the last one,
+// until it returns false
+func readLinesBackwards(file *os.File, f func(line []byte) bool) error {
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := info.Size()
+	chunk := make([]byte, 64*1024)
+	var partial []byte // first bytes read of a line that starts before offset
+	for offset > 0 {
+		n := min(int64(len(chunk)), offset)
+		offset -= n
+		_, err := file.ReadAt(chunk[:n], offset)
+		if err != nil {
+			return err
+		}
+
+		data := append(chunk[:n:n], partial...)
+		for {
+			i := bytes.LastIndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			line := data[i+1:]
+			data = data[:i]
+			if len(line) > 0 && !f(line) {
+				return nil
+			}
+		}
+		partial = append([]byte{}, data...)
+	}
+	if len(partial) > 0 {
+		f(partial)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fulldump/biff"
+)
+
+func TestLog(t *testing.T) {
+
+	// Setup
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(filename)
+	biff.AssertNil(err)
+	now := time.Now().UTC()
+
+	// Run
+	for i, collection := range []string{"users", "orders", "users"} {
+		e := &Entry{
+			Time:       now.Add(time.Duration(i) * time.Minute),
+			Principal:  "ci",
+			Action:     "insert",
+			Database:   "default",
+			Collection: collection,
+		}
+		AddDocuments(SetEntry(context.Background(), e), collection+"-1", "")
+		biff.AssertNil(l.Append(e))
+	}
+	biff.AssertNil(l.Close())
+
+	// Check: entries survive a reopen
+	l, err = Open(filename)
+	biff.AssertNil(err)
+	defer l.Close()
+
+	entries, err := l.Query(&Filter{})
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 3)
+	biff.AssertEqual(entries[0].Documents, []string{"users-1"})
+	biff.AssertEqual(entries[0].DocumentsCount, 2)
+
+	entries, err = l.Query(&Filter{Collection: "users", Since: now.Add(time.Minute)})
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 1)
+	biff.AssertTrue(entries[0].Time.Equal(now.Add(2 * time.Minute)))
+
+	entries, err = l.Query(&Filter{Document: "orders-1"})
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 1)
+
+	entries, err = l.Query(&Filter{Limit: 2})
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 2)
+	biff.AssertTrue(entries[0].Time.Equal(now.Add(2 * time.Minute))) // newest first
+	biff.AssertEqual(entries[1].Collection, "orders")
+
+	// Check: a torn last line is skipped
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+	biff.AssertNil(err)
+	f.WriteString(`{"time":"2`)
+	f.Close()
+	entries, err = l.Query(&Filter{})
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 3)
+}
+
+func TestLog_QueryBackwards(t *testing.T) {
+
+	// Setup: several chunks
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(filename)
+	biff.AssertNil(err)
+	defer l.Close()
+	for i := 0; i < 2000; i++ {
+		biff.AssertNil(l.Append(&Entry{
+			Action:     "insert",
+			Collection: fmt.Sprintf("c%d", i),
+		}))
+	}
+
+	// Run
+	entries, err := l.Query(&Filter{Limit: 3})
+
+	// Check
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 3)
+	biff.AssertEqual(entries[0].Collection, "c1999")
+	biff.AssertEqual(entries[2].Collection, "c1997")
+
+	entries, err = l.Query(&Filter{})
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 2000)
+	biff.AssertEqual(entries[1999].Collection, "c0")
+
+	entries, err = l.Query(&Filter{Collection: "c5"})
+	biff.AssertNil(err)
+	biff.AssertEqual(len(entries), 1)
+}
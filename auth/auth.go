@@ -0,0 +1,178 @@
+// Package auth identifies the clients of the HTTP API. Clients send an API key
+// in the X-Api-Key header or as an Authorization bearer token. There is one
+// admin key given in the configuration, the others are created through the
+// admin endpoints and stored hashed in a system collection of the default
+// database (see Keys). Bearer tokens can also be JWTs signed by an identity
+// provider (see JWT), and clients with a verified certificate and no
+// credential are identified by its subject (see AuthenticateRequest). Every principal is scoped to some databases and
+// collections, and only admins can manage databases, keys, backups or
+// replication.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+var ErrForbidden = errors.New("forbidden")
+
+// Authenticator returns the principal behind the credential of a request (see
+// FromRequest) or an error wrapping ErrUnauthorized. Keys and JWT implement it.
+type Authenticator interface {
+	Authenticate(credential string) (*Principal, error)
+}
+
+// CertificateAuthenticator returns the principal behind a verified client
+// certificate, Keys implements it
+type CertificateAuthenticator interface {
+	AuthenticateCertificate(cert *x509.Certificate) (*Principal, error)
+}
+
+// AuthenticateRequest uses the credential of r or, if there is none, its
+// verified client certificate
+func AuthenticateRequest(a Authenticator, r *http.Request) (*Principal, error) {
+	credential := FromRequest(r)
+	if credential == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		if ca, ok := a.(CertificateAuthenticator); ok {
+			return ca.AuthenticateCertificate(r.TLS.VerifiedChains[0][0])
+		}
+	}
+	return a.Authenticate(credential)
+}
+
+// Chain tries its authenticators in order until one accepts the credential
+type Chain []Authenticator
+
+func (c Chain) Authenticate(credential string) (*Principal, error) {
+	var result error = ErrUnauthorized
+	for _, a := range c {
+		principal, err := a.Authenticate(credential)
+		if err == nil {
+			return principal, nil
+		}
+		if !errors.Is(err, ErrUnauthorized) {
+			return nil, err
+		}
+		if err != ErrUnauthorized {
+			result = err // the most detailed one
+		}
+	}
+	return nil, result
+}
+
+func (c Chain) AuthenticateCertificate(cert *x509.Certificate) (*Principal, error) {
+	var result error = ErrUnauthorized
+	for _, a := range c {
+		ca, ok := a.(CertificateAuthenticator)
+		if !ok {
+			continue
+		}
+		principal, err := ca.AuthenticateCertificate(cert)
+		if err == nil || !errors.Is(err, ErrUnauthorized) {
+			return principal, err
+		}
+		result = err
+	}
+	return nil, result
+}
+
+// Wildcard matches every database or collection in a Scope
+const Wildcard = "*"
+
+// Roles of a Scope, each one can do everything the previous ones can. The
+// collection actions are tagged with the role they need (see
+// apicollectionv1.AttributeRole).
+const (
+	RoleReader = "reader" // find and get documents, follow changes
+	RoleWriter = "writer" // insert, patch and remove documents
+	RoleAdmin  = "admin"  // drop the collection, manage its indexes and settings
+)
+
+var roleRanks = map[string]int{
+	RoleReader: 1,
+	RoleWriter: 2,
+	RoleAdmin:  3,
+}
+
+// RoleIncludes tells if role can do what required needs
+func RoleIncludes(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+type Scope struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Role       string `json:"role,omitempty"` // empty means RoleAdmin
+}
+
+func (s Scope) Validate() error {
+	if s.Database == "" || s.Collection == "" {
+		return fmt.Errorf("scopes need a database and a collection, use '%s' to match all of them", Wildcard)
+	}
+	if _, exists := roleRanks[s.Role]; s.Role != "" && !exists {
+		return fmt.Errorf("unknown role '%s', use %s, %s or %s", s.Role, RoleReader, RoleWriter, RoleAdmin)
+	}
+	return nil
+}
+
+func (s Scope) role() string {
+	if s.Role == "" {
+		return RoleAdmin
+	}
+	return s.Role
+}
+
+func (s Scope) matches(database, collection string) bool {
+	if s.Database != Wildcard && s.Database != database {
+		return false
+	}
+	if collection == "" {
+		return s.Collection == Wildcard
+	}
+	return s.Collection == Wildcard || s.Collection == collection
+}
+
+// Principal is the identity behind a request
+type Principal struct {
+	Name   string  `json:"name"`
+	Admin  bool    `json:"admin,omitempty"`
+	Scopes []Scope `json:"scopes,omitempty"`
+}
+
+// Role returns the highest role of p in a collection of a database, empty if
+// p has no access. An empty collection stands for all of them (e.g. to list or
+// create collections), so it needs a Wildcard collection scope.
+func (p *Principal) Role(database, collection string) string {
+	if p.Admin {
+		return RoleAdmin
+	}
+	result := ""
+	for _, scope := range p.Scopes {
+		if scope.matches(database, collection) && RoleIncludes(scope.role(), result) {
+			result = scope.role()
+		}
+	}
+	return result
+}
+
+// Allows tells if p has the required role in a collection of a database
+func (p *Principal) Allows(database, collection, required string) bool {
+	role := p.Role(database, collection)
+	return role != "" && RoleIncludes(role, required)
+}
+
+type principalKey struct{}
+
+func SetPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// GetPrincipal returns nil if authentication is disabled
+func GetPrincipal(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
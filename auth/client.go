@@ -0,0 +1,26 @@
+package auth
+
+import "net/http"
+
+// NewClient returns an http client that sends key with every request, it is
+// used to talk to other instances with authentication enabled (leader, peers
+// or cluster nodes)
+func NewClient(key string) *http.Client {
+	return &http.Client{
+		Transport: &keyTransport{
+			key:  key,
+			next: http.DefaultTransport,
+		},
+	}
+}
+
+type keyTransport struct {
+	key  string
+	next http.RoundTripper
+}
+
+func (t *keyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("X-Api-Key", t.key)
+	return t.next.RoundTrip(r)
+}
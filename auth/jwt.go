@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJWTClaim holds the scopes of a token, see JWT
+const DefaultJWTClaim = "inceptiondb_scopes"
+
+type JWTOptions struct {
+	// Jwks is the file or http(s) url of the JSON Web Key Set
+	Jwks string
+	// Issuer and Audience are checked if not empty
+	Issuer   string
+	Audience string
+	// Claim with the scopes, DefaultJWTClaim if empty
+	Claim string
+	// Refresh is how often the JWKS is read again, it is also read when a token
+	// comes with an unknown key id (at most every MinRefresh)
+	Refresh    time.Duration
+	MinRefresh time.Duration
+	// Leeway tolerates clock differences with the identity provider
+	Leeway time.Duration
+	Client *http.Client
+}
+
+func DefaultJWTOptions() *JWTOptions {
+	return &JWTOptions{
+		Claim:      DefaultJWTClaim,
+		Refresh:    5 * time.Minute,
+		MinRefresh: 10 * time.Second,
+		Leeway:     30 * time.Second,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// JWT authenticates signed JSON Web Tokens (RS256, RS384, RS512, ES256,
+// ES384, ES512 and EdDSA). The principal is the subject of the token and its
+// scopes come from the Claim, a list (or a space separated string) of
+// "database:collection:role" entries, where the role is optional and "admin"
+// alone makes the principal an admin.
+type JWT struct {
+	options *JWTOptions
+	mutex   *sync.Mutex
+	keys    []*publicKey
+	readAt  time.Time
+	now     func() time.Time
+}
+
+// NewJWT fails if the JWKS cannot be read, zero options take the defaults
+// (except Leeway)
+func NewJWT(options *JWTOptions) (*JWT, error) {
+
+	defaults := DefaultJWTOptions()
+	if options.Claim == "" {
+		options.Claim = defaults.Claim
+	}
+	if options.Refresh == 0 {
+		options.Refresh = defaults.Refresh
+	}
+	if options.MinRefresh == 0 {
+		options.MinRefresh = defaults.MinRefresh
+	}
+	if options.Client == nil {
+		options.Client = defaults.Client
+	}
+
+	j := &JWT{
+		options: options,
+		mutex:   &sync.Mutex{},
+		now:     time.Now,
+	}
+	err := j.refresh()
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+var jwtCurves = map[string]int{
+	"ES256": 256,
+	"ES384": 384,
+	"ES512": 521,
+}
+
+func (j *JWT) Authenticate(token string) (*Principal, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnauthorized // not a jwt, maybe an api key
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	err := decodeJSONSegment(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: token header: %s", ErrUnauthorized, err.Error())
+	}
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: token signature: %s", ErrUnauthorized, err.Error())
+	}
+
+	key, err := j.key(header.Kid, header.Alg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err.Error())
+	}
+	err = verifySignature(key, header.Alg, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err.Error())
+	}
+
+	claims := map[string]any{}
+	err = decodeJSONSegment(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: token claims: %s", ErrUnauthorized, err.Error())
+	}
+	err = j.validate(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnauthorized, err.Error())
+	}
+
+	subject, _ := claims["sub"].(string)
+	principal := &Principal{Name: subject}
+	for _, entry := range claimStrings(claims[j.options.Claim]) {
+		if entry == RoleAdmin {
+			principal.Admin = true
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			continue // other kind of scope
+		}
+		scope := Scope{Database: parts[0], Collection: parts[1]}
+		if len(parts) == 3 {
+			scope.Role = parts[2]
+		}
+		if scope.Validate() != nil {
+			continue
+		}
+		principal.Scopes = append(principal.Scopes, scope)
+	}
+
+	return principal, nil
+}
+
+// validate checks expiration (mandatory), not before, issuer, audience and
+// subject (mandatory, it names the principal)
+func (j *JWT) validate(claims map[string]any) error {
+
+	now := j.now()
+	leeway := j.options.Leeway
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token without expiration")
+	}
+	if now.After(unixTime(exp).Add(leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(unixTime(nbf)) {
+		return fmt.Errorf("token not valid yet")
+	}
+
+	if j.options.Issuer != "" && claims["iss"] != j.options.Issuer {
+		return fmt.Errorf("unexpected token issuer")
+	}
+
+	if j.options.Audience != "" {
+		found := false
+		for _, audience := range claimStrings(claims["aud"]) {
+			if audience == j.options.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unexpected token audience")
+		}
+	}
+
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return fmt.Errorf("token without subject")
+	}
+
+	return nil
+}
+
+// key finds the verification key, reading the JWKS again if kid is unknown
+func (j *JWT) key(kid, alg string) (crypto.PublicKey, error) {
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	elapsed := j.now().Sub(j.readAt)
+	if elapsed > j.options.Refresh {
+		j.refreshLocked()
+	}
+
+	key := findKey(j.keys, kid, alg)
+	if key == nil && elapsed > j.options.MinRefresh {
+		j.refreshLocked()
+		key = findKey(j.keys, kid, alg)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key '%s'", kid)
+	}
+
+	return key, nil
+}
+
+func (j *JWT) refresh() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.refreshLocked()
+}
+
+// refreshLocked keeps the previous keys if the JWKS cannot be read
+func (j *JWT) refreshLocked() error {
+
+	j.readAt = j.now()
+
+	data, err := readJWKS(j.options.Client, j.options.Jwks)
+	if err == nil {
+		var keys []*publicKey
+		keys, err = parseJWKS(data)
+		if err == nil {
+			j.keys = keys
+			return nil
+		}
+	}
+
+	slog.Warn("read jwks", "jwks", j.options.Jwks, "error", err)
+	return err
+}
+
+func findKey(keys []*publicKey, kid, alg string) crypto.PublicKey {
+	for _, k := range keys {
+		if kid != "" && k.id != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if keyAlgorithm(k.key, alg) {
+			return k.key
+		}
+	}
+	return nil
+}
+
+// keyAlgorithm tells if alg can be used with key, a token must not choose
+// the algorithm of a key (e.g. "none" or HS256 with a public key)
+func keyAlgorithm(key crypto.PublicKey, alg string) bool {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS")
+	case *ecdsa.PublicKey:
+		return jwtCurves[alg] == k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+func verifySignature(key crypto.PublicKey, alg string, signed, signature []byte) error {
+
+	if k, ok := key.(ed25519.PublicKey); ok {
+		if !ed25519.Verify(k, signed, signature) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm '%s'", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		err := rsa.VerifyPKCS1v15(k, hash, digest, signature)
+		if err != nil {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported key")
+}
+
+func decodeJSONSegment(segment string, v any) error {
+	data, err := decodeSegment(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// claimStrings accepts a string, space separated values or a list of strings
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
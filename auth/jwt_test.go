@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fulldump/biff"
+)
+
+type testSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func (s *testSigner) jwk() map[string]any {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch k := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]any{"kty": "RSA", "kid": s.kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]any{"kty": "EC", "kid": s.kid, "crv": "P-256", "x": b64(k.X.FillBytes(make([]byte, 32))), "y": b64(k.Y.FillBytes(make([]byte, 32)))}
+	case ed25519.PublicKey:
+		return map[string]any{"kty": "OKP", "kid": s.kid, "crv": "Ed25519", "x": b64(k)}
+	}
+	panic("unexpected key")
+}
+
+func (s *testSigner) sign(claims map[string]any) string {
+
+	header, _ := json.Marshal(map[string]any{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch k := s.key.(type) {
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(signed))
+		r, s, _ := ecdsa.Sign(rand.Reader, k, digest[:])
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func newTestSigners(t *testing.T) (rsaSigner, ecSigner, edSigner *testSigner) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	biff.AssertNil(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	biff.AssertNil(err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	biff.AssertNil(err)
+	return &testSigner{kid: "rsa", alg: "RS256", key: rsaKey},
+		&testSigner{kid: "ec", alg: "ES256", key: ecKey},
+		&testSigner{kid: "ed", alg: "EdDSA", key: edKey}
+}
+
+func writeJWKS(t *testing.T, filename string, signers ...*testSigner) {
+	keys := []any{}
+	for _, s := range signers {
+		keys = append(keys, s.jwk())
+	}
+	data, _ := json.Marshal(map[string]any{"keys": keys})
+	biff.AssertNil(os.WriteFile(filename, data, 0644))
+}
+
+func TestJWT(t *testing.T) {
+
+	// Setup
+	rsaSigner, ecSigner, edSigner := newTestSigners(t)
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filename, rsaSigner, ecSigner, edSigner)
+	j, err := NewJWT(&JWTOptions{Jwks: filename, Issuer: "https://idp", Audience: "inceptiondb"})
+	biff.AssertNil(err)
+
+	claims := func() map[string]any {
+		return map[string]any{
+			"sub":           "billing",
+			"iss":           "https://idp",
+			"aud":           []string{"inceptiondb", "other"},
+			"exp":           time.Now().Add(time.Hour).Unix(),
+			DefaultJWTClaim: []string{"team:users:reader", "team:orders", "openid"},
+		}
+	}
+
+	for _, signer := range []*testSigner{rsaSigner, ecSigner, edSigner} {
+
+		// Run
+		principal, err := j.Authenticate(signer.sign(claims()))
+
+		// Check
+		biff.AssertNil(err)
+		biff.AssertEqual(principal.Name, "billing")
+		biff.AssertFalse(principal.Admin)
+		biff.AssertEqual(principal.Role("team", "users"), RoleReader)
+		biff.AssertEqual(principal.Role("team", "orders"), RoleAdmin)
+		biff.AssertEqual(principal.Role("other", "users"), "")
+	}
+
+	admin := claims()
+	admin[DefaultJWTClaim] = "admin"
+	principal, err := j.Authenticate(ecSigner.sign(admin))
+	biff.AssertNil(err)
+	biff.AssertTrue(principal.Admin)
+}
+
+func TestJWT_Rejected(t *testing.T) {
+
+	// Setup
+	rsaSigner, ecSigner, _ := newTestSigners(t)
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filename, rsaSigner)
+	j, err := NewJWT(&JWTOptions{Jwks: filename, Issuer: "https://idp"})
+	biff.AssertNil(err)
+
+	valid := map[string]any{"sub": "billing", "iss": "https://idp", "exp": time.Now().Add(time.Hour).Unix()}
+	token := rsaSigner.sign(valid)
+	parts := strings.Split(token, ".")
+
+	none, _ := json.Marshal(map[string]any{"alg": "none", "kid": "rsa"})
+
+	cases := map[string]string{
+		"expired":       rsaSigner.sign(map[string]any{"iss": "https://idp", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"no expiration": rsaSigner.sign(map[string]any{"iss": "https://idp"}),
+		"issuer":        rsaSigner.sign(map[string]any{"iss": "https://evil", "exp": time.Now().Add(time.Hour).Unix()}),
+		"no subject":    rsaSigner.sign(map[string]any{"iss": "https://idp", "exp": time.Now().Add(time.Hour).Unix()}),
+		"empty subject": rsaSigner.sign(map[string]any{"sub": "", "iss": "https://idp", "exp": time.Now().Add(time.Hour).Unix()}),
+		"unknown key":   ecSigner.sign(valid),
+		"tampered":      parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`)) + "." + parts[2],
+		"alg none":      base64.RawURLEncoding.EncodeToString(none) + "." + parts[1] + ".",
+		"api key":       "idb_something",
+	}
+
+	for name, token := range cases {
+		_, err := j.Authenticate(token)
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+
+	_, err = Chain{NewKeys(nil, "admin-secret"), j}.Authenticate(cases["expired"])
+	biff.AssertEqual(err.Error(), "unauthorized: token expired") // the detailed error
+}
+
+func TestJWT_Rotation(t *testing.T) {
+
+	// Setup
+	rsaSigner, ecSigner, _ := newTestSigners(t)
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filename, rsaSigner)
+	j, err := NewJWT(&JWTOptions{Jwks: filename})
+	biff.AssertNil(err)
+	now := time.Now()
+	j.now = func() time.Time { return now }
+	token := ecSigner.sign(map[string]any{"sub": "billing", "exp": now.Add(time.Hour).Unix()})
+
+	// Run
+	writeJWKS(t, filename, rsaSigner, ecSigner)
+	_, errTooSoon := j.Authenticate(token)
+	now = now.Add(time.Minute)
+	_, err = j.Authenticate(token)
+
+	// Check
+	biff.AssertTrue(errors.Is(errTooSoon, ErrUnauthorized))
+	biff.AssertNil(err)
+}
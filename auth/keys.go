@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/database"
+)
+
+// KeysCollection stores the API keys in the default database
+const KeysCollection = database.SystemCollectionPrefix + "apikeys"
+
+// KeyPrefix starts every generated secret, it makes keys easy to spot (e.g. in
+// a leaked config file)
+const KeyPrefix = "idb_"
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// Key is an API key as stored in KeysCollection, the secret itself is only
+// returned by Create. Keys bound to a client certificate subject have no
+// secret (see CreateForSubject).
+type Key struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash"` // sha256 of the secret or the subject, hex encoded
+	Subject   string    `json:"subject,omitempty"`
+	Admin     bool      `json:"admin,omitempty"`
+	Scopes    []Scope   `json:"scopes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (k *Key) principal() *Principal {
+	return &Principal{
+		Name:   k.Name,
+		Admin:  k.Admin,
+		Scopes: k.Scopes,
+	}
+}
+
+type Keys struct {
+	db       *database.Database
+	adminKey string
+	mutex    *sync.Mutex // serializes the creation of KeysCollection
+}
+
+// NewKeys manages the keys of db, adminKey cannot be empty
+func NewKeys(db *database.Database, adminKey string) *Keys {
+	return &Keys{
+		db:       db,
+		adminKey: adminKey,
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// FromRequest returns the API key sent in the X-Api-Key header or the bearer
+// token (an API key or a JWT), empty if there is none
+func FromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return ""
+}
+
+// Authenticate returns the principal of a secret, or ErrUnauthorized
+func (k *Keys) Authenticate(secret string) (*Principal, error) {
+
+	if secret == "" {
+		return nil, ErrUnauthorized
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(k.adminKey)) == 1 {
+		return &Principal{Name: "admin", Admin: true}, nil
+	}
+	if !strings.HasPrefix(secret, KeyPrefix) {
+		return nil, ErrUnauthorized // not generated by Create, maybe a jwt
+	}
+
+	col, err := k.collection(false)
+	if err == database.ErrCollectionNotFound {
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	row := findRow(col, "hash", hashSecret(secret))
+	if row == nil {
+		return nil, ErrUnauthorized
+	}
+	key := &Key{}
+	err = json.Unmarshal(row.GetPayload(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.principal(), nil
+}
+
+// Create stores a new key and returns it with its secret
+func (k *Keys) Create(name string, admin bool, scopes []Scope) (*Key, string, error) {
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := k.insert(&Key{
+		Name:   name,
+		Hash:   hashSecret(secret),
+		Admin:  admin,
+		Scopes: scopes,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return key, secret, nil
+}
+
+// CreateForSubject stores a key for the clients with a verified certificate
+// with this subject (e.g. "CN=billing,O=Acme"), see AuthenticateCertificate
+func (k *Keys) CreateForSubject(name, subject string, admin bool, scopes []Scope) (*Key, error) {
+
+	if subject == "" {
+		return nil, fmt.Errorf("empty certificate subject")
+	}
+
+	return k.insert(&Key{
+		Name:    name,
+		Hash:    hashSubject(subject),
+		Subject: subject,
+		Admin:   admin,
+		Scopes:  scopes,
+	})
+}
+
+// AuthenticateCertificate returns the principal of the key bound to the
+// subject of a verified client certificate, or ErrUnauthorized
+func (k *Keys) AuthenticateCertificate(cert *x509.Certificate) (*Principal, error) {
+
+	subject := cert.Subject.String()
+	col, err := k.collection(false)
+	if err == database.ErrCollectionNotFound {
+		return nil, fmt.Errorf("%w: no key for certificate '%s'", ErrUnauthorized, subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	row := findRow(col, "hash", hashSubject(subject))
+	if row == nil {
+		return nil, fmt.Errorf("%w: no key for certificate '%s'", ErrUnauthorized, subject)
+	}
+	key := &Key{}
+	err = json.Unmarshal(row.GetPayload(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.principal(), nil
+}
+
+func (k *Keys) insert(key *Key) (*Key, error) {
+
+	if key.Name == "" {
+		return nil, fmt.Errorf("api keys need a name")
+	}
+	for _, scope := range key.Scopes {
+		err := scope.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	key.Id = uuid.New().String()
+	key.CreatedAt = time.Now().UTC()
+
+	col, err := k.collection(true)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	item := map[string]any{}
+	json.Unmarshal(data, &item)
+	_, err = col.Insert(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// List returns the keys sorted by creation
+func (k *Keys) List() ([]*Key, error) {
+
+	result := []*Key{}
+
+	col, err := k.collection(false)
+	if err == database.ErrCollectionNotFound {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var decodeErr error
+	col.TraverseRows(func(row *collection.Row) bool {
+		key := &Key{}
+		decodeErr = json.Unmarshal(row.GetPayload(), key)
+		if decodeErr != nil {
+			return false
+		}
+		result = append(result, key)
+		return true
+	})
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+
+	return result, nil
+}
+
+func (k *Keys) Get(id string) (*Key, error) {
+
+	col, err := k.collection(false)
+	if err == database.ErrCollectionNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	row := findRow(col, "id", id)
+	if row == nil {
+		return nil, ErrKeyNotFound
+	}
+
+	key := &Key{}
+	err = json.Unmarshal(row.GetPayload(), key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Revoke removes a key, the next requests with it are rejected
+func (k *Keys) Revoke(id string) error {
+
+	col, err := k.collection(false)
+	if err == database.ErrCollectionNotFound {
+		return ErrKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	row := findRow(col, "id", id)
+	if row == nil {
+		return ErrKeyNotFound
+	}
+	return col.Remove(row)
+}
+
+// collection returns KeysCollection, it is created with its indexes on the
+// first key so read only instances without keys do not need to write
+func (k *Keys) collection(create bool) (*collection.Collection, error) {
+
+	col, err := k.db.GetCollection(KeysCollection)
+	if err != database.ErrCollectionNotFound || !create {
+		return col, err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	col, err = k.db.GetCollection(KeysCollection)
+	if err != database.ErrCollectionNotFound {
+		return col, err
+	}
+
+	col, err = k.db.CreateCollection(KeysCollection)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"id", "hash"} {
+		err = col.Index(field, &collection.IndexMapOptions{Field: field})
+		if err != nil {
+			return nil, fmt.Errorf("index '%s': %w", field, err)
+		}
+	}
+
+	return col, nil
+}
+
+func findRow(col *collection.Collection, index, value string) *collection.Row {
+
+	idx, exists := col.GetIndex(index)
+	if !exists {
+		return nil
+	}
+
+	options, _ := json.Marshal(&collection.IndexMapTraverse{Value: value})
+	var result *collection.Row
+	idx.Traverse(options, func(row *collection.Row) bool {
+		result = row
+		return false
+	})
+	return result
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashSubject cannot collide with hashSecret, secrets start with KeyPrefix
+func hashSubject(subject string) string {
+	return hashSecret("subject:" + subject)
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
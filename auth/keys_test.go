@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/database"
+)
+
+func TestKeys(t *testing.T) {
+
+	// Setup
+	dir := t.TempDir()
+	db := database.NewDatabase(&database.Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	keys := NewKeys(db, "admin-secret")
+	key, secret, err := keys.Create("ci", false, []Scope{{Database: "team", Collection: "users"}})
+	biff.AssertNil(err)
+	biff.AssertTrue(strings.HasPrefix(secret, KeyPrefix))
+	db.Stop()
+
+	// Run
+	db = database.NewDatabase(&database.Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+	keys = NewKeys(db, "admin-secret")
+	principal, err := keys.Authenticate(secret)
+
+	// Check
+	biff.AssertNil(err)
+	biff.AssertEqual(principal.Name, "ci")
+	biff.AssertEqual(principal.Role("team", "users"), RoleAdmin)
+	biff.AssertEqual(principal.Role("team", "orders"), "")
+	biff.AssertEqual(principal.Role("team", ""), "")
+	biff.AssertEqual(principal.Role(database.DefaultDatabase, "users"), "")
+
+	_, err = keys.Authenticate("idb_wrong")
+	biff.AssertEqual(err, ErrUnauthorized)
+
+	admin, err := keys.Authenticate("admin-secret")
+	biff.AssertNil(err)
+	biff.AssertTrue(admin.Admin)
+
+	biff.AssertNil(keys.Revoke(key.Id))
+	_, err = keys.Authenticate(secret)
+	biff.AssertEqual(err, ErrUnauthorized)
+	biff.AssertEqual(keys.Revoke(key.Id), ErrKeyNotFound)
+}
+
+func TestKeys_Concurrent(t *testing.T) {
+
+	// Setup
+	db := database.NewDatabase(&database.Config{Dir: t.TempDir()})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+	keys := NewKeys(db, "admin-secret")
+
+	// Run: readers while the keys collection is created, and keys are created
+	// and revoked
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key, _, err := keys.Create("ci", false, nil)
+				biff.AssertNil(err)
+				biff.AssertNil(keys.Revoke(key.Id))
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := keys.Authenticate(KeyPrefix + "unknown")
+				biff.AssertEqual(err, ErrUnauthorized)
+				_, err = keys.List()
+				biff.AssertNil(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Check
+	list, err := keys.List()
+	biff.AssertNil(err)
+	biff.AssertEqual(len(list), 0)
+}
+
+func TestPrincipal_Wildcard(t *testing.T) {
+
+	p := &Principal{Scopes: []Scope{{Database: Wildcard, Collection: Wildcard}}}
+
+	biff.AssertTrue(p.Allows("team", "users", RoleAdmin))
+	biff.AssertTrue(p.Allows(database.DefaultDatabase, "", RoleAdmin))
+}
+
+func TestPrincipal_Roles(t *testing.T) {
+
+	p := &Principal{Scopes: []Scope{
+		{Database: "team", Collection: Wildcard, Role: RoleReader},
+		{Database: "team", Collection: "users", Role: RoleWriter},
+	}}
+
+	biff.AssertEqual(p.Role("team", "orders"), RoleReader)
+	biff.AssertEqual(p.Role("team", "users"), RoleWriter) // the highest one
+	biff.AssertTrue(p.Allows("team", "users", RoleReader))
+	biff.AssertFalse(p.Allows("team", "users", RoleAdmin))
+	biff.AssertFalse(p.Allows("other", "users", RoleReader))
+
+	biff.AssertNotNil(Scope{Database: "team", Collection: "users", Role: "owner"}.Validate())
+}
@@ -0,0 +1,53 @@
+package bootstrap
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/fulldump/inceptiondb/configuration"
+)
+
+// Backup loads the data directory and writes a backup archive to filename,
+// it must not be used while another instance is running on the same directory
+func Backup(c *configuration.Configuration, filename string) error {
+
+	db, err := newDatabase(c)
+	if err != nil {
+		return err
+	}
+
+	err = db.Load()
+	if err != nil {
+		return err
+	}
+	defer db.Stop()
+
+	tmpFilename := filename + ".tmp"
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFilename) // Only takes effect if something goes wrong
+
+	manifest, err := db.Backup(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("backup", "file", filename, "databases", len(manifest.Databases)+1, "collections", len(manifest.Collections), "quarantined", len(manifest.Quarantined))
+
+	return nil
+}
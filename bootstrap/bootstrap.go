@@ -2,21 +2,29 @@ package bootstrap
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"sync"
 	"syscall"
 
 	"github.com/fulldump/box"
+	"github.com/google/uuid"
 
 	"github.com/fulldump/inceptiondb/api"
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/cluster"
+	"github.com/fulldump/inceptiondb/collection"
 	"github.com/fulldump/inceptiondb/configuration"
 	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/metrics"
+	"github.com/fulldump/inceptiondb/replication"
 	"github.com/fulldump/inceptiondb/service"
 )
 
@@ -24,43 +32,138 @@ var VERSION = "dev"
 
 func Bootstrap(c *configuration.Configuration) (start, stop func()) {
 
-	db := database.NewDatabase(&database.Config{
-		Dir: c.Dir,
-	})
+	db, err := newDatabase(c)
+	if err != nil {
+		slog.Error("open database", "error", err)
+		os.Exit(-1)
+	}
+
+	svc := service.NewService(db)
+
+	authenticator := auth.Chain{}
+	if c.AuthAdminKey != "" {
+		keys := auth.NewKeys(db, c.AuthAdminKey)
+		svc.SetKeys(keys)
+		authenticator = append(authenticator, keys)
+	}
+	if c.AuthJwks != "" {
+		jwt, err := auth.NewJWT(&auth.JWTOptions{
+			Jwks:     c.AuthJwks,
+			Issuer:   c.AuthJwtIssuer,
+			Audience: c.AuthJwtAudience,
+			Claim:    c.AuthJwtClaim,
+			Leeway:   auth.DefaultJWTOptions().Leeway,
+		})
+		if err != nil {
+			slog.Error("jwt authentication", "error", err)
+			os.Exit(-1)
+		}
+		authenticator = append(authenticator, jwt)
+	}
+
+	var auditLog *audit.Log
+	if c.AuditLog != "" {
+		auditLog, err = newAuditLog(c)
+		if err != nil {
+			slog.Error("audit log", "error", err)
+			os.Exit(-1)
+		}
+		svc.SetAudit(auditLog)
+	}
 
-	b := api.Build(service.NewService(db), c.Statics, VERSION)
+	var node *cluster.Node
+	if c.ClusterNodes != "" {
+		node, err = newClusterNode(c, db)
+		if err != nil {
+			slog.Error("cluster", "error", err)
+			os.Exit(-1)
+		}
+		db.Config.Replicator = node
+		svc.SetClusterNode(node)
+		node.Start()
+	}
+
+	var primary *replication.Primary
+	if c.Peers != "" {
+		primary = replication.NewPrimary(db, strings.Split(c.Peers, ","))
+		if c.AuthPeerKey != "" {
+			primary.Client = auth.NewClient(c.AuthPeerKey)
+		}
+		svc.SetPrimary(primary)
+		primary.Start()
+	}
+
+	var follower *replication.Follower
+	if c.Follow != "" {
+		follower = replication.NewFollower(db, c.Follow)
+		if c.AuthPeerKey != "" {
+			follower.Client = auth.NewClient(c.AuthPeerKey)
+		}
+		svc.SetFollower(follower)
+		follower.Start()
+	}
+
+	b := api.Build(svc, c.Statics, VERSION)
+	b.WithInterceptors(api.InterceptorRequestId)
 	if c.EnableCompression {
 		b.WithInterceptors(api.Compression)
 	}
 	b.WithInterceptors(
-		api.AccessLog(log.New(os.Stdout, "ACCESS: ", log.Lshortfile)),
+		api.AccessLog(slog.Default()),
+		api.InterceptorMetrics(metrics.NewRequests()),
+	)
+	if auditLog != nil {
+		b.WithInterceptors(api.InterceptorAudit(auditLog))
+	}
+	b.WithInterceptors(
 		api.InterceptorUnavailable(db),
 		api.RecoverFromPanic,
 		api.PrettyErrorInterceptor,
 	)
+	if len(authenticator) > 0 {
+		b.WithInterceptors(api.InterceptorAuth(authenticator))
+	}
+	b.WithInterceptors(api.InterceptorReadOnly(db))
 
 	s := &http.Server{
 		Addr:    c.HttpAddr,
 		Handler: box.Box2Http(b),
 	}
 
-	if c.HttpsSelfsigned {
-		log.Println("HTTPS Selfsigned")
-		s.TLSConfig = &tls.Config{
-			Certificates: []tls.Certificate{selfSignedCertificate()},
+	https := c.HttpsEnabled || c.HttpsCert != ""
+	if https {
+		if c.HttpsSelfsigned {
+			slog.Info("https with a self signed certificate")
+		}
+		s.TLSConfig, err = newTLSConfig(c)
+		if err != nil {
+			slog.Error("https", "error", err)
+			os.Exit(-1)
 		}
 	}
 
 	ln, err := net.Listen("tcp", c.HttpAddr)
 	if err != nil {
-		log.Println("ERROR:", err.Error())
+		slog.Error("listen", "addr", c.HttpAddr, "error", err)
 		os.Exit(-1)
 	}
-	log.Println("listening on", c.HttpAddr)
+	slog.Info("listening", "addr", c.HttpAddr)
 
 	stop = func() {
+		if follower != nil {
+			follower.Stop()
+		}
+		if primary != nil {
+			primary.Stop()
+		}
+		if node != nil {
+			node.Stop()
+		}
 		db.Stop()
 		s.Shutdown(context.Background())
+		if auditLog != nil {
+			auditLog.Close()
+		}
 	}
 
 	signalChan := make(chan os.Signal, 1)
@@ -68,7 +171,7 @@ func Bootstrap(c *configuration.Configuration) (start, stop func()) {
 	go func() {
 		for {
 			sig := <-signalChan
-			fmt.Println("Signal received", sig.String())
+			slog.Info("signal received", "signal", sig.String())
 			stop()
 		}
 	}()
@@ -82,7 +185,7 @@ func Bootstrap(c *configuration.Configuration) (start, stop func()) {
 			defer wg.Done()
 			err := db.Start()
 			if err != nil {
-				fmt.Println(err.Error())
+				slog.Error("database", "error", err)
 			}
 		}()
 
@@ -90,13 +193,13 @@ func Bootstrap(c *configuration.Configuration) (start, stop func()) {
 		go func() {
 			defer wg.Done()
 			var err error
-			if c.HttpsEnabled {
+			if https {
 				err = s.ServeTLS(ln, "", "")
 			} else {
 				err = s.Serve(ln)
 			}
 			if err != nil {
-				fmt.Println(err.Error())
+				slog.Info("http server stopped", "error", err)
 			}
 		}()
 
@@ -105,3 +208,83 @@ func Bootstrap(c *configuration.Configuration) (start, stop func()) {
 
 	return
 }
+
+func newDatabase(c *configuration.Configuration) (*database.Database, error) {
+
+	durability := &collection.DurabilityOptions{
+		Mode:     c.Durability,
+		Interval: c.DurabilityInterval,
+	}
+	err := durability.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := loadKeyring(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var clock *collection.HLC
+	if c.Peers != "" {
+		if c.Follow != "" || c.ClusterNodes != "" {
+			return nil, fmt.Errorf("multi-primary mode is not compatible with follow or cluster mode")
+		}
+		clock = collection.NewHLC(uuid.New().String()[:8])
+	}
+
+	return database.NewDatabase(&database.Config{
+		Dir: c.Dir,
+		Compaction: collection.CompactionOptions{
+			GarbageRatio: c.CompactionGarbageRatio,
+			MinCommands:  c.CompactionMinCommands,
+		},
+		Durability: *durability,
+		Segments: collection.SegmentOptions{
+			MaxSize: c.SegmentSize,
+		},
+		Encryption:  keyring,
+		LoadWorkers: c.LoadWorkers,
+		Clock:       clock,
+	}), nil
+}
+
+func newClusterNode(c *configuration.Configuration, db *database.Database) (*cluster.Node, error) {
+
+	if c.Follow != "" {
+		return nil, fmt.Errorf("cluster mode and follow are not compatible")
+	}
+
+	config := cluster.DefaultConfig()
+	config.Id = c.ClusterNode
+	config.Nodes = strings.Split(c.ClusterNodes, ",")
+	config.Dir = c.ClusterDir
+	config.MaxLogEntries = c.ClusterMaxLogEntries
+	if c.AuthPeerKey != "" {
+		config.Transport = &cluster.HTTPTransport{Client: auth.NewClient(c.AuthPeerKey)}
+	}
+	if config.Dir == "" {
+		config.Dir = filepath.Clean(c.Dir) + ".raft"
+	}
+
+	return cluster.NewNode(db, config)
+}
+
+// newAuditLog opens c.AuditLog, it cannot be in the data directory because
+// every file there is loaded as a collection
+func newAuditLog(c *configuration.Configuration) (*audit.Log, error) {
+
+	filename, err := filepath.Abs(c.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(dir, filename); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("AuditLog '%s' is inside the data directory", c.AuditLog)
+	}
+
+	return audit.Open(filename)
+}
@@ -0,0 +1,33 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/configuration"
+)
+
+// loadKeyring returns nil if encryption at rest is not configured
+func loadKeyring(c *configuration.Configuration) (*collection.Keyring, error) {
+
+	if c.EncryptionKey != "" && c.EncryptionKeyFile != "" {
+		return nil, fmt.Errorf("EncryptionKey and EncryptionKeyFile are mutually exclusive")
+	}
+
+	keys := c.EncryptionKey
+	if c.EncryptionKeyFile != "" {
+		data, err := os.ReadFile(c.EncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read encryption keys: %w", err)
+		}
+		keys = string(data)
+	}
+
+	keyring, err := collection.ParseKeyring(keys)
+	if err != nil {
+		return nil, fmt.Errorf("encryption keys: %w", err)
+	}
+
+	return keyring, nil
+}
@@ -0,0 +1,83 @@
+package bootstrap
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/configuration"
+	"github.com/fulldump/inceptiondb/database"
+)
+
+// Restore extracts c.Restore into the data directory (if set) and then rewinds
+// the journals to c.RestoreUntil or c.RestoreUntilUuid (if set). The database
+// must not be running.
+func Restore(c *configuration.Configuration) error {
+
+	keyring, err := loadKeyring(c)
+	if err != nil {
+		return err
+	}
+
+	target, err := recoveryTarget(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Restore != "" {
+		f, err := os.Open(c.Restore)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		manifest, err := database.Restore(f, c.Dir)
+		if err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		slog.Info("restored", "backup", c.Restore, "dir", c.Dir, "databases", len(manifest.Databases)+1, "collections", len(manifest.Collections), "created_at", manifest.CreatedAt.Format(time.RFC3339))
+	}
+
+	if target == nil {
+		return nil
+	}
+
+	results, err := database.Rewind(c.Dir, c.RestoreDatabase, c.RestoreCollection, target, keyring)
+	for _, result := range results {
+		slog.Info("rewound", "database", result.Database, "collection", result.Collection, "kept", result.Kept, "discarded", result.Discarded, "rewound", result.Rewound, "dry_run", target.DryRun)
+	}
+	if err != nil {
+		return fmt.Errorf("rewind: %w", err)
+	}
+
+	return nil
+}
+
+func recoveryTarget(c *configuration.Configuration) (*collection.RecoveryTarget, error) {
+
+	if c.RestoreUntil == "" && c.RestoreUntilUuid == "" {
+		return nil, nil
+	}
+
+	target := &collection.RecoveryTarget{
+		Uuid:   c.RestoreUntilUuid,
+		DryRun: c.RestoreDryRun,
+	}
+
+	if c.RestoreUntil != "" {
+		t, err := time.Parse(time.RFC3339Nano, c.RestoreUntil)
+		if err == nil {
+			target.Timestamp = t.UnixNano()
+		} else {
+			target.Timestamp, err = strconv.ParseInt(c.RestoreUntil, 10, 64)
+		}
+		if err != nil || target.Timestamp <= 0 {
+			return nil, fmt.Errorf("RestoreUntil: expected RFC3339 time or unix nanoseconds, got '%s'", c.RestoreUntil)
+		}
+	}
+
+	return target, nil
+}
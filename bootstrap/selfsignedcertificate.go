@@ -10,8 +10,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
-	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
 	"os"
 	"time"
@@ -35,7 +34,7 @@ func pemBlockForKey(priv interface{}) *pem.Block {
 	case *ecdsa.PrivateKey:
 		b, err := x509.MarshalECPrivateKey(k)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Unable to marshal ECDSA private key: %v", err)
+			slog.Error("marshal ECDSA private key", "error", err)
 			os.Exit(2)
 		}
 		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}
@@ -47,7 +46,8 @@ func pemBlockForKey(priv interface{}) *pem.Block {
 func selfSignedCertificate() tls.Certificate {
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("generate key", "error", err)
+		os.Exit(2)
 	}
 	template := x509.Certificate{
 		SerialNumber: big.NewInt(1),
@@ -63,7 +63,8 @@ func selfSignedCertificate() tls.Certificate {
 
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
 	if err != nil {
-		log.Fatalf("Failed to create certificate: %s", err)
+		slog.Error("create certificate", "error", err)
+		os.Exit(2)
 	}
 
 	certPEMBlock := &bytes.Buffer{}
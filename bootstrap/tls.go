@@ -0,0 +1,151 @@
+package bootstrap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fulldump/inceptiondb/configuration"
+)
+
+// tlsCheckInterval is how often the certificate files are checked for changes
+var tlsCheckInterval = 5 * time.Second
+
+// tlsFiles builds the TLS configuration of the server and reads the
+// certificate, key and client CA files again when they change (e.g. renewed
+// by certbot or cert-manager), so there is no need to restart. If the new
+// files are wrong the previous ones are kept.
+type tlsFiles struct {
+	certFile  string
+	keyFile   string
+	caFile    string
+	clientCas bool // verify client certificates
+	require   bool // reject clients without certificate
+
+	mutex     *sync.Mutex
+	checkedAt time.Time
+	modTimes  map[string]time.Time
+	config    *tls.Config
+}
+
+func newTLSConfig(c *configuration.Configuration) (*tls.Config, error) {
+
+	if (c.HttpsCert == "") != (c.HttpsKey == "") {
+		return nil, fmt.Errorf("HttpsCert and HttpsKey must be used together")
+	}
+	if c.HttpsCert != "" && c.HttpsSelfsigned {
+		return nil, fmt.Errorf("HttpsCert and HttpsSelfsigned are not compatible")
+	}
+	if c.HttpsCert == "" && !c.HttpsSelfsigned {
+		return nil, fmt.Errorf("https needs a certificate, use HttpsCert and HttpsKey or HttpsSelfsigned")
+	}
+	if c.HttpsClientCertRequired && c.HttpsClientCa == "" {
+		return nil, fmt.Errorf("HttpsClientCertRequired needs HttpsClientCa")
+	}
+
+	t := &tlsFiles{
+		certFile:  c.HttpsCert,
+		keyFile:   c.HttpsKey,
+		caFile:    c.HttpsClientCa,
+		clientCas: c.HttpsClientCa != "",
+		require:   c.HttpsClientCertRequired,
+		mutex:     &sync.Mutex{},
+		modTimes:  map[string]time.Time{},
+		config:    &tls.Config{},
+	}
+	if c.HttpsSelfsigned {
+		t.config.Certificates = []tls.Certificate{selfSignedCertificate()}
+	}
+
+	err := t.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if t.certFile == "" && t.caFile == "" {
+		return t.config, nil // nothing to reload
+	}
+
+	return &tls.Config{
+		GetConfigForClient: t.getConfigForClient,
+	}, nil
+}
+
+func (t *tlsFiles) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if time.Since(t.checkedAt) > tlsCheckInterval {
+		t.checkedAt = time.Now()
+		if t.changed() {
+			err := t.load()
+			if err != nil {
+				slog.Warn("reload tls files", "error", err)
+			}
+		}
+	}
+
+	return t.config, nil
+}
+
+// changed tells if a file has been modified since the last load
+func (t *tlsFiles) changed() bool {
+	for filename, modTime := range t.modTimes {
+		info, err := os.Stat(filename)
+		if err == nil && !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// load replaces config only if every file is valid
+func (t *tlsFiles) load() error {
+
+	config := t.config.Clone()
+	modTimes := map[string]time.Time{}
+
+	for _, filename := range []string{t.certFile, t.keyFile, t.caFile} {
+		if filename == "" {
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		modTimes[filename] = info.ModTime()
+	}
+
+	if t.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if t.clientCas {
+		data, err := os.ReadFile(t.caFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in '%s'", t.caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if t.require {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	t.config = config
+	t.modTimes = modTimes
+	return nil
+}
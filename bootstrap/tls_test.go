@@ -0,0 +1,92 @@
+package bootstrap
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/configuration"
+)
+
+func writeTestCertificate(t *testing.T, certFile, keyFile, name string, modTime time.Time) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	biff.AssertNil(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	biff.AssertNil(err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	biff.AssertNil(err)
+
+	biff.AssertNil(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	biff.AssertNil(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func TestTLSConfig_Reload(t *testing.T) {
+
+	// Setup
+	dir := t.TempDir()
+	c := configuration.Default()
+	c.HttpsCert = filepath.Join(dir, "cert.pem")
+	c.HttpsKey = filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writeTestCertificate(t, c.HttpsCert, c.HttpsKey, "first", now.Add(-time.Minute))
+	config, err := newTLSConfig(c)
+	biff.AssertNil(err)
+
+	commonName := func() string {
+		current, err := config.GetConfigForClient(nil)
+		biff.AssertNil(err)
+		leaf, err := x509.ParseCertificate(current.Certificates[0].Certificate[0])
+		biff.AssertNil(err)
+		return leaf.Subject.CommonName
+	}
+	defer func(interval time.Duration) { tlsCheckInterval = interval }(tlsCheckInterval)
+	tlsCheckInterval = 0
+
+	// Run
+	biff.AssertEqual(commonName(), "first")
+	writeTestCertificate(t, c.HttpsCert, c.HttpsKey, "second", now)
+
+	// Check
+	biff.AssertEqual(commonName(), "second")
+
+	os.WriteFile(c.HttpsCert, []byte("broken"), 0644)
+	os.Chtimes(c.HttpsCert, now.Add(time.Minute), now.Add(time.Minute))
+	biff.AssertEqual(commonName(), "second") // keeps the previous one
+}
+
+func TestTLSConfig_Invalid(t *testing.T) {
+
+	c := configuration.Default()
+	c.HttpsEnabled = true
+	_, err := newTLSConfig(c)
+	biff.AssertNotNil(err) // without certificate
+
+	c.HttpsCert = "cert.pem"
+	_, err = newTLSConfig(c)
+	biff.AssertNotNil(err) // without key
+
+	c.HttpsCert = ""
+	c.HttpsSelfsigned = true
+	c.HttpsClientCertRequired = true
+	_, err = newTLSConfig(c)
+	biff.AssertNotNil(err) // without client ca
+}
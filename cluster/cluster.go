@@ -0,0 +1,120 @@
+// Package cluster replicates the database among three or five nodes with the
+// Raft consensus protocol. Every change (creating or dropping a collection
+// and every journal command) is an Entry of the raft log. Every node applies
+// the entries in log order once they are committed (a quorum of nodes has
+// them in its log). The leader proposes the changes without applying them and
+// acknowledges them once it has applied them, so writes are linearizable and
+// a change that is not committed never reaches the database.
+//
+// Nodes talk over HTTP (see HTTPTransport) and are identified by their base
+// url. The raft log is the source of truth: the collections of a node are
+// rebuilt from it every time the node starts. The log is not compacted (there
+// are no snapshots yet), it is kept in memory and replayed on every start, so
+// it is capped by Config.MaxLogEntries: once full the leader rejects writes.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	RoleFollower  = "follower"
+	RoleCandidate = "candidate"
+	RoleLeader    = "leader"
+)
+
+var ErrNotLeader = errors.New("this node is not the cluster leader")
+var ErrLeadershipLost = errors.New("leadership lost before the change was committed")
+var ErrCommitTimeout = errors.New("timeout waiting for a quorum to commit the change")
+var ErrStopped = errors.New("cluster node is stopped")
+var ErrLogFull = errors.New("the cluster log is full")
+
+type Config struct {
+	Id    string   // base url of this node, must be one of Nodes
+	Nodes []string // base urls of all the nodes
+	Dir   string   // raft log and state
+	// ElectionTimeout is the minimum time without hearing from a leader before
+	// starting an election, the actual one is randomized up to twice it
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+	// CommitTimeout is how long a change waits for a quorum
+	CommitTimeout time.Duration
+	// MaxLogEntries bounds the memory and the start time of the nodes, zero
+	// means no limit
+	MaxLogEntries int64
+	Transport     Transport
+}
+
+const DefaultMaxLogEntries = 10_000_000
+
+func DefaultConfig() *Config {
+	return &Config{
+		ElectionTimeout:   time.Second,
+		HeartbeatInterval: 100 * time.Millisecond,
+		CommitTimeout:     5 * time.Second,
+		MaxLogEntries:     DefaultMaxLogEntries,
+		Transport:         NewHTTPTransport(),
+	}
+}
+
+// Transport sends a request to another node and decodes its response
+type Transport interface {
+	Send(ctx context.Context, node, rpc string, request, response any) error
+}
+
+// RPC names
+const (
+	RPCVote   = "vote"
+	RPCAppend = "append"
+)
+
+type VoteRequest struct {
+	Term         int64  `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex int64  `json:"last_log_index"`
+	LastLogTerm  int64  `json:"last_log_term"`
+}
+
+type VoteResponse struct {
+	Term    int64 `json:"term"`
+	Granted bool  `json:"granted"`
+}
+
+type AppendRequest struct {
+	Term         int64    `json:"term"`
+	Leader       string   `json:"leader"`
+	PrevLogIndex int64    `json:"prev_log_index"`
+	PrevLogTerm  int64    `json:"prev_log_term"`
+	Entries      []*Entry `json:"entries"`
+	LeaderCommit int64    `json:"leader_commit"`
+}
+
+type AppendResponse struct {
+	Term    int64 `json:"term"`
+	Success bool  `json:"success"`
+	// ConflictIndex is where the leader should continue from when Success is
+	// false because of the log consistency check
+	ConflictIndex int64 `json:"conflict_index,omitempty"`
+}
+
+type Status struct {
+	Id          string        `json:"id"`
+	Role        string        `json:"role"`
+	Term        int64         `json:"term"`
+	Leader      string        `json:"leader,omitempty"`
+	LastIndex   int64         `json:"last_index"`
+	CommitIndex int64         `json:"commit_index"`
+	Applied     int64         `json:"applied"`
+	Ready       bool          `json:"ready"` // accepting writes
+	Nodes       []*NodeStatus `json:"nodes,omitempty"`
+}
+
+// NodeStatus is how the leader sees the other nodes
+type NodeStatus struct {
+	Id          string    `json:"id"`
+	MatchIndex  int64     `json:"match_index"`
+	LastContact time.Time `json:"last_contact"`
+	Error       string    `json:"error,omitempty"`
+}
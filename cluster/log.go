@@ -0,0 +1,277 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+const (
+	logFilename   = "log.jsonl"
+	stateFilename = "state.json"
+)
+
+// Entry types
+const (
+	EntryNoop             = "noop" // appended by every new leader
+	EntryCommand          = "command"
+	EntryCreateCollection = "create_collection"
+	EntryDropCollection   = "drop_collection"
+)
+
+type Entry struct {
+	Index      int64               `json:"index"`
+	Term       int64               `json:"term"`
+	Type       string              `json:"type"`
+	Collection string              `json:"collection,omitempty"`
+	Command    *collection.Command `json:"command,omitempty"`
+}
+
+// persistentState must be saved before answering any request
+type persistentState struct {
+	Term     int64  `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// raftLog keeps all the entries in memory and appends them to a JSON lines
+// file. It is never compacted, see Config.MaxLogEntries. todo: snapshots
+type raftLog struct {
+	dir     string
+	file    *os.File
+	buffer  *bufio.Writer
+	entries []*Entry // entries[i].Index == i+1
+	offsets []int64  // where every entry starts in the file
+	size    int64
+	synced  int64 // last index fsynced
+}
+
+func openLog(dir string) (*raftLog, error) {
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &raftLog{
+		dir: dir,
+	}
+
+	filename := path.Join(dir, logFilename)
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				slog.Warn("cluster: discarding torn entry", "file", filename, "offset", l.size)
+			}
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		entry := &Entry{}
+		err = json.Unmarshal(line, entry)
+		if err != nil || entry.Index != int64(len(l.entries))+1 {
+			f.Close()
+			return nil, fmt.Errorf("invalid entry at byte %d of '%s'", l.size, filename)
+		}
+		l.entries = append(l.entries, entry)
+		l.offsets = append(l.offsets, l.size)
+		l.size += int64(len(line))
+	}
+
+	err = f.Truncate(l.size)
+	if err == nil {
+		_, err = f.Seek(l.size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l.file = f
+	l.buffer = bufio.NewWriterSize(f, 1024*1024)
+	l.synced = l.lastIndex()
+
+	return l, nil
+}
+
+func (l *raftLog) lastIndex() int64 {
+	return int64(len(l.entries))
+}
+
+func (l *raftLog) lastTerm() int64 {
+	return l.term(l.lastIndex())
+}
+
+// term returns the term of the entry at index, zero if it does not exist
+func (l *raftLog) term(index int64) int64 {
+	if index <= 0 || index > l.lastIndex() {
+		return 0
+	}
+	return l.entries[index-1].Term
+}
+
+func (l *raftLog) get(index int64) *Entry {
+	if index <= 0 || index > l.lastIndex() {
+		return nil
+	}
+	return l.entries[index-1]
+}
+
+// slice returns up to max entries from index on
+func (l *raftLog) slice(index int64, max int) []*Entry {
+	if index <= 0 || index > l.lastIndex() {
+		return nil
+	}
+	entries := l.entries[index-1:]
+	if len(entries) > max {
+		entries = entries[:max]
+	}
+	return entries
+}
+
+// append writes the entry at the end of the log, it is not durable until sync
+func (l *raftLog) append(entry *Entry) error {
+
+	entry.Index = l.lastIndex() + 1
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	_, err = l.buffer.Write(data)
+	if err != nil {
+		return err
+	}
+
+	l.entries = append(l.entries, entry)
+	l.offsets = append(l.offsets, l.size)
+	l.size += int64(len(data))
+
+	return nil
+}
+
+// truncate removes the entries from index on
+func (l *raftLog) truncate(index int64) error {
+
+	if index > l.lastIndex() {
+		return nil
+	}
+
+	err := l.flush()
+	if err != nil {
+		return err
+	}
+
+	size := l.offsets[index-1]
+	err = l.file.Truncate(size)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Seek(size, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	l.entries = l.entries[:index-1]
+	l.offsets = l.offsets[:index-1]
+	l.size = size
+	l.synced = min(l.synced, l.lastIndex())
+
+	return nil
+}
+
+// flush writes the buffered entries into the file, they are not durable
+// until the file is synced
+func (l *raftLog) flush() error {
+	return l.buffer.Flush()
+}
+
+// sync makes all the entries durable
+func (l *raftLog) sync() error {
+
+	if l.synced == l.lastIndex() {
+		return nil
+	}
+
+	err := l.flush()
+	if err != nil {
+		return err
+	}
+	err = l.file.Sync()
+	if err != nil {
+		return err
+	}
+	l.synced = l.lastIndex()
+
+	return nil
+}
+
+func (l *raftLog) close() error {
+	err := l.sync()
+	if err != nil {
+		return err
+	}
+	return l.file.Close()
+}
+
+func (l *raftLog) readState() (*persistentState, error) {
+
+	state := &persistentState{}
+
+	data, err := os.ReadFile(path.Join(l.dir, stateFilename))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", stateFilename, err)
+	}
+
+	return state, nil
+}
+
+func (l *raftLog) writeState(state *persistentState) error {
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	filename := path.Join(l.dir, stateFilename)
+	tmp := filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filename)
+}
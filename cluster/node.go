@@ -0,0 +1,883 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/database"
+)
+
+// maxAppendEntries is the maximum number of entries sent in one AppendRequest
+const maxAppendEntries = 1000
+
+// Node is a member of the cluster, it implements database.Replicator
+type Node struct {
+	config *Config
+	db     *database.Database
+	log    *raftLog
+
+	mutex   *sync.Mutex
+	changed *sync.Cond // broadcast on every change of the fields below
+
+	role        string
+	term        int64
+	votedFor    string
+	leader      string
+	deadline    time.Time // of the election timeout
+	commitIndex int64
+	applied     int64 // last entry reflected in the database
+	ready       bool  // leader with the entries of former leaders applied, accepting writes
+	leaderIndex int64 // first entry of the current leadership
+	diverged    bool  // the database must be rebuilt from the log
+	rebuilding  bool
+	stopped     bool
+	peers       []*peer
+	proposals   map[int64]*proposal // entries proposed by this node, until they are applied
+
+	syncTrigger chan struct{}
+	cancel      context.CancelFunc
+	wg          *sync.WaitGroup
+}
+
+// proposal is the outcome of applying an entry proposed by the node
+type proposal struct {
+	term    int64
+	applied bool
+	err     error
+}
+
+type peer struct {
+	id          string
+	next        int64 // next entry to send
+	match       int64 // last entry known to be in its log
+	lastContact time.Time
+	err         string
+	trigger     chan struct{}
+}
+
+// NewNode opens the raft log at config.Dir. The database must be empty the
+// first time, after that its collections are rebuilt from the log on Start.
+// Set db.Config.Replicator to the node before loading the database.
+func NewNode(db *database.Database, config *Config) (*Node, error) {
+
+	config.Id = strings.TrimSuffix(config.Id, "/")
+	nodes := []string{}
+	for _, node := range config.Nodes {
+		nodes = append(nodes, strings.TrimSuffix(strings.TrimSpace(node), "/"))
+	}
+	if !slices.Contains(nodes, config.Id) {
+		return nil, fmt.Errorf("node '%s' is not one of the cluster nodes %v", config.Id, nodes)
+	}
+
+	_, err := os.Stat(config.Dir)
+	fresh := os.IsNotExist(err)
+	if fresh {
+		files, _ := os.ReadDir(db.Config.Dir)
+		if len(files) > 0 {
+			return nil, fmt.Errorf("data directory '%s' must be empty to join a cluster", db.Config.Dir)
+		}
+	}
+
+	l, err := openLog(config.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("open raft log: %w", err)
+	}
+	state, err := l.readState()
+	if err != nil {
+		l.close()
+		return nil, err
+	}
+
+	n := &Node{
+		config:      config,
+		db:          db,
+		log:         l,
+		mutex:       &sync.Mutex{},
+		role:        RoleFollower,
+		term:        state.Term,
+		votedFor:    state.VotedFor,
+		diverged:    true, // drop whatever was loaded, the log is the source of truth
+		proposals:   map[int64]*proposal{},
+		syncTrigger: make(chan struct{}, 1),
+		wg:          &sync.WaitGroup{},
+	}
+	n.changed = sync.NewCond(n.mutex)
+
+	for _, node := range nodes {
+		if node == config.Id {
+			continue
+		}
+		n.peers = append(n.peers, &peer{
+			id:      node,
+			trigger: make(chan struct{}, 1),
+		})
+	}
+
+	return n, nil
+}
+
+func (n *Node) Start() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+
+	n.mutex.Lock()
+	n.resetDeadline()
+	n.mutex.Unlock()
+
+	n.goRun(func() { n.tick(ctx) })
+	n.goRun(func() { n.syncLoop(ctx) })
+	n.goRun(n.applyLoop)
+	n.goRun(n.readOnlyLoop)
+	for _, p := range n.peers {
+		p := p
+		n.goRun(func() { n.replicate(ctx, p) })
+	}
+}
+
+func (n *Node) goRun(f func()) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		f()
+	}()
+}
+
+func (n *Node) Stop() error {
+
+	n.mutex.Lock()
+	n.stopped = true
+	n.ready = false
+	n.changed.Broadcast()
+	n.mutex.Unlock()
+
+	if n.cancel != nil {
+		n.cancel()
+	}
+	n.wg.Wait()
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.log.close()
+}
+
+func (n *Node) Status() *Status {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	status := &Status{
+		Id:          n.config.Id,
+		Role:        n.role,
+		Term:        n.term,
+		Leader:      n.leader,
+		LastIndex:   n.log.lastIndex(),
+		CommitIndex: n.commitIndex,
+		Applied:     n.applied,
+		Ready:       n.ready,
+	}
+	if n.role == RoleLeader {
+		for _, p := range n.peers {
+			status.Nodes = append(status.Nodes, &NodeStatus{
+				Id:          p.id,
+				MatchIndex:  p.match,
+				LastContact: p.lastContact,
+				Error:       p.err,
+			})
+		}
+	}
+
+	return status
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// resetDeadline must be called holding the mutex
+func (n *Node) resetDeadline() {
+	timeout := n.config.ElectionTimeout
+	n.deadline = time.Now().Add(timeout + time.Duration(rand.Int63n(int64(timeout))))
+}
+
+// saveState must be called holding the mutex
+func (n *Node) saveState() error {
+	return n.log.writeState(&persistentState{
+		Term:     n.term,
+		VotedFor: n.votedFor,
+	})
+}
+
+// tick starts elections and steps down leaders that cannot reach a quorum
+func (n *Node) tick(ctx context.Context) {
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n.mutex.Lock()
+		now := time.Now()
+		switch {
+		case n.role == RoleLeader && !n.quorumContact(now):
+			slog.Warn("cluster: leader cannot reach a quorum, stepping down", "term", n.term)
+			n.becomeFollower(n.term)
+		case n.role != RoleLeader && now.After(n.deadline):
+			n.startElection(ctx)
+		}
+		n.mutex.Unlock()
+	}
+}
+
+// quorumContact returns if the leader heard from a quorum in the last
+// election timeout
+func (n *Node) quorumContact(now time.Time) bool {
+	contacts := 1
+	for _, p := range n.peers {
+		if now.Sub(p.lastContact) < n.config.ElectionTimeout {
+			contacts++
+		}
+	}
+	return contacts >= n.quorum()
+}
+
+// startElection must be called holding the mutex
+func (n *Node) startElection(ctx context.Context) {
+
+	n.role = RoleCandidate
+	n.term++
+	n.votedFor = n.config.Id
+	n.leader = ""
+	n.ready = false
+	n.resetDeadline()
+	n.changed.Broadcast()
+	err := n.saveState()
+	if err != nil {
+		slog.Error("cluster: save state", "error", err)
+		n.role = RoleFollower
+		return
+	}
+
+	request := &VoteRequest{
+		Term:         n.term,
+		Candidate:    n.config.Id,
+		LastLogIndex: n.log.lastIndex(),
+		LastLogTerm:  n.log.lastTerm(),
+	}
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+
+	for _, p := range n.peers {
+		p := p
+		n.goRun(func() {
+			ctx, cancel := context.WithTimeout(ctx, n.config.ElectionTimeout)
+			defer cancel()
+			response := &VoteResponse{}
+			err := n.config.Transport.Send(ctx, p.id, RPCVote, request, response)
+			if err != nil {
+				return
+			}
+
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+			if response.Term > n.term {
+				n.becomeFollower(response.Term)
+				return
+			}
+			if n.role != RoleCandidate || n.term != request.Term || !response.Granted {
+				return
+			}
+			votes++
+			if votes == n.quorum() {
+				n.becomeLeader()
+			}
+		})
+	}
+}
+
+// becomeLeader must be called holding the mutex
+func (n *Node) becomeLeader() {
+
+	n.role = RoleLeader
+	n.leader = n.config.Id
+	now := time.Now()
+	for _, p := range n.peers {
+		p.next = n.log.lastIndex() + 1
+		p.match = 0
+		p.lastContact = now // grace period to reach them
+	}
+
+	// Entries of previous terms are committed with the first one of this term
+	err := n.log.append(&Entry{
+		Term: n.term,
+		Type: EntryNoop,
+	})
+	if err != nil {
+		slog.Error("cluster: append", "error", err)
+		n.becomeFollower(n.term)
+		return
+	}
+	n.leaderIndex = n.log.lastIndex()
+
+	slog.Info("cluster: leader", "term", n.term)
+	n.changed.Broadcast()
+	n.triggerSync()
+	n.triggerPeers()
+}
+
+// becomeFollower must be called holding the mutex
+func (n *Node) becomeFollower(term int64) {
+
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+		err := n.saveState()
+		if err != nil {
+			slog.Error("cluster: save state", "error", err)
+		}
+	}
+	if n.role == RoleLeader {
+		n.leader = ""
+	}
+
+	n.role = RoleFollower
+	n.ready = false
+	n.resetDeadline()
+	n.changed.Broadcast()
+}
+
+func (n *Node) triggerSync() {
+	select {
+	case n.syncTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func (n *Node) triggerPeers() {
+	for _, p := range n.peers {
+		select {
+		case p.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// syncLoop makes the entries of the leader durable, which counts as its own
+// acknowledgement
+func (n *Node) syncLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-n.syncTrigger:
+		}
+
+		n.mutex.Lock()
+		if n.role != RoleLeader || n.log.synced == n.log.lastIndex() {
+			n.mutex.Unlock()
+			continue
+		}
+		index, term := n.log.lastIndex(), n.log.lastTerm()
+		err := n.log.flush()
+		n.mutex.Unlock()
+
+		if err == nil {
+			err = n.log.file.Sync() // concurrent appends only touch the buffer
+		}
+
+		n.mutex.Lock()
+		if err != nil {
+			slog.Error("cluster: sync raft log", "error", err)
+		} else if n.log.term(index) == term {
+			n.log.synced = max(n.log.synced, index)
+			n.advanceCommit()
+		}
+		n.mutex.Unlock()
+	}
+}
+
+// advanceCommit must be called holding the mutex by the leader
+func (n *Node) advanceCommit() {
+
+	if n.role != RoleLeader {
+		return
+	}
+
+	matches := []int64{n.log.synced}
+	for _, p := range n.peers {
+		matches = append(matches, p.match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i] > matches[j]
+	})
+
+	index := matches[n.quorum()-1]
+	if index > n.commitIndex && n.log.term(index) == n.term {
+		n.commitIndex = index
+		n.changed.Broadcast()
+		n.triggerPeers() // let them know
+	}
+}
+
+// replicate sends the log of the leader to a peer
+func (n *Node) replicate(ctx context.Context, p *peer) {
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.trigger:
+		case <-ticker.C:
+		}
+
+		for n.sendAppend(ctx, p) {
+		}
+	}
+}
+
+// sendAppend returns true if there are more entries to send
+func (n *Node) sendAppend(ctx context.Context, p *peer) bool {
+
+	n.mutex.Lock()
+	if n.role != RoleLeader {
+		n.mutex.Unlock()
+		return false
+	}
+	prev := p.next - 1
+	request := &AppendRequest{
+		Term:         n.term,
+		Leader:       n.config.Id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  n.log.term(prev),
+		Entries:      slices.Clone(n.log.slice(p.next, maxAppendEntries)),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mutex.Unlock()
+
+	rctx, cancel := context.WithTimeout(ctx, n.config.ElectionTimeout)
+	response := &AppendResponse{}
+	err := n.config.Transport.Send(rctx, p.id, RPCAppend, request, response)
+	cancel()
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if err != nil {
+		if p.err == "" && ctx.Err() == nil {
+			slog.Warn("cluster: node is not reachable", "node", p.id, "error", err)
+		}
+		p.err = err.Error()
+		return false
+	}
+	if p.err != "" {
+		slog.Info("cluster: node is reachable again", "node", p.id)
+		p.err = ""
+	}
+
+	if response.Term > n.term {
+		n.becomeFollower(response.Term)
+		return false
+	}
+	if n.role != RoleLeader || n.term != request.Term {
+		return false
+	}
+	p.lastContact = time.Now()
+
+	if !response.Success {
+		next := p.next - 1
+		if response.ConflictIndex > 0 {
+			next = min(next, response.ConflictIndex)
+		}
+		p.next = max(1, next)
+		return true
+	}
+
+	match := prev + int64(len(request.Entries))
+	if match > p.match {
+		p.match = match
+		n.advanceCommit()
+	}
+	p.next = max(p.next, match+1)
+
+	return p.next <= n.log.lastIndex()
+}
+
+func (n *Node) HandleVote(request *VoteRequest) (*VoteResponse, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if request.Term > n.term {
+		n.becomeFollower(request.Term)
+	}
+
+	response := &VoteResponse{
+		Term: n.term,
+	}
+
+	upToDate := request.LastLogTerm > n.log.lastTerm() ||
+		(request.LastLogTerm == n.log.lastTerm() && request.LastLogIndex >= n.log.lastIndex())
+	if request.Term < n.term || !upToDate {
+		return response, nil
+	}
+	if n.votedFor != "" && n.votedFor != request.Candidate {
+		return response, nil
+	}
+
+	n.votedFor = request.Candidate
+	err := n.saveState()
+	if err != nil {
+		return nil, err
+	}
+	n.resetDeadline()
+	response.Granted = true
+
+	return response, nil
+}
+
+func (n *Node) HandleAppend(request *AppendRequest) (*AppendResponse, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+
+	if request.Term < n.term {
+		return &AppendResponse{Term: n.term}, nil
+	}
+	if request.Term > n.term || n.role != RoleFollower {
+		n.becomeFollower(request.Term)
+	}
+	if n.leader != request.Leader {
+		slog.Info("cluster: following", "leader", request.Leader, "term", n.term)
+		n.leader = request.Leader
+		n.changed.Broadcast()
+	}
+	n.resetDeadline()
+
+	response := &AppendResponse{
+		Term: n.term,
+	}
+
+	// Consistency check
+	if request.PrevLogIndex > n.log.lastIndex() {
+		response.ConflictIndex = n.log.lastIndex() + 1
+		return response, nil
+	}
+	if term := n.log.term(request.PrevLogIndex); term != request.PrevLogTerm {
+		// Skip the whole conflicting term
+		index := request.PrevLogIndex
+		for index > 1 && n.log.term(index-1) == term {
+			index--
+		}
+		response.ConflictIndex = index
+		return response, nil
+	}
+
+	for _, entry := range request.Entries {
+		existing := n.log.get(entry.Index)
+		if existing != nil && existing.Term == entry.Term {
+			continue
+		}
+		if existing != nil {
+			if entry.Index <= n.commitIndex {
+				return nil, fmt.Errorf("entry %d is already committed", entry.Index)
+			}
+			// changes of a former leadership that were never committed, nor
+			// applied
+			err := n.log.truncate(entry.Index)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := n.log.append(entry)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := n.log.sync()
+	if err != nil {
+		return nil, err
+	}
+
+	last := request.PrevLogIndex + int64(len(request.Entries))
+	if request.LeaderCommit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(request.LeaderCommit, last))
+	}
+	n.changed.Broadcast()
+
+	response.Success = true
+	return response, nil
+}
+
+// applyLoop applies the committed entries to the database
+func (n *Node) applyLoop() {
+
+	for n.db.GetStatus() != database.StatusOperating {
+		n.mutex.Lock()
+		stopped := n.stopped
+		n.mutex.Unlock()
+		if stopped {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	for {
+		n.mutex.Lock()
+		for !n.stopped && !n.diverged && n.applied >= n.commitIndex {
+			n.changed.Wait()
+		}
+		if n.stopped {
+			n.mutex.Unlock()
+			return
+		}
+
+		if n.diverged {
+			n.diverged = false
+			n.rebuilding = true
+			n.applied = 0
+			n.ready = false
+			n.changed.Broadcast()
+			n.mutex.Unlock()
+
+			slog.Info("cluster: rebuilding the database from the raft log")
+			err := n.dropCollections()
+
+			n.mutex.Lock()
+			n.rebuilding = false
+			if err != nil {
+				slog.Error("cluster: rebuild database", "error", err)
+				n.diverged = true
+			}
+			n.changed.Broadcast()
+			n.mutex.Unlock()
+			if err != nil {
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		entries := slices.Clone(n.log.slice(n.applied+1, int(n.commitIndex-n.applied)))
+		n.mutex.Unlock()
+
+		for _, entry := range entries {
+			err := n.apply(entry)
+			if err != nil {
+				// Entries are applied in the same order everywhere, so they
+				// fail everywhere
+				slog.Warn("cluster: apply entry", "entry", entry.Index, "error", err)
+			}
+
+			n.mutex.Lock()
+			if n.diverged || n.stopped {
+				n.mutex.Unlock()
+				break
+			}
+			n.applied = entry.Index
+			n.ready = n.role == RoleLeader && n.applied >= n.leaderIndex
+			if p, exists := n.proposals[entry.Index]; exists {
+				delete(n.proposals, entry.Index)
+				if p.term == entry.Term {
+					p.applied = true
+					p.err = err
+				}
+			}
+			n.changed.Broadcast()
+			n.mutex.Unlock()
+		}
+	}
+}
+
+func (n *Node) apply(entry *Entry) error {
+	switch entry.Type {
+	case EntryNoop:
+		return nil
+	case EntryCreateCollection:
+		_, err := n.db.ApplyCreateCollection(entry.Collection)
+		return err
+	case EntryDropCollection:
+		return n.db.ApplyDropCollection(entry.Collection)
+	case EntryCommand:
+		col, err := n.db.GetCollection(entry.Collection)
+		if err != nil {
+			return err
+		}
+		return col.ApplyCommand(entry.Command)
+	}
+	return fmt.Errorf("unknown entry type '%s'", entry.Type)
+}
+
+// dropCollections empties the database to apply the log from the beginning
+func (n *Node) dropCollections() error {
+	names := []string{}
+	for name := range n.db.ListCollections() {
+		names = append(names, name)
+	}
+	for _, q := range n.db.Quarantined() {
+		names = append(names, q.Name)
+	}
+	for _, name := range names {
+		err := n.db.ApplyDropCollection(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOnlyLoop makes the database read only unless the node is a ready leader
+func (n *Node) readOnlyLoop() {
+
+	n.mutex.Lock()
+	last := "-"
+	for !n.stopped {
+		reason := n.readOnlyReason()
+		if reason == last {
+			n.changed.Wait()
+			continue
+		}
+		last = reason
+		n.mutex.Unlock()
+		n.db.SetReadOnly(reason) // not holding the mutex, the database calls the node holding its own
+		n.mutex.Lock()
+	}
+	n.mutex.Unlock()
+
+	n.db.SetReadOnly(ErrStopped.Error())
+}
+
+// readOnlyReason must be called holding the mutex
+func (n *Node) readOnlyReason() string {
+	switch {
+	case n.role == RoleLeader && n.logFull():
+		return fmt.Sprintf("%s: %d entries", ErrLogFull, n.log.lastIndex())
+	case n.ready:
+		return ""
+	case n.rebuilding:
+		return "this node is rebuilding its database from the cluster log"
+	case n.role == RoleLeader:
+		return "this node is the cluster leader but it is not ready yet"
+	case n.leader != "":
+		return fmt.Sprintf("writes must go to the cluster leader %s", n.leader)
+	}
+	return "the cluster has no leader"
+}
+
+// propose appends a change to the log, the returned function waits until it
+// is committed and applied and returns the error of applying it
+func (n *Node) propose(entry *Entry) (func() error, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if !n.ready {
+		return nil, ErrNotLeader
+	}
+	if n.logFull() {
+		return nil, ErrLogFull
+	}
+
+	entry.Term = n.term
+	err := n.log.append(entry)
+	if err != nil {
+		return nil, err
+	}
+	p := &proposal{term: entry.Term}
+	n.proposals[entry.Index] = p
+	n.triggerSync()
+	n.triggerPeers()
+
+	index := entry.Index
+	return func() error {
+		return n.waitApplied(index, p)
+	}, nil
+}
+
+// logFull must be called holding the mutex
+func (n *Node) logFull() bool {
+	return n.config.MaxLogEntries > 0 && n.log.lastIndex() >= n.config.MaxLogEntries
+}
+
+func (n *Node) waitApplied(index int64, p *proposal) error {
+
+	deadline := time.Now().Add(n.config.CommitTimeout)
+	timer := time.AfterFunc(n.config.CommitTimeout, func() {
+		n.mutex.Lock()
+		n.changed.Broadcast()
+		n.mutex.Unlock()
+	})
+	defer timer.Stop()
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for {
+		var err error
+		switch {
+		case p.applied:
+			return p.err
+		case n.log.term(index) != p.term:
+			err = ErrLeadershipLost
+		case n.stopped:
+			err = ErrStopped
+		case !time.Now().Before(deadline):
+			err = ErrCommitTimeout // it can still be committed later
+		}
+		if err != nil {
+			if n.proposals[index] == p {
+				delete(n.proposals, index)
+			}
+			return err
+		}
+		n.changed.Wait()
+	}
+}
+
+func (n *Node) CreateCollection(name string) (func() error, error) {
+	return n.propose(&Entry{
+		Type:       EntryCreateCollection,
+		Collection: name,
+	})
+}
+
+func (n *Node) DropCollection(name string) (func() error, error) {
+	return n.propose(&Entry{
+		Type:       EntryDropCollection,
+		Collection: name,
+	})
+}
+
+func (n *Node) Command(name string, command *collection.Command) (func() error, error) {
+	return n.propose(&Entry{
+		Type:       EntryCommand,
+		Collection: name,
+		Command:    command,
+	})
+}
@@ -0,0 +1,327 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/database"
+)
+
+// memoryTransport delivers the requests to the nodes of the same process
+type memoryTransport struct {
+	mutex *sync.Mutex
+	nodes map[string]*Node // nil if down
+}
+
+func (t *memoryTransport) Send(ctx context.Context, id, rpc string, request, response any) error {
+	t.mutex.Lock()
+	node := t.nodes[id]
+	t.mutex.Unlock()
+	if node == nil {
+		return fmt.Errorf("node %s is down", id)
+	}
+
+	// Serialize as the HTTP transport would
+	data, _ := json.Marshal(request)
+	var result any
+	var err error
+	switch rpc {
+	case RPCVote:
+		r := &VoteRequest{}
+		json.Unmarshal(data, r)
+		result, err = node.HandleVote(r)
+	case RPCAppend:
+		r := &AppendRequest{}
+		json.Unmarshal(data, r)
+		result, err = node.HandleAppend(r)
+	}
+	if err != nil {
+		return err
+	}
+	data, _ = json.Marshal(result)
+	return json.Unmarshal(data, response)
+}
+
+type testCluster struct {
+	t         *testing.T
+	dir       string
+	ids       []string
+	transport *memoryTransport
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{
+		t:   t,
+		dir: t.TempDir(),
+		transport: &memoryTransport{
+			mutex: &sync.Mutex{},
+			nodes: map[string]*Node{},
+		},
+	}
+	for i := 0; i < size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("node%d", i))
+	}
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	t.Cleanup(func() {
+		for _, id := range c.ids {
+			c.stop(id)
+		}
+	})
+	return c
+}
+
+func (c *testCluster) start(id string) *Node {
+	db := database.NewDatabase(&database.Config{
+		Dir: path.Join(c.dir, id),
+	})
+	node, err := NewNode(db, &Config{
+		Id:                id,
+		Nodes:             c.ids,
+		Dir:               path.Join(c.dir, id+".raft"),
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		CommitTimeout:     2 * time.Second,
+		Transport:         c.transport,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	db.Config.Replicator = node
+	db.Load()
+	node.Start()
+
+	c.transport.mutex.Lock()
+	c.transport.nodes[id] = node
+	c.transport.mutex.Unlock()
+
+	return node
+}
+
+func (c *testCluster) stop(id string) {
+	c.transport.mutex.Lock()
+	node := c.transport.nodes[id]
+	c.transport.nodes[id] = nil
+	c.transport.mutex.Unlock()
+
+	if node != nil {
+		node.Stop()
+		node.db.Stop()
+	}
+}
+
+func (c *testCluster) node(id string) *Node {
+	c.transport.mutex.Lock()
+	defer c.transport.mutex.Unlock()
+	return c.transport.nodes[id]
+}
+
+// leader waits for a ready leader
+func (c *testCluster) leader() *Node {
+	var leader *Node
+	waitFor(c.t, func() bool {
+		for _, id := range c.ids {
+			node := c.node(id)
+			if node != nil && node.Status().Ready {
+				leader = node
+				return true
+			}
+		}
+		return false
+	})
+	return leader
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitDocuments waits until the collection has n documents in every node up
+func (c *testCluster) waitDocuments(name string, n int) {
+	waitFor(c.t, func() bool {
+		for _, id := range c.ids {
+			node := c.node(id)
+			if node == nil {
+				continue
+			}
+			col, err := node.db.GetCollection(name)
+			if err != nil {
+				return false
+			}
+			documents := 0
+			col.Traverse(func(data []byte) {
+				documents++
+			})
+			if documents != n {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestCluster(t *testing.T) {
+
+	c := newTestCluster(t, 3)
+
+	leader := c.leader()
+	col, err := leader.db.CreateCollection("users")
+	biff.AssertNil(err)
+	_, err = col.Insert(map[string]any{"id": "1", "name": "Alice"})
+	biff.AssertNil(err)
+	_, err = col.Insert(map[string]any{"id": "2", "name": "Bob"})
+	biff.AssertNil(err)
+
+	// Acknowledged writes are in a quorum
+	status := leader.Status()
+	biff.AssertEqual(status.CommitIndex, status.LastIndex)
+	c.waitDocuments("users", 2)
+
+	// Followers are read only
+	for _, id := range c.ids {
+		node := c.node(id)
+		if node != leader {
+			biff.AssertNotEqual(node.db.ReadOnly(), "")
+		}
+	}
+
+	// Failover
+	old := leader.config.Id
+	c.stop(old)
+	leader = c.leader()
+	biff.AssertNotEqual(leader.config.Id, old)
+
+	col, err = leader.db.GetCollection("users")
+	biff.AssertNil(err)
+	_, err = col.Insert(map[string]any{"id": "3", "name": "Carol"})
+	biff.AssertNil(err)
+
+	// The old leader rebuilds its database and catches up
+	c.start(old)
+	c.waitDocuments("users", 3)
+}
+
+func TestCluster_Changes(t *testing.T) {
+
+	c := newTestCluster(t, 3)
+
+	leader := c.leader()
+	col, err := leader.db.CreateCollection("users")
+	biff.AssertNil(err)
+	biff.AssertNil(col.Index("by-id", &collection.IndexMapOptions{Field: "id"}))
+	biff.AssertNil(col.SetDefaults(map[string]any{"role": "user"}))
+
+	// Run
+	alice, err := col.Insert(map[string]any{"id": "1", "name": "Alice"})
+	biff.AssertNil(err)
+	bob, err := col.Insert(map[string]any{"id": "2", "name": "Bob"})
+	biff.AssertNil(err)
+	_, err = col.Insert(map[string]any{"id": "1", "name": "Duplicated"})
+	biff.AssertNotNil(err) // rejected when applied
+	biff.AssertNil(col.Patch(alice, map[string]any{"name": "Alicia"}))
+	biff.AssertNil(col.Remove(bob))
+
+	// Check: applied when acknowledged
+	biff.AssertEqual(string(alice.GetPayload()), `{"id":"1","name":"Alicia","role":"user"}`)
+	biff.AssertEqual(col.Len(), 1)
+	c.waitDocuments("users", 1)
+	for _, id := range c.ids {
+		replica, err := c.node(id).db.GetCollection("users")
+		biff.AssertNil(err)
+		replica.Traverse(func(data []byte) {
+			biff.AssertEqual(string(data), `{"id":"1","name":"Alicia","role":"user"}`)
+		})
+		_, exists := replica.GetIndex("by-id")
+		biff.AssertTrue(exists)
+	}
+}
+
+func TestCluster_NoQuorum(t *testing.T) {
+
+	c := newTestCluster(t, 3)
+
+	leader := c.leader()
+	col, err := leader.db.CreateCollection("users")
+	biff.AssertNil(err)
+
+	for _, id := range c.ids {
+		if id != leader.config.Id {
+			c.stop(id)
+		}
+	}
+
+	_, err = col.Insert(map[string]any{"id": "1"})
+	biff.AssertNotNil(err)
+	biff.AssertTrue(errors.Is(err, ErrCommitTimeout) || errors.Is(err, ErrNotLeader) || errors.Is(err, ErrLeadershipLost))
+	biff.AssertEqual(col.Len(), 0) // not committed, not applied
+
+	// Steps down without a quorum
+	waitFor(t, func() bool {
+		return leader.Status().Role != RoleLeader
+	})
+	biff.AssertNotEqual(leader.db.ReadOnly(), "")
+}
+
+func TestCluster_LogFull(t *testing.T) {
+
+	c := newTestCluster(t, 1)
+
+	leader := c.leader()
+	col, err := leader.db.CreateCollection("users")
+	biff.AssertNil(err)
+	leader.mutex.Lock()
+	leader.config.MaxLogEntries = leader.log.lastIndex() + 1
+	leader.mutex.Unlock()
+
+	_, err = col.Insert(map[string]any{"id": "1"})
+	biff.AssertNil(err)
+	_, err = col.Insert(map[string]any{"id": "2"})
+	biff.AssertTrue(errors.Is(err, ErrLogFull))
+
+	waitFor(t, func() bool {
+		return leader.db.ReadOnly() != ""
+	})
+	c.waitDocuments("users", 1)
+}
+
+func TestLog_Reopen(t *testing.T) {
+
+	dir := t.TempDir()
+
+	l, err := openLog(dir)
+	biff.AssertNil(err)
+	for term := int64(1); term <= 3; term++ {
+		l.append(&Entry{Term: term, Type: EntryNoop})
+	}
+	biff.AssertNil(l.truncate(3))
+	l.append(&Entry{Term: 4, Type: EntryNoop})
+	biff.AssertNil(l.writeState(&persistentState{Term: 4, VotedFor: "node1"}))
+	biff.AssertNil(l.close())
+
+	l, err = openLog(dir)
+	biff.AssertNil(err)
+	defer l.close()
+	biff.AssertEqual(l.lastIndex(), int64(3))
+	biff.AssertEqual(l.lastTerm(), int64(4))
+	biff.AssertEqual(l.term(2), int64(2))
+
+	state, err := l.readState()
+	biff.AssertNil(err)
+	biff.AssertEqual(state.VotedFor, "node1")
+}
@@ -0,0 +1,48 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HTTPTransport posts requests to {node}/v1/cluster:{rpc}
+type HTTPTransport struct {
+	Client *http.Client
+}
+
+func NewHTTPTransport() *HTTPTransport {
+	return &HTTPTransport{
+		Client: &http.Client{},
+	}
+}
+
+func (t *HTTPTransport) Send(ctx context.Context, node, rpc string, request, response any) error {
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, node+"/v1/cluster:"+rpc, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: unexpected status %d: %s", rpc, resp.StatusCode, body)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(response)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+var usage = `Offline maintenance of InceptionDB collections, the server must not be
+running on the same data directory.
+
+Usage:
+    inceptiondb-admin <command> [flags] <collection file>...
+
+Commands:
+    verify    replay journals without modifying them
+    dump      print journal commands as JSON lines
+    stats     print live rows, dead commands and sizes
+    compact   rewrite journals as snapshots
+    repair    truncate journals at the first invalid record
+
+Run 'inceptiondb-admin <command> -h' to see the flags of a command.
+`
+
+func main() {
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	key := flags.String("key", os.Getenv("ENCRYPTIONKEY"), "encryption keys, see EncryptionKey")
+	keyFile := flags.String("keyfile", os.Getenv("ENCRYPTIONKEYFILE"), "file with the encryption keys, see EncryptionKeyFile")
+	var keyring *collection.Keyring
+
+	var run func(filename string) error
+	switch os.Args[1] {
+	case "verify":
+		run = func(filename string) error {
+			return verify(filename, keyring)
+		}
+	case "dump":
+		names := flags.String("name", "", "only commands with these names, comma separated (insert, patch, remove...)")
+		since := flags.String("since", "", "only commands at or after this time (RFC3339 or unix nanoseconds)")
+		until := flags.String("until", "", "only commands at or before this time (RFC3339 or unix nanoseconds)")
+		run = func(filename string) error {
+			filter, err := newDumpFilter(*names, *since, *until)
+			if err != nil {
+				return err
+			}
+			return dump(filename, keyring, filter)
+		}
+	case "stats":
+		run = func(filename string) error {
+			return stats(filename, keyring)
+		}
+	case "compact":
+		run = func(filename string) error {
+			return compact(filename, keyring)
+		}
+	case "repair":
+		run = func(filename string) error {
+			return repair(filename, keyring)
+		}
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	flags.Parse(os.Args[2:])
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "at least one collection file is expected")
+		os.Exit(2)
+	}
+
+	keys := *key
+	if *keyFile != "" {
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", err.Error())
+			os.Exit(2)
+		}
+		keys = string(data)
+	}
+	var err error
+	keyring, err = collection.ParseKeyring(keys)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR: encryption keys:", err.Error())
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, filename := range flags.Args() {
+		err := run(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s: %s\n", filename, err.Error())
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func verify(filename string, keyring *collection.Keyring) error {
+
+	t0 := time.Now()
+	c, err := collection.ReplayCollection(filename, &collection.Options{Encryption: keyring})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("OK %s: %d rows, %d indexes, %d commands in %s\n", filename, len(c.Rows), len(c.Indexes), c.Commands(), time.Since(t0))
+	return nil
+}
+
+type dumpFilter struct {
+	names map[string]bool
+	since int64
+	until int64
+}
+
+func newDumpFilter(names, since, until string) (*dumpFilter, error) {
+
+	filter := &dumpFilter{}
+
+	if names != "" {
+		filter.names = map[string]bool{}
+		for _, name := range strings.Split(names, ",") {
+			filter.names[strings.TrimSpace(name)] = true
+		}
+	}
+
+	var err error
+	filter.since, err = parseTime(since)
+	if err != nil {
+		return nil, fmt.Errorf("since: %w", err)
+	}
+	filter.until, err = parseTime(until)
+	if err != nil {
+		return nil, fmt.Errorf("until: %w", err)
+	}
+
+	return filter, nil
+}
+
+func (f *dumpFilter) match(command *collection.Command) bool {
+	if f.names != nil && !f.names[command.Name] {
+		return false
+	}
+	if f.since != 0 && command.Timestamp < f.since {
+		return false
+	}
+	if f.until != 0 && command.Timestamp > f.until {
+		return false
+	}
+	return true
+}
+
+func parseTime(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return t.UnixNano(), nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("expected RFC3339 time or unix nanoseconds, got '%s'", s)
+	}
+	return n, nil
+}
+
+func dump(filename string, keyring *collection.Keyring, filter *dumpFilter) error {
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetEscapeHTML(false)
+
+	return collection.ReadJournal(filename, keyring, func(file string, offset int64, command *collection.Command) error {
+		if !filter.match(command) {
+			return nil
+		}
+		command.Checksum = 0
+		command.Length = 0
+		return e.Encode(command)
+	})
+}
+
+type collectionStats struct {
+	Collection   string           `json:"collection"`
+	Rows         int              `json:"rows"`
+	Indexes      int              `json:"indexes"`
+	Commands     int64            `json:"commands"`
+	DeadCommands int64            `json:"dead_commands"`
+	GarbageRatio float64          `json:"garbage_ratio"`
+	ByName       map[string]int64 `json:"by_name"`
+	Segments     int              `json:"segments"`
+	Bytes        int64            `json:"bytes"`
+	First        time.Time        `json:"first"`
+	Last         time.Time        `json:"last"`
+}
+
+func stats(filename string, keyring *collection.Keyring) error {
+
+	c, err := collection.ReplayCollection(filename, &collection.Options{Encryption: keyring})
+	if err != nil {
+		return err
+	}
+
+	result := &collectionStats{
+		Collection:   filename,
+		Rows:         len(c.Rows),
+		Indexes:      len(c.Indexes),
+		Commands:     c.Commands(),
+		GarbageRatio: c.GarbageRatio(),
+		ByName:       map[string]int64{},
+		Segments:     len(c.Segments()),
+	}
+	result.DeadCommands = int64(float64(result.Commands) * result.GarbageRatio)
+
+	var first, last int64
+	err = collection.ReadJournal(filename, keyring, func(file string, offset int64, command *collection.Command) error {
+		result.ByName[command.Name]++
+		if first == 0 || command.Timestamp < first {
+			first = command.Timestamp
+		}
+		last = max(last, command.Timestamp)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	result.First = time.Unix(0, first).UTC()
+	result.Last = time.Unix(0, last).UTC()
+
+	files, _ := collection.JournalFiles(filename)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err == nil {
+			result.Bytes += info.Size()
+		}
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "    ")
+	return e.Encode(result)
+}
+
+func compact(filename string, keyring *collection.Keyring) error {
+
+	// opening creates missing files, a mistyped path must not become an empty
+	// collection
+	_, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	c, err := collection.OpenCollectionWithOptions(filename, &collection.Options{
+		Encryption: keyring,
+	})
+	if err != nil {
+		return err
+	}
+
+	stats, compactErr := c.Compact()
+	err = c.Close()
+	if compactErr != nil {
+		return compactErr
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Compacted %s: %d -> %d commands, %d -> %d bytes in %s\n", filename, stats.CommandsBefore, stats.CommandsAfter, stats.BytesBefore, stats.BytesAfter, stats.Took)
+	return nil
+}
+
+func repair(filename string, keyring *collection.Keyring) error {
+
+	stats, err := collection.Repair(filename, keyring)
+	if err != nil {
+		return err
+	}
+
+	if stats.File == "" {
+		fmt.Printf("OK %s: nothing to repair\n", filename)
+		return nil
+	}
+
+	fmt.Printf("Repaired %s: cut '%s' at byte %d (%s), %d bytes discarded\n", filename, stats.File, stats.Offset, stats.Reason, stats.DiscardedBytes)
+	return nil
+}
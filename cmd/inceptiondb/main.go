@@ -3,12 +3,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/fulldump/goconfig"
 
 	"github.com/fulldump/inceptiondb/bootstrap"
 	"github.com/fulldump/inceptiondb/configuration"
+	"github.com/fulldump/inceptiondb/logger"
 )
 
 var banner = `
@@ -32,6 +34,34 @@ func main() {
 		return
 	}
 
+	err := logger.Configure(&logger.Options{
+		Level:  c.LogLevel,
+		Format: c.LogFormat,
+		File:   c.LogFile,
+	})
+	if err != nil {
+		fmt.Println("ERROR:", err.Error())
+		os.Exit(-1)
+	}
+
+	if c.Backup != "" {
+		err = bootstrap.Backup(c, c.Backup)
+		if err != nil {
+			slog.Error("backup failed", "error", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
+	if c.Restore != "" || c.RestoreUntil != "" || c.RestoreUntilUuid != "" {
+		err = bootstrap.Restore(c)
+		if err != nil {
+			slog.Error("restore failed", "error", err)
+			os.Exit(-1)
+		}
+		return
+	}
+
 	if c.ShowBanner {
 		fmt.Println(banner)
 	}
@@ -39,7 +69,11 @@ func main() {
 	if c.ShowConfig {
 		e := json.NewEncoder(os.Stdout)
 		e.SetIndent("", "    ")
-		e.Encode(c)
+		shown := *c
+		if shown.EncryptionKey != "" {
+			shown.EncryptionKey = "********"
+		}
+		e.Encode(shown)
 	}
 
 	start, _ := bootstrap.Bootstrap(c)
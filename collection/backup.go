@@ -0,0 +1,138 @@
+package collection
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// JournalSnapshot references the content of the journal files of a collection
+// at a point in time. Files are kept open, so they can be read while the
+// collection keeps working, even if they are sealed or deleted meanwhile.
+type JournalSnapshot struct {
+	Files         []*JournalFile
+	Commands      int64
+	LastTimestamp int64
+
+	collection *Collection
+}
+
+type JournalFile struct {
+	// Name relative to the directory of the collection
+	Name string
+	Size int64
+
+	data []byte // content not backed by a file
+	file *os.File
+}
+
+// FreezeJournal flushes the journal and blocks writes until Thaw is called.
+// Freezing several collections at once gives a consistent point across them.
+func (c *Collection) FreezeJournal() (*JournalSnapshot, error) {
+
+	c.journalMutex.Lock()
+
+	s, err := c.journalSnapshot()
+	if err != nil {
+		c.journalMutex.Unlock()
+		return nil, err
+	}
+	s.collection = c
+
+	return s, nil
+}
+
+func (c *Collection) journalSnapshot() (*JournalSnapshot, error) {
+
+	if c.file == nil {
+		return nil, fmt.Errorf("collection is closed")
+	}
+
+	err := c.flushBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("flush: %w", err)
+	}
+
+	s := &JournalSnapshot{
+		Commands:      c.commands,
+		LastTimestamp: c.active.lastTimestamp,
+	}
+
+	base := path.Base(c.Filename)
+	dir := SegmentsDir(c.Filename)
+	for _, segment := range c.manifest.Segments {
+		s.LastTimestamp = max(s.LastTimestamp, segment.LastTimestamp)
+		f, err := os.Open(path.Join(dir, segment.File))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.Files = append(s.Files, &JournalFile{
+			Name: path.Join(base+segmentsDirSuffix, segment.File),
+			Size: segment.Size,
+			file: f,
+		})
+	}
+
+	if len(c.manifest.Segments) > 0 {
+		data, err := json.MarshalIndent(c.manifest, "", "  ")
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.Files = append(s.Files, &JournalFile{
+			Name: path.Join(base+segmentsDirSuffix, manifestFilename),
+			Size: int64(len(data)),
+			data: data,
+		})
+	}
+
+	f, err := os.Open(c.Filename)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Files = append(s.Files, &JournalFile{
+		Name: base,
+		Size: c.active.size,
+		file: f,
+	})
+
+	return s, nil
+}
+
+// Thaw unblocks the writes, the snapshot can still be read
+func (s *JournalSnapshot) Thaw() {
+	if s.collection != nil {
+		s.collection.journalMutex.Unlock()
+		s.collection = nil
+	}
+}
+
+// Close thaws the collection if needed and releases the files
+func (s *JournalSnapshot) Close() error {
+	s.Thaw()
+
+	var lastErr error
+	for _, f := range s.Files {
+		if f.file == nil {
+			continue
+		}
+		err := f.file.Close()
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Reader returns the content of the file at the time of the snapshot
+func (f *JournalFile) Reader() io.Reader {
+	if f.file == nil {
+		return bytes.NewReader(f.data)
+	}
+	return io.NewSectionReader(f.file, 0, f.Size)
+}
@@ -0,0 +1,41 @@
+package collection
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestFreezeJournal(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "2"})
+
+		// Run
+		snapshot, err := c.FreezeJournal()
+		AssertNil(err)
+		snapshot.Thaw()
+		c.Insert(map[string]interface{}{"id": "3"})
+		c.Compact()
+		defer snapshot.Close()
+
+		// Check
+		AssertEqual(snapshot.Commands, int64(3))
+		AssertEqual(len(snapshot.Files), 3) // segment, manifest and active
+		AssertEqual(snapshot.Files[1].Name, filename+".segments/manifest.json")
+		for _, file := range snapshot.Files {
+			data, _ := io.ReadAll(file.Reader())
+			AssertEqual(int64(len(data)), file.Size)
+		}
+		active, _ := io.ReadAll(snapshot.Files[2].Reader())
+		AssertTrue(strings.Contains(string(active), `{"id":"2"}`))
+		AssertFalse(strings.Contains(string(active), `{"id":"3"}`))
+	})
+}
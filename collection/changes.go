@@ -0,0 +1,227 @@
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/btree"
+)
+
+const (
+	ChangeUpsert = "upsert"
+	ChangeDelete = "delete"
+)
+
+// DocumentChange is the last state of a document that changed after a
+// sequence: the whole document if it exists, a tombstone with its id if it
+// was removed
+type DocumentChange struct {
+	Sequence int64           `json:"sequence"`
+	Type     string          `json:"type"`
+	Id       string          `json:"id,omitempty"`
+	Document json.RawMessage `json:"document,omitempty"`
+}
+
+type ChangesPage struct {
+	Changes []*DocumentChange `json:"changes"`
+	// Checkpoint is the sequence of the last change read, the next page
+	// starts after it
+	Checkpoint int64 `json:"checkpoint"`
+	More       bool  `json:"more"`
+}
+
+var ErrChangesExpired = errors.New("changes not retained anymore")
+
+// changeEntry is the last change of a row, or the removal of a document
+type changeEntry struct {
+	sequence int64
+	created  int64  // sequence of the insert, zero if unknown
+	row      *Row   // nil if removed
+	key      string // document id of a removal
+}
+
+// changeLog orders the rows and the removed documents by the sequence of
+// their last change. Sequences are assigned to insert, patch and remove
+// commands and written with them, so they survive restarts and compactions.
+// Compactions forget the removals (except the tombstones of the multi-primary
+// mode), clients behind them must start again.
+type changeLog struct {
+	sequence int64 // last sequence assigned
+	horizon  int64 // last removal forgotten by a compaction
+	entries  *btree.BTreeG[*changeEntry]
+	rows     map[int64]*changeEntry
+	removed  map[string]*changeEntry
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{
+		entries: btree.NewG(32, func(a, b *changeEntry) bool {
+			return a.sequence < b.sequence
+		}),
+		rows:    map[int64]*changeEntry{},
+		removed: map[string]*changeEntry{},
+	}
+}
+
+func (l *changeLog) set(entry *changeEntry, sequence int64) {
+	if entry.sequence != 0 {
+		l.entries.Delete(entry)
+	}
+	entry.sequence = sequence
+	l.entries.ReplaceOrInsert(entry)
+}
+
+// forgettable returns the last removal that a compaction would forget
+func (l *changeLog) forgettable(tombstones map[string]string) int64 {
+	horizon := l.horizon
+	for key, entry := range l.removed {
+		if _, exists := tombstones[key]; !exists && entry.sequence > horizon {
+			horizon = entry.sequence
+		}
+	}
+	return horizon
+}
+
+// forget drops the removals up to sequence, once they are compacted
+func (l *changeLog) forget(sequence int64, tombstones map[string]string) {
+	for key, entry := range l.removed {
+		if _, exists := tombstones[key]; exists || entry.sequence > sequence {
+			continue
+		}
+		l.entries.Delete(entry)
+		delete(l.removed, key)
+		if entry.sequence > l.horizon {
+			l.horizon = entry.sequence
+		}
+	}
+}
+
+// trackChange assigns the next sequence to insert, patch and remove commands.
+// Replayed commands keep their sequence.
+func (c *Collection) trackChange(command *Command, replay bool) {
+
+	switch command.Name {
+	case "insert", "patch", "remove":
+	default:
+		return
+	}
+
+	c.rowsMutex.Lock()
+	defer c.rowsMutex.Unlock()
+
+	l := c.changes
+	sequence := command.Sequence
+	created := sequence
+	switch {
+	case !replay || sequence == 0:
+		l.sequence++
+		sequence = l.sequence
+		created = sequence
+		command.Sequence = sequence
+	case sequence > l.sequence:
+		l.sequence = sequence
+	default:
+		created = 0 // written by a compaction, the insert is unknown
+	}
+
+	key := command.Key
+	if command.RowId != 0 {
+		entry := l.rows[command.RowId]
+		switch {
+		case command.Name == "insert":
+			row := c.rowsById[command.RowId]
+			if row == nil {
+				return
+			}
+			if removed, exists := l.removed[row.key]; exists {
+				l.entries.Delete(removed) // inserted again
+				delete(l.removed, row.key)
+			}
+			entry = &changeEntry{created: created, row: row}
+			l.rows[command.RowId] = entry
+			l.set(entry, sequence)
+			return
+		case entry == nil:
+			return
+		case command.Name == "patch":
+			l.set(entry, sequence)
+			return
+		}
+		l.entries.Delete(entry)
+		delete(l.rows, command.RowId)
+		created = entry.created
+		if key == "" {
+			key = DocumentKey(entry.row.Payload)
+		}
+	}
+
+	if command.Name != "remove" || key == "" || c.rowsByKey[key] != nil {
+		return // nothing to tell the clients, or the document was replaced
+	}
+	entry, exists := l.removed[key]
+	if !exists {
+		entry = &changeEntry{key: key}
+		l.removed[key] = entry
+	}
+	entry.created = created
+	l.set(entry, sequence)
+}
+
+// Sequence returns the sequence of the last change
+func (c *Collection) Sequence() int64 {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+	return c.changes.sequence
+}
+
+// Changes returns the documents changed after the sequence since (from the
+// beginning if zero), up to limit documents (no limit if zero). Documents
+// changed several times are returned once. A sequence older than the removals
+// forgotten by a compaction, or newer than the last one, returns
+// ErrChangesExpired: the client must start again from the beginning.
+func (c *Collection) Changes(since int64, limit int) (*ChangesPage, error) {
+
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+
+	l := c.changes
+	if since < 0 || (since > 0 && since < l.horizon) || since > l.sequence {
+		return nil, fmt.Errorf("%w: sequence %d is not between %d and %d", ErrChangesExpired, since, l.horizon, l.sequence)
+	}
+
+	page := &ChangesPage{
+		Changes:    []*DocumentChange{},
+		Checkpoint: l.sequence,
+	}
+	l.entries.AscendGreaterOrEqual(&changeEntry{sequence: since + 1}, func(entry *changeEntry) bool {
+		if limit > 0 && len(page.Changes) >= limit {
+			page.More = true
+			return false
+		}
+		page.Checkpoint = entry.sequence
+		if entry.row == nil {
+			if since == 0 || entry.created > since {
+				return true // unknown for the client
+			}
+			page.Changes = append(page.Changes, &DocumentChange{
+				Sequence: entry.sequence,
+				Type:     ChangeDelete,
+				Id:       entry.key,
+			})
+			return true
+		}
+		page.Changes = append(page.Changes, &DocumentChange{
+			Sequence: entry.sequence,
+			Type:     ChangeUpsert,
+			Id:       DocumentKey(entry.row.Payload),
+			Document: entry.row.Payload,
+		})
+		return true
+	})
+	if !page.More {
+		page.Checkpoint = l.sequence
+	}
+
+	return page, nil
+}
@@ -0,0 +1,134 @@
+package collection
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestChanges(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		one, _ := c.Insert(map[string]any{"id": "1"})
+		two, _ := c.Insert(map[string]any{"id": "2"})
+		first, err := c.Changes(0, 0)
+		AssertNil(err)
+		AssertEqual(len(first.Changes), 2)
+
+		// Run
+		c.Patch(one, map[string]any{"name": "one"})
+		c.Patch(one, map[string]any{"name": "uno"})
+		c.Remove(two)
+		three, _ := c.Insert(map[string]any{"id": "3"})
+		c.Remove(three) // never seen by the client
+
+		// Check
+		page, err := c.Changes(first.Checkpoint, 0)
+		AssertNil(err)
+		AssertEqual(len(page.Changes), 2)
+		AssertEqual(page.Changes[0].Type, ChangeUpsert)
+		AssertEqual(string(page.Changes[0].Document), `{"id":"1","name":"uno"}`)
+		AssertEqual(page.Changes[1].Type, ChangeDelete)
+		AssertEqual(page.Changes[1].Id, "2")
+		AssertEqual(page.Checkpoint, c.Sequence())
+		AssertEqual(page.More, false)
+	})
+}
+
+func TestChanges_Pages(t *testing.T) {
+	Environment(func(filename string) {
+
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		for i := 0; i < 5; i++ {
+			c.Insert(map[string]any{"n": i})
+		}
+
+		pages := 0
+		documents := 0
+		checkpoint := int64(0)
+		for {
+			page, err := c.Changes(checkpoint, 2)
+			AssertNil(err)
+			pages++
+			documents += len(page.Changes)
+			checkpoint = page.Checkpoint
+			if !page.More {
+				break
+			}
+		}
+		AssertEqual(pages, 3)
+		AssertEqual(documents, 5)
+	})
+}
+
+func TestChanges_Compacted(t *testing.T) {
+	Environment(func(filename string) {
+
+		c, _ := OpenCollection(filename)
+		one, _ := c.Insert(map[string]any{"id": "1"})
+		c.Insert(map[string]any{"id": "2"})
+		before, _ := c.Changes(0, 0)
+		c.Patch(one, map[string]any{"name": "one"})
+		after, _ := c.Changes(0, 0)
+		c.Remove(one)
+		c.Compact()
+		c.Close()
+
+		// Run
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		three, _ := c.Insert(map[string]any{"id": "3"})
+
+		// Check
+		_, err := c.Changes(before.Checkpoint, 0)
+		AssertTrue(errors.Is(err, ErrChangesExpired)) // the remove is forgotten
+		page, err := c.Changes(after.Checkpoint, 0)
+		AssertTrue(errors.Is(err, ErrChangesExpired))
+		page, err = c.Changes(c.Sequence()-1, 0)
+		AssertNil(err)
+		AssertEqual(len(page.Changes), 1)
+		AssertEqual(page.Changes[0].Sequence, int64(5))
+		AssertEqual(string(page.Changes[0].Document), string(three.Payload))
+		page, err = c.Changes(0, 0)
+		AssertNil(err)
+		AssertEqual(len(page.Changes), 2)
+		AssertEqual(page.Changes[0].Sequence, int64(2)) // kept by the compaction
+		AssertEqual(page.Changes[0].Id, "2")
+		AssertEqual(page.Changes[1].Id, "3")
+	})
+}
+
+func TestChanges_Restart(t *testing.T) {
+	Environment(func(filename string) {
+
+		c, _ := OpenCollection(filename)
+		one, _ := c.Insert(map[string]any{"id": "1"})
+		two, _ := c.Insert(map[string]any{"id": "2"})
+		first, _ := c.Changes(0, 0)
+		c.Remove(two)
+		c.Patch(one, map[string]any{"name": "one"})
+		c.Close()
+
+		// Run
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		page, err := c.Changes(first.Checkpoint, 0)
+
+		// Check
+		AssertNil(err)
+		AssertEqual(len(page.Changes), 2)
+		AssertEqual(page.Changes[0].Sequence, int64(3))
+		AssertEqual(page.Changes[0].Type, ChangeDelete)
+		AssertEqual(page.Changes[0].Id, "2")
+		AssertEqual(page.Changes[1].Sequence, int64(4))
+		AssertEqual(string(page.Changes[1].Document), `{"id":"1","name":"one"}`)
+		AssertEqual(page.Checkpoint, int64(4))
+		_, err = c.Changes(page.Checkpoint+1, 0)
+		AssertTrue(errors.Is(err, ErrChangesExpired))
+	})
+}
@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"path"
 	"reflect"
 	"sync"
 	"sync/atomic"
@@ -21,15 +24,74 @@ import (
 )
 
 type Collection struct {
-	Filename     string // Just informative...
-	file         *os.File
-	Rows         []*Row
-	rowsMutex    *sync.Mutex
-	Indexes      map[string]*collectionIndex // todo: protect access with mutex or use sync.Map
-	buffer       *bufio.Writer               // TODO: use write buffer to improve performance (x3 in tests)
-	Defaults     map[string]any
-	Count        int64
-	encoderMutex *sync.Mutex
+	Filename      string // Just informative...
+	file          *os.File
+	Rows          []*Row
+	rowsById      map[int64]*Row
+	lastRowId     int64
+	proposedRows  int64                       // inserts proposed to Options.Replicate, protected by rowsMutex
+	rowsMutex     *sync.RWMutex               // protects Rows, Indexes, Defaults and the payload of the rows
+	Indexes       map[string]*collectionIndex // use GetIndex and GetIndexes from other goroutines
+	buffer        *bufio.Writer               // TODO: use write buffer to improve performance (x3 in tests)
+	Defaults      map[string]any              // use GetDefaults from other goroutines
+	Count         int64
+	encoderMutex  *sync.Mutex
+	journalMutex  *sync.RWMutex // mutations hold it in read mode, compaction in write mode
+	commands      int64         // number of commands stored in the journal
+	compacting    atomic.Bool
+	rotating      atomic.Bool
+	manifest      *Manifest     // sealed segments
+	active        activeSegment // protected by encoderMutex
+	Options       *Options
+	durability    atomic.Pointer[DurabilityOptions] // overrides Options.Durability if not nil
+	flusherMutex  *sync.Mutex
+	flusherStop   chan struct{}
+	written       int64 // sequence of the last command written into the buffer
+	syncMutex     *sync.Mutex
+	syncCond      *sync.Cond
+	syncing       bool
+	synced        int64 // sequence of the last command fsynced
+	version       int   // journal format version
+	watchers      map[*Watcher]struct{}
+	watchersMutex *sync.Mutex
+	lastUuid      string // last command replayed or written, protected by encoderMutex
+	lastTimestamp int64
+	rowsByKey     map[string]*Row   // multi-primary mode, protected by rowsMutex
+	tombstones    map[string]string // document id -> version of its remove
+	changes       *changeLog        // protected by rowsMutex
+	mergeMutex    *sync.Mutex
+	maxDocuments  atomic.Int64
+	bytesWritten  int64 // protected by encoderMutex
+	flushes       atomic.Int64
+}
+
+var ErrDocumentLimit = errors.New("document limit reached")
+
+type Options struct {
+	Compaction *CompactionOptions
+	Durability *DurabilityOptions
+	Segments   *SegmentOptions
+	// Encryption encrypts new journal records if not nil
+	Encryption *Keyring
+	// Clock enables the multi-primary mode, see Merge
+	Clock *HLC
+	// MaxDocuments makes Insert fail with ErrDocumentLimit when the collection
+	// has that many documents, zero means no limit
+	MaxDocuments int
+	// Replicate is called with the commands of every change instead of
+	// applying them: the replicas apply them with ApplyCommand once they are
+	// committed (this collection included). The change waits for the returned
+	// function (if any), that returns the error of applying it. See package
+	// cluster.
+	Replicate func(command *Command) (wait func() error, err error)
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Compaction: &CompactionOptions{},
+		Durability: &DurabilityOptions{Mode: DurabilityNone},
+		Segments:   &SegmentOptions{},
+	}
 }
 
 type collectionIndex struct {
@@ -39,15 +101,29 @@ type collectionIndex struct {
 }
 
 type Row struct {
-	I          int // position in Rows
-	Payload    json.RawMessage
+	I          int             // position in Rows
+	Id         int64           // immutable identifier, referenced by the journal
+	Payload    json.RawMessage // use GetPayload if the row can be patched concurrently
 	PatchMutex sync.Mutex
+	key        string        // document id in multi-primary mode
+	mutex      *sync.RWMutex // rowsMutex of the collection
+}
+
+// GetPayload returns the payload of a row while it can be patched
+func (r *Row) GetPayload() json.RawMessage {
+	if r.mutex == nil {
+		return r.Payload
+	}
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.Payload
 }
 
 type EncoderMachine struct {
 	Buffer *bytes.Buffer
 	Enc    *json.Encoder
 	Enc2   *jsontext.Encoder
+	Sealed []byte
 }
 
 var encPool = sync.Pool{
@@ -71,105 +147,65 @@ var encPool = sync.Pool{
 	},
 }
 
-func OpenCollection(filename string) (*Collection, error) {
+// encodeRecord returns the journal record for a command, encrypted if keyring
+// is not nil. The result is only valid until the next use of the EncoderMachine
+func (em *EncoderMachine) encodeRecord(command *Command, keyring *Keyring) ([]byte, error) {
 
-	// TODO: initialize, read all file and apply its changes into memory
-	f, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+	em.Buffer.Reset()
+
+	record := *command
+	record.Checksum = 0
+	record.Length = 0
+
+	// err := em.Enc.Encode(command)
+	err := json2.MarshalEncode(em.Enc2, &record)
+	// err := json2.MarshalWrite(em.Buffer, command)
 	if err != nil {
-		return nil, fmt.Errorf("open file for read: %w", err)
+		return nil, err
 	}
 
-	collection := &Collection{
-		Rows:         []*Row{},
-		rowsMutex:    &sync.Mutex{},
-		Filename:     filename,
-		Indexes:      map[string]*collectionIndex{},
-		encoderMutex: &sync.Mutex{},
+	b := appendRecordTrailer(em.Buffer.Bytes())
+	if keyring == nil {
+		return b, nil
 	}
 
-	j := jsontext.NewDecoder(f,
-		jsontext.AllowDuplicateNames(true),
-		jsontext.AllowInvalidUTF8(true),
-	)
+	em.Sealed, err = keyring.seal(em.Sealed[:0], b)
+	return em.Sealed, err
+}
 
-	command := &Command{}
+func OpenCollection(filename string) (*Collection, error) {
+	return OpenCollectionWithOptions(filename, DefaultOptions())
+}
 
-	for {
-		command.Payload = nil
+func OpenCollectionWithOptions(filename string, options *Options) (*Collection, error) {
 
-		err := json2.UnmarshalDecode(j, &command)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			// todo: try a best effort?
-			return nil, fmt.Errorf("decode json: %w", err)
-		}
+	if options == nil {
+		options = DefaultOptions()
+	}
 
-		switch command.Name {
-		case "insert":
-			_, err := collection.addRow(command.Payload)
-			if err != nil {
-				return nil, err
-			}
-		case "drop_index":
-			dropIndexCommand := &DropIndexCommand{}
-			json.Unmarshal(command.Payload, dropIndexCommand) // Todo: handle error properly
+	collection := newCollection(filename, options)
 
-			err := collection.dropIndex(dropIndexCommand.Name, false)
-			if err != nil {
-				fmt.Printf("WARNING: drop index '%s': %s\n", dropIndexCommand.Name, err.Error())
-				// TODO: stop process? if error might get inconsistent state
-			}
-		case "index": // todo: rename to create_index
-			indexCommand := &CreateIndexCommand{}
-			json.Unmarshal(command.Payload, indexCommand) // Todo: handle error properly
-
-			var options interface{}
-
-			switch indexCommand.Type {
-			case "map":
-				options = &IndexMapOptions{}
-				utils.Remarshal(indexCommand.Options, options)
-			case "btree":
-				options = &IndexBTreeOptions{}
-				utils.Remarshal(indexCommand.Options, options)
-			default:
-				return nil, fmt.Errorf("index command: unexpected type '%s' instead of [map|btree]", indexCommand.Type)
-			}
+	manifest, err := loadManifest(SegmentsDir(filename), options.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+	collection.manifest = manifest
 
-			err := collection.createIndex(indexCommand.Name, options, false)
-			if err != nil {
-				fmt.Printf("WARNING: create index '%s': %s\n", indexCommand.Name, err.Error())
-			}
-		case "remove":
-			params := struct {
-				I int
-			}{}
-			json.Unmarshal(command.Payload, &params) // Todo: handle error properly
-			row := collection.Rows[params.I]         // this access is threadsafe, OpenCollection is a secuence
-			err := collection.removeByRow(row, false)
-			if err != nil {
-				fmt.Printf("WARNING: remove row %d: %s\n", params.I, err.Error())
-			}
-		case "patch":
-			params := struct {
-				I    int
-				Diff map[string]interface{}
-			}{}
-			json.Unmarshal(command.Payload, &params)
-			row := collection.Rows[params.I] // this access is threadsafe, OpenCollection is a secuence
-			err := collection.patchByRow(row, params.Diff, false)
-			if err != nil {
-				fmt.Printf("WARNING: patch item %d: %s\n", params.I, err.Error())
-			}
-		case "set_defaults":
-			defaults := map[string]any{}
-			json.Unmarshal(command.Payload, &defaults)
-			collection.setDefaults(defaults, false)
+	// Sealed segments
+	for _, segment := range manifest.Segments {
+		segmentFilename := path.Join(SegmentsDir(filename), segment.File)
+		_, err := collection.replayFile(segmentFilename, false)
+		if err != nil {
+			return nil, fmt.Errorf("segment '%s': %w", segment.File, err)
 		}
 	}
 
+	// Active segment
+	j, err := collection.replayFile(filename, true)
+	if err != nil {
+		return nil, err
+	}
+
 	// Open file for append only
 	// todo: investigate O_SYNC
 	collection.file, err = os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
@@ -178,14 +214,219 @@ func OpenCollection(filename string) (*Collection, error) {
 	}
 
 	collection.buffer = bufio.NewWriterSize(collection.file, 16*1024*1024)
+	collection.active.size = j.Offset()
+
+	if j.MissingNewline() {
+		collection.buffer.WriteString("\n")
+		collection.active.size++
+	}
+
+	// Migrate: from now on, commands reference rows by id
+	if collection.version < JournalVersion {
+		err = collection.EncodeCommand(collection.newFormatCommand(false))
+		if err != nil {
+			return nil, fmt.Errorf("write journal format: %w", err)
+		}
+		collection.version = JournalVersion
+	}
+
+	collection.startFlusher()
 
 	return collection, nil
 }
 
-func (c *Collection) addRow(payload json.RawMessage) (*Row, error) {
+func newCollection(filename string, options *Options) *Collection {
+	collection := &Collection{
+		Rows:          []*Row{},
+		rowsById:      map[int64]*Row{},
+		rowsMutex:     &sync.RWMutex{},
+		version:       1,
+		Filename:      filename,
+		Indexes:       map[string]*collectionIndex{},
+		encoderMutex:  &sync.Mutex{},
+		journalMutex:  &sync.RWMutex{},
+		Options:       options,
+		flusherMutex:  &sync.Mutex{},
+		syncMutex:     &sync.Mutex{},
+		watchers:      map[*Watcher]struct{}{},
+		watchersMutex: &sync.Mutex{},
+		rowsByKey:     map[string]*Row{},
+		tombstones:    map[string]string{},
+		changes:       newChangeLog(),
+		mergeMutex:    &sync.Mutex{},
+	}
+	collection.syncCond = sync.NewCond(collection.syncMutex)
+	collection.maxDocuments.Store(int64(options.MaxDocuments))
+
+	return collection
+}
+
+// replayFile applies all the commands of a journal file. A torn record at the
+// end is truncated only if truncateTorn is set, otherwise it is an error.
+func (c *Collection) replayFile(filename string, truncateTorn bool) (*JournalReader, error) {
+
+	f, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open file for read: %w", err)
+	}
+	defer f.Close()
+
+	j := NewJournalReader(f, c.Options.Encryption)
+
+	command := &Command{}
+
+	for {
+		err := j.Next(command)
+		if err == io.EOF {
+			break
+		}
+		if err == ErrTornTail && truncateTorn {
+			slog.Warn("truncating torn record", "file", filename, "offset", j.Offset())
+			err = os.Truncate(filename, j.Offset())
+			if err != nil {
+				return nil, fmt.Errorf("truncate torn tail: %w", err)
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode json: %w", err)
+		}
+
+		c.commands++
+		if truncateTorn {
+			c.active.track(command)
+		}
+		c.trackLast(command)
+
+		err = c.applyCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		c.trackChange(command, true)
+	}
+
+	return j, nil
+}
+
+// applyCommand replays a journal command into memory (without persisting it)
+func (c *Collection) applyCommand(command *Command) error {
+
+	switch command.Name {
+	case "format":
+		format := &FormatCommand{}
+		json.Unmarshal(command.Payload, format) // Todo: handle error properly
+		if format.Version > JournalVersion {
+			return fmt.Errorf("unsupported journal version %d, expected %d or lower", format.Version, JournalVersion)
+		}
+		c.version = format.Version
+		if format.LastRowId > c.lastRowId {
+			c.lastRowId = format.LastRowId
+		}
+		c.rowsMutex.Lock()
+		c.changes.sequence = max(c.changes.sequence, format.Sequence)
+		c.changes.horizon = max(c.changes.horizon, format.Horizon)
+		c.rowsMutex.Unlock()
+	case "insert":
+		row, err := c.addRow(command.Payload, command.RowId, 0)
+		if err != nil {
+			return err
+		}
+		command.RowId = row.Id
+	case "drop_index":
+		dropIndexCommand := &DropIndexCommand{}
+		json.Unmarshal(command.Payload, dropIndexCommand) // Todo: handle error properly
+
+		err := c.dropIndex(dropIndexCommand.Name, false)
+		if err != nil {
+			slog.Warn("replay drop index", "collection", c.Filename, "index", dropIndexCommand.Name, "error", err)
+			// TODO: stop process? if error might get inconsistent state
+		}
+	case "index": // todo: rename to create_index
+		indexCommand := &CreateIndexCommand{}
+		json.Unmarshal(command.Payload, indexCommand) // Todo: handle error properly
+
+		var options interface{}
+
+		switch indexCommand.Type {
+		case "map":
+			options = &IndexMapOptions{}
+			utils.Remarshal(indexCommand.Options, options)
+		case "btree":
+			options = &IndexBTreeOptions{}
+			utils.Remarshal(indexCommand.Options, options)
+		default:
+			return fmt.Errorf("index command: unexpected type '%s' instead of [map|btree]", indexCommand.Type)
+		}
+
+		err := c.createIndex(indexCommand.Name, options, false)
+		if err != nil {
+			slog.Warn("replay create index", "collection", c.Filename, "index", indexCommand.Name, "error", err)
+		}
+	case "remove":
+		if command.Key != "" {
+			c.rowsMutex.Lock()
+			c.setTombstone(command.Key, command.Version)
+			c.rowsMutex.Unlock()
+			if command.RowId == 0 {
+				break // only the tombstone
+			}
+		}
+		row, err := c.commandRow(command)
+		if err == nil {
+			command.RowId = row.Id
+			err = c.removeByRow(row, false, "")
+		}
+		if err != nil {
+			slog.Warn("replay remove", "collection", c.Filename, "error", err)
+		}
+	case "patch":
+		params := struct {
+			Diff map[string]interface{}
+		}{}
+		json.Unmarshal(command.Payload, &params)
+		row, err := c.commandRow(command)
+		if err == nil {
+			command.RowId = row.Id
+			err = c.patchByRow(row, params.Diff, false)
+		}
+		if err != nil {
+			slog.Warn("replay patch", "collection", c.Filename, "error", err)
+		}
+	case "set_defaults":
+		defaults := map[string]any{}
+		json.Unmarshal(command.Payload, &defaults)
+		c.setDefaults(defaults, false)
+	case "set_durability":
+		var durability *DurabilityOptions
+		json.Unmarshal(command.Payload, &durability)
+		err := c.setDurability(durability, false)
+		if err != nil {
+			slog.Warn("replay set durability", "collection", c.Filename, "error", err)
+		}
+	}
+
+	return nil
+}
+
+// addRow inserts a new row, a new id is assigned if id is zero. It fails with
+// ErrDocumentLimit if the collection has limit rows (zero means no limit).
+func (c *Collection) addRow(payload json.RawMessage, id int64, limit int64) (*Row, error) {
 
 	row := &Row{
 		Payload: payload,
+		mutex:   c.rowsMutex,
+	}
+
+	key := ""
+	if c.Options.Clock != nil {
+		key = DocumentKey(payload)
+	}
+
+	c.rowsMutex.Lock()
+	defer c.rowsMutex.Unlock()
+
+	if limit > 0 && int64(len(c.Rows)) >= limit {
+		return nil, fmt.Errorf("%w: %d", ErrDocumentLimit, limit)
 	}
 
 	err := indexInsert(c.Indexes, row)
@@ -193,30 +434,163 @@ func (c *Collection) addRow(payload json.RawMessage) (*Row, error) {
 		return nil, err
 	}
 
-	c.rowsMutex.Lock()
+	if id == 0 {
+		c.lastRowId++
+		id = c.lastRowId
+	} else if id > c.lastRowId {
+		c.lastRowId = id
+	}
+	row.Id = id
 	row.I = len(c.Rows)
 	c.Rows = append(c.Rows, row)
-	c.rowsMutex.Unlock()
+	c.rowsById[id] = row
+	if key != "" {
+		c.trackKey(row, key)
+	}
 
 	return row, nil
 }
 
+// commandRow returns the row affected by a remove or patch command. Legacy
+// commands (journal version 1) reference the row by its position in Rows.
+func (c *Collection) commandRow(command *Command) (*Row, error) {
+
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+
+	if command.RowId != 0 {
+		row, exists := c.rowsById[command.RowId]
+		if !exists {
+			return nil, fmt.Errorf("row %d does not exist", command.RowId)
+		}
+		return row, nil
+	}
+
+	params := struct {
+		I int
+	}{}
+	json.Unmarshal(command.Payload, &params) // Todo: handle error properly
+	if params.I < 0 || params.I >= len(c.Rows) {
+		return nil, fmt.Errorf("row %d does not exist", params.I)
+	}
+	return c.Rows[params.I], nil
+}
+
+// SetMaxDocuments replaces Options.MaxDocuments
+func (c *Collection) SetMaxDocuments(limit int) {
+	c.maxDocuments.Store(int64(limit))
+}
+
+func (c *Collection) GetRowById(id int64) *Row {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+	return c.rowsById[id]
+}
+
+// Len returns the number of documents
+func (c *Collection) Len() int {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+	return len(c.Rows)
+}
+
+// GetDefaults returns the defaults, the map must not be modified
+func (c *Collection) GetDefaults() map[string]any {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+	return c.Defaults
+}
+
+func (c *Collection) GetIndex(name string) (*collectionIndex, bool) {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+	index, exists := c.Indexes[name]
+	return index, exists
+}
+
+// GetIndexes returns a copy of Indexes
+func (c *Collection) GetIndexes() map[string]*collectionIndex {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+	indexes := make(map[string]*collectionIndex, len(c.Indexes))
+	for name, index := range c.Indexes {
+		indexes[name] = index
+	}
+	return indexes
+}
+
+// rows returns a copy of Rows, so they can be traversed while the collection
+// changes (even by the traversal itself)
+func (c *Collection) rows() []*Row {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
+	return append([]*Row(nil), c.Rows...)
+}
+
 // TODO: test concurrency
 func (c *Collection) Insert(item map[string]any) (*Row, error) {
+	if c.Options.Replicate != nil {
+		return c.proposeInsert(item)
+	}
+
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+
 	if c.file == nil {
 		return nil, fmt.Errorf("collection is closed")
 	}
 
+	// Fail fast, addRow checks it again holding the lock
+	limit := c.maxDocuments.Load()
+	if limit > 0 && int64(c.Len()) >= limit {
+		return nil, fmt.Errorf("%w: %d", ErrDocumentLimit, limit)
+	}
+
+	payload, version, err := c.newDocument(item)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add row
+	row, err := c.addRow(payload, 0, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// Persist
+	command := &Command{
+		Name:      "insert",
+		Uuid:      uuid.New().String(),
+		Timestamp: time.Now().UnixNano(),
+		StartByte: 0,
+		RowId:     row.Id,
+		Payload:   payload,
+		Key:       row.key,
+		Version:   version,
+	}
+
+	err = c.EncodeCommand(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return row, nil
+}
+
+// newDocument fills the defaults of a new document and returns its payload
+// and its version (multi-primary mode)
+func (c *Collection) newDocument(item map[string]any) (json.RawMessage, string, error) {
+
 	auto := atomic.AddInt64(&c.Count, 1)
 
-	if c.Defaults != nil {
+	if defaults := c.GetDefaults(); defaults != nil {
 		// item := map[string]any{} // todo: item is shadowed, choose a better name
 		// err := json.Unmarshal(payload, &item)
 		// if err != nil {
 		// 	return nil, fmt.Errorf("json encode defaults: %w", err)
 		// }
 
-		for k, v := range c.Defaults {
+		for k, v := range defaults {
 			if item[k] != nil {
 				continue
 			}
@@ -235,35 +609,23 @@ func (c *Collection) Insert(item map[string]any) (*Row, error) {
 		}
 	}
 
-	payload, err := json.Marshal(item)
-	if err != nil {
-		return nil, fmt.Errorf("json encode payload: %w", err)
-	}
-
-	// Add row
-	row, err := c.addRow(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	// Persist
-	command := &Command{
-		Name:      "insert",
-		Uuid:      uuid.New().String(),
-		Timestamp: time.Now().UnixNano(),
-		StartByte: 0,
-		Payload:   payload,
+	version := ""
+	if c.Options.Clock != nil {
+		version = c.Options.Clock.Now()
+		stampDocument(item, version)
 	}
 
-	err = c.EncodeCommand(command)
+	payload, err := json.Marshal(item)
 	if err != nil {
-		return nil, err
+		return nil, "", fmt.Errorf("json encode payload: %w", err)
 	}
 
-	return row, nil
+	return payload, version, nil
 }
 
 func (c *Collection) FindOne(data interface{}) {
+	c.rowsMutex.RLock()
+	defer c.rowsMutex.RUnlock()
 	for _, row := range c.Rows {
 		json.Unmarshal(row.Payload, data)
 		return
@@ -272,13 +634,24 @@ func (c *Collection) FindOne(data interface{}) {
 }
 
 func (c *Collection) Traverse(f func(data []byte)) { // todo: return *Row instead of data?
-	for _, row := range c.Rows {
-		f(row.Payload)
+	c.TraverseRows(func(row *Row) bool {
+		f(row.GetPayload())
+		return true
+	})
+}
+
+// TraverseRows calls f with every row until it returns false, f can modify
+// the collection
+func (c *Collection) TraverseRows(f func(row *Row) bool) {
+	for _, row := range c.rows() {
+		if !f(row) {
+			return
+		}
 	}
 }
 
 func (c *Collection) TraverseRange(from, to int, f func(row *Row)) { // todo: improve this naive  implementation
-	for i, row := range c.Rows {
+	for i, row := range c.rows() {
 		if i < from {
 			continue
 		}
@@ -302,45 +675,106 @@ type CreateIndexCommand struct {
 }
 
 func (c *Collection) SetDefaults(defaults map[string]any) error {
+	if c.Options.Replicate != nil {
+		command, err := newDefaultsCommand(defaults)
+		if err != nil {
+			return err
+		}
+		return c.propose(command)
+	}
+
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
 	return c.setDefaults(defaults, true)
 }
 
 func (c *Collection) setDefaults(defaults map[string]any, persist bool) error {
 
+	c.rowsMutex.Lock()
 	c.Defaults = defaults
+	c.rowsMutex.Unlock()
 
 	if !persist {
 		return nil
 	}
 
+	command, err := newDefaultsCommand(defaults)
+	if err != nil {
+		return err
+	}
+
+	return c.EncodeCommand(command)
+}
+
+func newDefaultsCommand(defaults map[string]any) (*Command, error) {
+
 	payload, err := json.Marshal(defaults)
 	if err != nil {
-		return fmt.Errorf("json encode payload: %w", err)
+		return nil, fmt.Errorf("json encode payload: %w", err)
 	}
 
-	command := &Command{
+	return &Command{
 		Name:      "set_defaults", // todo: rename to create_index
 		Uuid:      uuid.New().String(),
 		Timestamp: time.Now().UnixNano(),
 		StartByte: 0,
 		Payload:   payload,
-	}
-
-	return c.EncodeCommand(command)
+	}, nil
 }
 
 // IndexMap create a unique index with a name
 // Constraints: values can be only scalar strings or array of strings
 func (c *Collection) Index(name string, options interface{}) error { // todo: rename to CreateIndex
+	if c.Options.Replicate != nil {
+		return c.proposeIndex(name, options)
+	}
+
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
 	return c.createIndex(name, options, true)
 }
 
 func (c *Collection) createIndex(name string, options interface{}, persist bool) error {
 
-	if _, exists := c.Indexes[name]; exists {
-		return fmt.Errorf("index '%s' already exists", name)
+	index, err := newCollectionIndex(options)
+	if err != nil {
+		return err
 	}
 
+	err = lockBlock(c.rowsMutex, func() error {
+		if _, exists := c.Indexes[name]; exists {
+			return fmt.Errorf("index '%s' already exists", name)
+		}
+
+		// Add all rows to the index
+		for _, row := range c.Rows {
+			err := index.AddRow(row)
+			if err != nil {
+				return fmt.Errorf("index row: %s, data: %s", err.Error(), string(row.Payload))
+			}
+		}
+
+		c.Indexes[name] = index
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !persist {
+		return nil
+	}
+
+	command, err := newIndexCommand(name, index)
+	if err != nil {
+		return err
+	}
+
+	return c.EncodeCommand(command)
+}
+
+func newCollectionIndex(options interface{}) (*collectionIndex, error) {
+
 	index := &collectionIndex{}
 
 	switch value := options.(type) {
@@ -353,42 +787,30 @@ func (c *Collection) createIndex(name string, options interface{}, persist bool)
 		index.Index = NewIndexBTree(value)
 		index.Options = value
 	default:
-		return fmt.Errorf("unexpected options parameters, it should be [map|btree]")
+		return nil, fmt.Errorf("unexpected options parameters, it should be [map|btree]")
 	}
 
-	c.Indexes[name] = index
-
-	// Add all rows to the index
-	for _, row := range c.Rows {
-		err := index.AddRow(row)
-		if err != nil {
-			delete(c.Indexes, name)
-			return fmt.Errorf("index row: %s, data: %s", err.Error(), string(row.Payload))
-		}
-	}
+	return index, nil
+}
 
-	if !persist {
-		return nil
-	}
+func newIndexCommand(name string, index *collectionIndex) (*Command, error) {
 
 	payload, err := json.Marshal(&CreateIndexCommand{
 		Name:    name,
 		Type:    index.Type,
-		Options: options,
+		Options: index.Options,
 	})
 	if err != nil {
-		return fmt.Errorf("json encode payload: %w", err)
+		return nil, fmt.Errorf("json encode payload: %w", err)
 	}
 
-	command := &Command{
+	return &Command{
 		Name:      "index", // todo: rename to create_index
 		Uuid:      uuid.New().String(),
 		Timestamp: time.Now().UnixNano(),
 		StartByte: 0,
 		Payload:   payload,
-	}
-
-	return c.EncodeCommand(command)
+	}, nil
 }
 
 func indexInsert(indexes map[string]*collectionIndex, row *Row) (err error) {
@@ -434,24 +856,34 @@ func indexRemove(indexes map[string]*collectionIndex, row *Row) (err error) {
 }
 
 func (c *Collection) Remove(r *Row) error {
-	return c.removeByRow(r, true)
+	if c.Options.Replicate != nil {
+		return c.proposeRemove(r)
+	}
+
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+	version := ""
+	if c.Options.Clock != nil {
+		version = c.Options.Clock.Now()
+	}
+	return c.removeByRow(r, true, version)
 }
 
 // TODO: move this to utils/diogenesis?
-func lockBlock(m *sync.Mutex, f func() error) error {
+func lockBlock(m sync.Locker, f func() error) error {
 	m.Lock()
 	defer m.Unlock()
 	return f()
 }
 
-func (c *Collection) removeByRow(row *Row, persist bool) error { // todo: rename to 'removeRow'
+// removeByRow keeps a tombstone with version in multi-primary mode
+func (c *Collection) removeByRow(row *Row, persist bool, version string) error { // todo: rename to 'removeRow'
 
-	var i int
 	err := lockBlock(c.rowsMutex, func() error {
-		i = row.I
-		if len(c.Rows) <= i {
-			return fmt.Errorf("row %d does not exist", i)
+		if c.rowsById[row.Id] != row {
+			return fmt.Errorf("row %d does not exist", row.Id)
 		}
+		i := row.I
 
 		err := indexRemove(c.Indexes, row)
 		if err != nil {
@@ -462,6 +894,13 @@ func (c *Collection) removeByRow(row *Row, persist bool) error { // todo: rename
 		c.Rows[i] = c.Rows[last]
 		c.Rows[i].I = i
 		c.Rows = c.Rows[:last]
+		delete(c.rowsById, row.Id)
+		if row.key != "" && c.rowsByKey[row.key] == row {
+			delete(c.rowsByKey, row.key)
+		}
+		if row.key != "" && version != "" {
+			c.setTombstone(row.key, version)
+		}
 		return nil
 	})
 	if err != nil {
@@ -473,64 +912,112 @@ func (c *Collection) removeByRow(row *Row, persist bool) error { // todo: rename
 	}
 
 	// Persist
-	payload, err := json.Marshal(map[string]interface{}{
-		"i": i,
-	})
-	if err != nil {
-		return err // todo: wrap error
-	}
+	return c.EncodeCommand(newRemoveCommand(row, version))
+}
+
+func newRemoveCommand(row *Row, version string) *Command {
 	command := &Command{
 		Name:      "remove",
 		Uuid:      uuid.New().String(),
 		Timestamp: time.Now().UnixNano(),
 		StartByte: 0,
-		Payload:   payload,
+		RowId:     row.Id,
+		Payload:   json.RawMessage("{}"),
+		Key:       row.key,
+		Version:   version,
 	}
-
-	return c.EncodeCommand(command)
+	if command.Key == "" {
+		command.Key = DocumentKey(row.GetPayload()) // tombstone for Changes
+	}
+	return command
 }
 
 func (c *Collection) Patch(row *Row, patch interface{}) error {
+	if c.Options.Replicate != nil {
+		return c.proposePatch(row, patch)
+	}
+
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+	if c.Options.Clock != nil {
+		patch = stampPatch(patch, c.Options.Clock.Now())
+	}
 	return c.patchByRow(row, patch, true)
 }
 
-func (c *Collection) patchByRow(row *Row, patch interface{}, persist bool) error { // todo: rename to 'patchRow'
+// Replace patches a row so it becomes document, the versions of multi-primary
+// mode are kept
+func (c *Collection) Replace(row *Row, document map[string]any) error {
 
-	originalValue, err := decodeJSONValue(row.Payload)
+	current := map[string]any{}
+	err := json.Unmarshal(row.GetPayload(), &current)
 	if err != nil {
 		return fmt.Errorf("decode row payload: %w", err)
 	}
+	modified := make(map[string]any, len(document)+1)
+	for field, value := range document {
+		modified[field] = value
+	}
+	delete(modified, VersionsField)
+	if versions, exists := current[VersionsField]; exists {
+		modified[VersionsField] = versions
+	}
 
-	normalizedPatch, err := normalizeJSONValue(patch)
+	// Compare JSON values (numbers are float64)
+	payload, err := json.Marshal(modified)
 	if err != nil {
-		return fmt.Errorf("normalize patch: %w", err)
+		return fmt.Errorf("json encode document: %w", err)
 	}
-
-	newValue, changed, err := applyMergePatchValue(originalValue, normalizedPatch)
+	normalized, err := decodeJSONValue(payload)
 	if err != nil {
-		return fmt.Errorf("cannot apply patch: %w", err)
+		return fmt.Errorf("decode document: %w", err)
 	}
-
+	diff, changed := createMergeDiff(current, normalized)
 	if !changed {
 		return nil
 	}
 
+	return c.Patch(row, diff)
+}
+
+func (c *Collection) patchByRow(row *Row, patch interface{}, persist bool) error { // todo: rename to 'patchRow'
+
+	originalValue, newValue, changed, err := patchValue(row.GetPayload(), patch)
+	if err != nil || !changed {
+		return err
+	}
+
 	newPayload, err := json.Marshal(newValue)
 	if err != nil {
 		return fmt.Errorf("marshal payload: %w", err)
 	}
 
-	// index update
-	err = indexRemove(c.Indexes, row)
-	if err != nil {
-		return fmt.Errorf("indexRemove: %w", err)
+	key := ""
+	if c.Options.Clock != nil {
+		key = DocumentKey(newPayload)
 	}
 
-	row.Payload = newPayload
+	err = lockBlock(c.rowsMutex, func() error {
+		// index update
+		err := indexRemove(c.Indexes, row)
+		if err != nil {
+			return fmt.Errorf("indexRemove: %w", err)
+		}
+
+		row.Payload = newPayload
+
+		err = indexInsert(c.Indexes, row)
+		if err != nil {
+			return fmt.Errorf("indexInsert: %w", err)
+		}
 
-	err = indexInsert(c.Indexes, row)
+		if c.Options.Clock != nil && key != row.key && c.rowsById[row.Id] == row {
+			c.trackKey(row, key)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("indexInsert: %w", err)
+		return err
 	}
 
 	if !persist {
@@ -543,22 +1030,58 @@ func (c *Collection) patchByRow(row *Row, patch interface{}, persist bool) error
 	}
 
 	// Persist
+	command, err := newPatchCommand(row, diffValue)
+	if err != nil {
+		return err
+	}
+
+	return c.EncodeCommand(command)
+}
+
+// patchValue applies a merge patch to a payload, changed is false if the
+// result is the same
+func patchValue(payload json.RawMessage, patch interface{}) (original, patched interface{}, changed bool, err error) {
+
+	original, err = decodeJSONValue(payload)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("decode row payload: %w", err)
+	}
+
+	normalizedPatch, err := normalizeJSONValue(patch)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("normalize patch: %w", err)
+	}
+
+	patched, changed, err = applyMergePatchValue(original, normalizedPatch)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("cannot apply patch: %w", err)
+	}
+
+	return original, patched, changed, nil
+}
+
+func newPatchCommand(row *Row, diffValue interface{}) (*Command, error) {
+
 	payload, err := json.Marshal(map[string]interface{}{
-		"i":    row.I,
 		"diff": diffValue,
 	})
 	if err != nil {
-		return err // todo: wrap error
+		return nil, err // todo: wrap error
 	}
 	command := &Command{
 		Name:      "patch",
 		Uuid:      uuid.New().String(),
 		Timestamp: time.Now().UnixNano(),
 		StartByte: 0,
+		RowId:     row.Id,
 		Payload:   payload,
+		Key:       row.key,
+	}
+	if diff, ok := diffValue.(map[string]any); ok {
+		command.Version = maxVersion(documentVersions(diff))
 	}
 
-	return c.EncodeCommand(command)
+	return command, nil
 }
 
 func decodeJSONValue(raw json.RawMessage) (interface{}, error) {
@@ -787,8 +1310,25 @@ func cloneJSONArray(values []interface{}) []interface{} {
 }
 
 func (c *Collection) Close() error {
+	c.stopFlusher()
+	c.stopWatchers(fmt.Errorf("collection is closed"))
+
+	c.journalMutex.Lock()
+	defer c.journalMutex.Unlock()
+
+	if c.buffer == nil {
+		return nil // read only, see ReplayCollection
+	}
+
 	{
-		err := c.buffer.Flush()
+		err := c.flushBuffer()
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.GetDurability().Mode != DurabilityNone {
+		err := c.file.Sync()
 		if err != nil {
 			return err
 		}
@@ -810,10 +1350,28 @@ func (c *Collection) Drop() error {
 		return fmt.Errorf("remove: %w", err)
 	}
 
+	err = os.RemoveAll(SegmentsDir(c.Filename))
+	if err != nil {
+		return fmt.Errorf("remove segments: %w", err)
+	}
+
 	return nil
 }
 
 func (c *Collection) DropIndex(name string) error {
+	if c.Options.Replicate != nil {
+		if _, exists := c.GetIndex(name); !exists {
+			return fmt.Errorf("dropIndex: index '%s' not found", name)
+		}
+		command, err := newDropIndexCommand(name)
+		if err != nil {
+			return err
+		}
+		return c.propose(command)
+	}
+
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
 	return c.dropIndex(name, true)
 }
 
@@ -822,51 +1380,91 @@ type DropIndexCommand struct {
 }
 
 func (c *Collection) dropIndex(name string, persist bool) error {
-	_, exists := c.Indexes[name]
-	if !exists {
-		return fmt.Errorf("dropIndex: index '%s' not found", name)
+	err := lockBlock(c.rowsMutex, func() error {
+		_, exists := c.Indexes[name]
+		if !exists {
+			return fmt.Errorf("dropIndex: index '%s' not found", name)
+		}
+		delete(c.Indexes, name)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	delete(c.Indexes, name)
 
 	if !persist {
 		return nil
 	}
 
+	command, err := newDropIndexCommand(name)
+	if err != nil {
+		return err
+	}
+
+	return c.EncodeCommand(command)
+}
+
+func newDropIndexCommand(name string) (*Command, error) {
+
 	payload, err := json.Marshal(&CreateIndexCommand{
 		Name: name,
 	})
 	if err != nil {
-		return fmt.Errorf("json encode payload: %w", err)
+		return nil, fmt.Errorf("json encode payload: %w", err)
 	}
 
-	command := &Command{
+	return &Command{
 		Name:      "drop_index",
 		Uuid:      uuid.New().String(),
 		Timestamp: time.Now().UnixNano(),
 		StartByte: 0,
 		Payload:   payload,
-	}
-
-	return c.EncodeCommand(command)
+	}, nil
 }
 
 func (c *Collection) EncodeCommand(command *Command) error {
 
+	c.trackChange(command, false)
+
 	em := encPool.Get().(*EncoderMachine)
 	defer encPool.Put(em)
-	em.Buffer.Reset()
 
-	// err := em.Enc.Encode(command)
-	err := json2.MarshalEncode(em.Enc2, command)
-	// err := json2.MarshalWrite(em.Buffer, command)
+	b, err := em.encodeRecord(command, c.Options.Encryption)
 	if err != nil {
 		return err
 	}
 
-	b := em.Buffer.Bytes()
 	c.encoderMutex.Lock()
+	if c.buffer == nil {
+		c.encoderMutex.Unlock()
+		return fmt.Errorf("collection is read only")
+	}
 	c.buffer.Write(b)
 	//	c.file.Write(b)
+	c.active.size += int64(len(b))
+	c.bytesWritten += int64(len(b))
+	c.active.track(command)
+	c.trackLast(command)
+	c.commands++
+	c.written++
+	seq := c.written
+	c.notifyWatchers(command, c.commands)
 	c.encoderMutex.Unlock()
+
+	if c.GetDurability().Mode == DurabilitySync {
+		err = c.waitDurable(seq)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.needsCompaction() {
+		go c.autoCompact()
+	}
+
+	if c.needsRotation() && !c.rotating.Load() {
+		go c.autoRotate()
+	}
+
 	return nil
 }
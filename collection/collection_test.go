@@ -9,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -33,9 +34,14 @@ func TestInsert(t *testing.T) {
 		c.Close()
 
 		// Check
-		fileContent, _ := ioutil.ReadFile(filename)
+		f, _ := os.Open(filename)
+		defer f.Close()
+		d := json.NewDecoder(f)
+		format := &Command{}
+		d.Decode(format)
+		AssertEqual(format.Name, "format")
 		command := &Command{}
-		json.Unmarshal(fileContent, command)
+		d.Decode(command)
 		AssertEqual(string(command.Payload), `{"hello":"world"}`)
 	})
 }
@@ -62,6 +68,35 @@ func TestCollection_Insert_Concurrency(t *testing.T) {
 	})
 }
 
+func TestCollection_Insert_MaxDocuments(t *testing.T) {
+	Environment(func(filename string) {
+
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		c.SetMaxDocuments(1000)
+
+		limited := int64(0)
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 64; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					_, err := c.Insert(map[string]interface{}{"hello": "world"})
+					if errors.Is(err, ErrDocumentLimit) {
+						atomic.AddInt64(&limited, 1)
+					}
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		AssertEqual(c.Len(), 1000)
+		AssertEqual(limited, int64(64*100-1000))
+	})
+}
+
 func TestFindOne(t *testing.T) {
 	Environment(func(filename string) {
 
@@ -418,6 +453,10 @@ func (m *MockIndex) Traverse(options []byte, f func(row *Row) bool) {
 	panic("implement me")
 }
 
+func (m *MockIndex) Len() int {
+	return 0
+}
+
 func TestIndexInsert_Rollback(t *testing.T) {
 
 	adds := []string{}
@@ -556,3 +595,146 @@ func TestInsert1M_concurrent(t *testing.T) {
 }
 
 // TODO: test concurrent delete
+
+func TestCompact(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.SetDefaults(map[string]any{"id": "uuid()"})
+		c.Index("my-index", &IndexMapOptions{
+			Field: "id",
+		})
+		row, _ := c.Insert(map[string]interface{}{"id": "1", "name": "Pablo"})
+		removed, _ := c.Insert(map[string]interface{}{"id": "2", "name": "Sara"})
+		c.Insert(map[string]interface{}{"id": "3", "name": "Ana"})
+		for i := 0; i < 10; i++ {
+			c.Patch(row, map[string]interface{}{"n": i})
+		}
+		c.Remove(removed)
+
+		// Run
+		stats, err := c.Compact()
+		AssertNil(err)
+		c.Insert(map[string]interface{}{"id": "4", "name": "Maria"})
+		c.Close()
+
+		// Check
+		AssertEqual(stats.CommandsBefore, int64(17))
+		AssertEqual(stats.CommandsAfter, int64(5))
+		AssertTrue(stats.BytesAfter < stats.BytesBefore)
+
+		c, err = OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+
+		AssertEqual(len(c.Rows), 3)
+		AssertEqual(c.Defaults, map[string]any{"id": "uuid()"})
+		AssertEqual(c.GarbageRatio(), 0.0)
+		user := struct {
+			Id   string
+			Name string
+			N    int
+		}{}
+		n := findByIndex(c.Indexes["my-index"], `{"value":"1"}`, &user)
+		AssertEqual(n, 1)
+		AssertEqual(user.N, 9)
+		n = findByIndex(c.Indexes["my-index"], `{"value":"2"}`, &user)
+		AssertEqual(n, 0)
+	})
+}
+
+func TestCompact_Automatic(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollectionWithOptions(filename, &Options{
+			Compaction: &CompactionOptions{
+				GarbageRatio: 0.5,
+				MinCommands:  10,
+			},
+		})
+		row, _ := c.Insert(map[string]interface{}{"id": "1"})
+
+		// Run
+		for i := 0; i < 20; i++ {
+			c.Patch(row, map[string]interface{}{"n": i})
+		}
+
+		// Check
+		for i := 0; i < 100 && c.GarbageRatio() > 0.5; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		AssertTrue(c.GarbageRatio() <= 0.5)
+		c.Close()
+
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		AssertEqual(string(c.Rows[0].Payload), `{"id":"1","n":19}`)
+	})
+}
+
+func TestPersistence_StableRowIds(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		row1, _ := c.Insert(map[string]interface{}{"id": "1"})
+		row2, _ := c.Insert(map[string]interface{}{"id": "2"})
+		row3, _ := c.Insert(map[string]interface{}{"id": "3"})
+
+		// Run
+		c.Remove(row1) // row3 takes the position of row1
+		c.Patch(row3, map[string]interface{}{"name": "three"})
+		c.Patch(row2, map[string]interface{}{"name": "two"})
+		AssertNotNil(c.Remove(row1))
+		c.Close()
+
+		// Check
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 2)
+		AssertNil(c.GetRowById(row1.Id))
+		AssertEqual(string(c.GetRowById(row2.Id).Payload), `{"id":"2","name":"two"}`)
+		AssertEqual(string(c.GetRowById(row3.Id).Payload), `{"id":"3","name":"three"}`)
+	})
+}
+
+func TestPersistence_MigrateVersion1(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup: journal written by a previous version (positional references)
+		ioutil.WriteFile(filename, []byte(`{"name":"insert","uuid":"a","timestamp":1,"start_byte":0,"payload":{"id":"1"}}
+{"name":"insert","uuid":"b","timestamp":2,"start_byte":0,"payload":{"id":"2"}}
+{"name":"insert","uuid":"c","timestamp":3,"start_byte":0,"payload":{"id":"3"}}
+{"name":"remove","uuid":"d","timestamp":4,"start_byte":0,"payload":{"i":0}}
+{"name":"patch","uuid":"e","timestamp":5,"start_byte":0,"payload":{"i":0,"diff":{"name":"three"}}}
+`), 0666)
+
+		// Run
+		c, err := OpenCollection(filename)
+		AssertNil(err)
+		AssertEqual(string(c.Rows[0].Payload), `{"id":"3","name":"three"}`)
+		c.Remove(c.Rows[0])
+		c.Insert(map[string]interface{}{"id": "4"})
+		c.Close()
+
+		// Check
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 2)
+		AssertEqual(string(c.Rows[0].Payload), `{"id":"2"}`)
+		AssertEqual(string(c.Rows[1].Payload), `{"id":"4"}`)
+		AssertEqual(c.Rows[1].Id, int64(4))
+
+		_, err = c.Compact()
+		AssertNil(err)
+		c.Close()
+
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		AssertEqual(c.Rows[1].Id, int64(4))
+		row, _ := c.Insert(map[string]interface{}{"id": "5"})
+		AssertEqual(row.Id, int64(5))
+	})
+}
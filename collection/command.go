@@ -2,6 +2,9 @@ package collection
 
 import (
 	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 type Command struct {
@@ -9,5 +12,48 @@ type Command struct {
 	Uuid      string          `json:"uuid"`
 	Timestamp int64           `json:"timestamp"`
 	StartByte int64           `json:"start_byte"`
+	RowId     int64           `json:"row_id,omitzero"` // row affected by insert, patch and remove commands
 	Payload   json.RawMessage `json:"payload"`
+	Key       string          `json:"key,omitzero"`      // document id of insert, patch and remove commands in multi-primary mode
+	Version   string          `json:"version,omitzero"`  // HLC version of the change in multi-primary mode
+	Sequence  int64           `json:"sequence,omitzero"` // order of insert, patch and remove commands, see Changes
+	Checksum  uint32          `json:"checksum,omitzero"` // filled when reading the journal
+	Length    int             `json:"length,omitzero"`   // filled when reading the journal
+}
+
+// JournalVersion is the current journal format:
+//   - 1: remove and patch commands reference rows by their position
+//   - 2: rows have an immutable id (row_id) referenced by the commands
+const JournalVersion = 2
+
+type FormatCommand struct {
+	Version   int   `json:"version"`
+	LastRowId int64 `json:"last_row_id,omitzero"`
+	Sequence  int64 `json:"sequence,omitzero"` // last sequence assigned
+	Horizon   int64 `json:"horizon,omitzero"`  // last removal forgotten by a compaction
+}
+
+// newFormatCommand starts a journal, or a snapshot if compaction is set
+func (c *Collection) newFormatCommand(compaction bool) *Command {
+
+	c.rowsMutex.Lock()
+	format := &FormatCommand{
+		Version:   JournalVersion,
+		LastRowId: c.lastRowId,
+		Sequence:  c.changes.sequence,
+		Horizon:   c.changes.horizon,
+	}
+	if compaction {
+		format.Horizon = c.changes.forgettable(c.tombstones)
+	}
+	payload, _ := json.Marshal(format)
+	c.rowsMutex.Unlock()
+
+	return &Command{
+		Name:      "format",
+		Uuid:      uuid.New().String(),
+		Timestamp: time.Now().UnixNano(),
+		StartByte: 0,
+		Payload:   payload,
+	}
 }
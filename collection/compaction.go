@@ -0,0 +1,372 @@
+package collection
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CompactionOptions struct {
+	// GarbageRatio triggers an automatic compaction when the fraction of
+	// journal commands that do not contribute to the current state is greater
+	// than this value. Zero disables automatic compaction.
+	GarbageRatio float64
+	// MinCommands avoids compacting small journals.
+	MinCommands int64
+}
+
+type CompactionStats struct {
+	CommandsBefore int64         `json:"commands_before"`
+	CommandsAfter  int64         `json:"commands_after"`
+	BytesBefore    int64         `json:"bytes_before"`
+	BytesAfter     int64         `json:"bytes_after"`
+	Took           time.Duration `json:"took"`
+}
+
+// Commands returns the number of commands in the journal
+func (c *Collection) Commands() int64 {
+	c.encoderMutex.Lock()
+	defer c.encoderMutex.Unlock()
+	return c.commands
+}
+
+// GarbageRatio returns the fraction of journal commands that are not needed
+// to rebuild the current state (patches, removed rows, dropped indexes...).
+func (c *Collection) GarbageRatio() float64 {
+	c.encoderMutex.Lock()
+	commands := c.commands
+	c.encoderMutex.Unlock()
+
+	if commands == 0 {
+		return 0
+	}
+
+	garbage := commands - c.liveCommands()
+	if garbage <= 0 {
+		return 0
+	}
+
+	return float64(garbage) / float64(commands)
+}
+
+// liveCommands is the number of commands a fresh snapshot would contain
+func (c *Collection) liveCommands() int64 {
+	c.rowsMutex.RLock()
+	live := int64(len(c.Rows)) + 1 // format command
+	live += int64(len(c.Indexes))
+	if c.Defaults != nil {
+		live++
+	}
+	c.rowsMutex.RUnlock()
+	if c.Durability() != nil {
+		live++
+	}
+
+	return live
+}
+
+func (c *Collection) needsCompaction() bool {
+
+	options := c.Options.Compaction
+	if options == nil || options.GarbageRatio <= 0 {
+		return false
+	}
+
+	if c.compacting.Load() {
+		return false
+	}
+
+	c.encoderMutex.Lock()
+	commands := c.commands
+	c.encoderMutex.Unlock()
+
+	if commands < options.MinCommands {
+		return false
+	}
+
+	return c.GarbageRatio() > options.GarbageRatio
+}
+
+func (c *Collection) autoCompact() {
+	stats, err := c.Compact()
+	if err == ErrCompactionInProgress {
+		return
+	}
+	if err != nil {
+		slog.Error("compact", "collection", c.Filename, "error", err)
+		return
+	}
+	slog.Info("compacted", "collection", c.Filename, "commands_before", stats.CommandsBefore, "commands_after", stats.CommandsAfter, "took", stats.Took)
+}
+
+var ErrCompactionInProgress = fmt.Errorf("compaction already in progress")
+
+// Compact rewrites the journal as a snapshot of the current state (defaults,
+// indexes and rows) so that the segments it replaces can be deleted.
+//
+// Writes are only blocked while the active segment is sealed and the snapshot
+// is taken, and while the manifest is updated. New commands go to the new
+// active segment meanwhile.
+func (c *Collection) Compact() (*CompactionStats, error) {
+
+	if !c.compacting.CompareAndSwap(false, true) {
+		return nil, ErrCompactionInProgress
+	}
+	defer c.compacting.Store(false)
+
+	t0 := time.Now()
+	stats := &CompactionStats{}
+
+	// Take the snapshot
+	c.journalMutex.Lock()
+	if c.file == nil {
+		c.journalMutex.Unlock()
+		return nil, fmt.Errorf("collection is closed")
+	}
+	err := c.flushBuffer()
+	if err != nil {
+		c.journalMutex.Unlock()
+		return nil, fmt.Errorf("flush: %w", err)
+	}
+	stats.BytesBefore = c.journalSize()
+
+	c.encoderMutex.Lock()
+	stats.CommandsBefore = c.commands
+	c.encoderMutex.Unlock()
+
+	segments := c.manifest.Segments
+	if c.active.size == 0 && (len(segments) == 0 || (len(segments) == 1 && segments[0].Snapshot)) {
+		// Nothing to compact
+		c.journalMutex.Unlock()
+		stats.CommandsAfter = stats.CommandsBefore
+		stats.BytesAfter = stats.BytesBefore
+		stats.Took = time.Since(t0)
+		return stats, nil
+	}
+
+	_, err = c.sealActiveSegment()
+	if err != nil {
+		c.journalMutex.Unlock()
+		return nil, err
+	}
+	base := c.manifest.LastId
+
+	snapshot, err := c.snapshotCommands()
+	if err != nil {
+		c.journalMutex.Unlock()
+		return nil, err
+	}
+	sequence := c.Sequence()
+	c.journalMutex.Unlock()
+
+	// Write the snapshot without blocking writers
+	dir := SegmentsDir(c.Filename)
+	segment := &Segment{
+		Id:             base,
+		File:           fmt.Sprintf("%06d.snapshot.jsonl", base),
+		Snapshot:       true,
+		Commands:       int64(len(snapshot)),
+		FirstTimestamp: snapshot[0].Timestamp,
+		LastTimestamp:  snapshot[0].Timestamp,
+		SealedAt:       time.Now().UnixNano(),
+	}
+	segmentFilename := path.Join(dir, segment.File)
+
+	segment.Size, err = writeSnapshot(segmentFilename, snapshot, c.Options.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("write snapshot: %w", err)
+	}
+
+	// Commit
+	c.journalMutex.Lock()
+	defer c.journalMutex.Unlock()
+
+	if c.file == nil {
+		os.Remove(segmentFilename)
+		return nil, fmt.Errorf("collection closed while compacting")
+	}
+
+	previous := c.manifest.Segments
+	obsolete := []*Segment{}
+	kept := []*Segment{segment}
+	for _, s := range previous {
+		if s.Id <= base {
+			obsolete = append(obsolete, s)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+
+	c.manifest.Segments = kept
+	err = writeManifest(dir, c.manifest)
+	if err != nil {
+		c.manifest.Segments = previous
+		os.Remove(segmentFilename)
+		return nil, err
+	}
+
+	for _, s := range obsolete {
+		os.Remove(path.Join(dir, s.File))
+	}
+
+	c.rowsMutex.Lock()
+	c.changes.forget(sequence, c.tombstones)
+	c.rowsMutex.Unlock()
+
+	c.encoderMutex.Lock()
+	c.commands = int64(len(snapshot)) + c.commands - stats.CommandsBefore
+	stats.CommandsAfter = c.commands
+	c.encoderMutex.Unlock()
+
+	stats.BytesAfter = c.journalSize()
+	stats.Took = time.Since(t0)
+
+	return stats, nil
+}
+
+func writeSnapshot(filename string, commands []*Command, keyring *Keyring) (int64, error) {
+
+	tmpFilename := filename + ".tmp"
+	defer os.Remove(tmpFilename) // Only takes effect if something goes wrong
+
+	f, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriterSize(f, 16*1024*1024)
+	err = writeCommands(w, commands, keyring)
+	if err != nil {
+		return 0, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return 0, err
+	}
+	err = f.Sync()
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		return 0, err
+	}
+	syncDir(path.Dir(filename))
+
+	return info.Size(), nil
+}
+
+// snapshotCommands must be called holding journalMutex in write mode
+func (c *Collection) snapshotCommands() ([]*Command, error) {
+
+	commands := make([]*Command, 0, c.liveCommands())
+	now := time.Now().UnixNano()
+
+	format := c.newFormatCommand(true)
+	format.Timestamp = now
+	commands = append(commands, format)
+
+	if c.Defaults != nil {
+		payload, err := json.Marshal(c.Defaults)
+		if err != nil {
+			return nil, fmt.Errorf("json encode defaults: %w", err)
+		}
+		commands = append(commands, &Command{
+			Name:      "set_defaults",
+			Uuid:      uuid.New().String(),
+			Timestamp: now,
+			Payload:   payload,
+		})
+	}
+
+	if durability := c.Durability(); durability != nil {
+		command, err := newDurabilityCommand(durability)
+		if err != nil {
+			return nil, err
+		}
+		command.Timestamp = now
+		commands = append(commands, command)
+	}
+
+	for name, index := range c.Indexes {
+		command, err := newIndexCommand(name, index)
+		if err != nil {
+			return nil, err
+		}
+		command.Timestamp = now
+		commands = append(commands, command)
+	}
+
+	c.rowsMutex.Lock()
+	defer c.rowsMutex.Unlock()
+	for _, row := range c.Rows {
+		command := &Command{
+			Name:      "insert",
+			Uuid:      uuid.New().String(),
+			Timestamp: now,
+			RowId:     row.Id,
+			Payload:   row.Payload,
+		}
+		if entry, exists := c.changes.rows[row.Id]; exists {
+			command.Sequence = entry.sequence
+		}
+		commands = append(commands, command)
+	}
+	for key, version := range c.tombstones {
+		command := &Command{
+			Name:      "remove",
+			Uuid:      uuid.New().String(),
+			Timestamp: now,
+			Payload:   json.RawMessage("{}"),
+			Key:       key,
+			Version:   version,
+		}
+		if entry, exists := c.changes.removed[key]; exists {
+			command.Sequence = entry.sequence
+		}
+		commands = append(commands, command)
+	}
+
+	return commands, nil
+}
+
+func writeCommands(w *bufio.Writer, commands []*Command, keyring *Keyring) error {
+
+	em := encPool.Get().(*EncoderMachine)
+	defer encPool.Put(em)
+
+	for _, command := range commands {
+		b, err := em.encodeRecord(command, keyring)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,240 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DurabilityNone only writes to disk when the 16MB buffer is full or the
+	// collection is closed. Fastest, but a crash loses acknowledged writes.
+	DurabilityNone = "none"
+	// DurabilityInterval flushes and fsyncs the journal periodically.
+	DurabilityInterval = "interval"
+	// DurabilitySync does not acknowledge a write until it is fsynced. Concurrent
+	// writers share the same fsync (group commit).
+	DurabilitySync = "sync"
+)
+
+const DefaultDurabilityInterval = time.Second
+
+type DurabilityOptions struct {
+	Mode     string        `json:"mode"`
+	Interval time.Duration `json:"interval"`
+}
+
+func (d *DurabilityOptions) Validate() error {
+	switch d.Mode {
+	case DurabilityNone, DurabilitySync:
+		return nil
+	case DurabilityInterval:
+		if d.Interval < 0 {
+			return fmt.Errorf("durability interval must be positive")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected durability mode '%s' instead of [%s|%s|%s]", d.Mode, DurabilityNone, DurabilityInterval, DurabilitySync)
+	}
+}
+
+// GetDurability returns the effective durability policy: the collection one if
+// it has been set, otherwise the one inherited from Options.
+func (c *Collection) GetDurability() *DurabilityOptions {
+	if durability := c.durability.Load(); durability != nil {
+		return durability
+	}
+	if c.Options.Durability != nil {
+		return c.Options.Durability
+	}
+	return &DurabilityOptions{Mode: DurabilityNone}
+}
+
+// Durability returns the policy set on this collection, nil if it is
+// inherited from Options
+func (c *Collection) Durability() *DurabilityOptions {
+	return c.durability.Load()
+}
+
+// SetDurability overrides the durability policy of this collection, nil means
+// inherit it from Options again.
+func (c *Collection) SetDurability(durability *DurabilityOptions) error {
+	if c.Options.Replicate != nil {
+		if durability != nil {
+			err := durability.Validate()
+			if err != nil {
+				return err
+			}
+		}
+		command, err := newDurabilityCommand(durability)
+		if err != nil {
+			return err
+		}
+		return c.propose(command)
+	}
+
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+	return c.setDurability(durability, true)
+}
+
+func (c *Collection) setDurability(durability *DurabilityOptions, persist bool) error {
+
+	if durability != nil {
+		err := durability.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.durability.Store(durability)
+
+	if !persist {
+		return nil
+	}
+
+	c.startFlusher()
+
+	command, err := newDurabilityCommand(durability)
+	if err != nil {
+		return err
+	}
+
+	return c.EncodeCommand(command)
+}
+
+func newDurabilityCommand(durability *DurabilityOptions) (*Command, error) {
+
+	payload, err := json.Marshal(durability)
+	if err != nil {
+		return nil, fmt.Errorf("json encode payload: %w", err)
+	}
+
+	return &Command{
+		Name:      "set_durability",
+		Uuid:      uuid.New().String(),
+		Timestamp: time.Now().UnixNano(),
+		StartByte: 0,
+		Payload:   payload,
+	}, nil
+}
+
+// startFlusher (re)starts the background flush loop according to the
+// effective durability policy
+func (c *Collection) startFlusher() {
+
+	c.flusherMutex.Lock()
+	defer c.flusherMutex.Unlock()
+
+	if c.flusherStop != nil {
+		close(c.flusherStop)
+		c.flusherStop = nil
+	}
+
+	durability := c.GetDurability()
+	if durability.Mode != DurabilityInterval {
+		return
+	}
+
+	interval := durability.Interval
+	if interval <= 0 {
+		interval = DefaultDurabilityInterval
+	}
+
+	stop := make(chan struct{})
+	c.flusherStop = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				err := c.Sync()
+				if err != nil {
+					slog.Error("flush", "collection", c.Filename, "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (c *Collection) stopFlusher() {
+	c.flusherMutex.Lock()
+	defer c.flusherMutex.Unlock()
+
+	if c.flusherStop != nil {
+		close(c.flusherStop)
+		c.flusherStop = nil
+	}
+}
+
+// Sync flushes the write buffer and fsyncs the journal
+func (c *Collection) Sync() error {
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+
+	if c.file == nil {
+		return fmt.Errorf("collection is closed")
+	}
+
+	_, err := c.flushAndSync()
+	return err
+}
+
+// flushAndSync returns the sequence number of the last command that is safe
+// on disk. It must be called holding journalMutex (read mode is enough).
+func (c *Collection) flushAndSync() (int64, error) {
+
+	c.encoderMutex.Lock()
+	written := c.written
+	err := c.flushBuffer()
+	c.encoderMutex.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("flush: %w", err)
+	}
+
+	err = c.file.Sync()
+	if err != nil {
+		return 0, fmt.Errorf("fsync: %w", err)
+	}
+
+	return written, nil
+}
+
+// waitDurable blocks until the command with sequence number seq is fsynced.
+// The first waiter performs the fsync on behalf of everybody else waiting,
+// writers arriving meanwhile will be covered by the next one.
+func (c *Collection) waitDurable(seq int64) error {
+
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+
+	for c.synced < seq {
+		if c.syncing {
+			c.syncCond.Wait()
+			continue
+		}
+
+		c.syncing = true
+		c.syncMutex.Unlock()
+		synced, err := c.flushAndSync()
+		c.syncMutex.Lock()
+		c.syncing = false
+		if synced > c.synced {
+			c.synced = synced
+		}
+		c.syncCond.Broadcast()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package collection
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestDurability_Sync(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollectionWithOptions(filename, &Options{
+			Durability: &DurabilityOptions{Mode: DurabilitySync},
+		})
+		defer c.Close()
+
+		// Run
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Insert(map[string]interface{}{"hello": "world"})
+			}()
+		}
+		wg.Wait()
+
+		// Check: everything acknowledged is already on disk (plus format command)
+		fileContent, _ := os.ReadFile(filename)
+		AssertEqual(strings.Count(string(fileContent), "\n"), 51)
+	})
+}
+
+func TestDurability_Interval(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollectionWithOptions(filename, &Options{
+			Durability: &DurabilityOptions{Mode: DurabilityInterval, Interval: 10 * time.Millisecond},
+		})
+		defer c.Close()
+
+		// Run
+		c.Insert(map[string]interface{}{"hello": "world"})
+
+		// Check
+		fileContent := []byte{}
+		for i := 0; i < 100 && !strings.Contains(string(fileContent), "hello"); i++ {
+			time.Sleep(10 * time.Millisecond)
+			fileContent, _ = os.ReadFile(filename)
+		}
+		AssertEqual(strings.Count(string(fileContent), "\n"), 2)
+	})
+}
+
+func TestDurability_Persisted(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		err := c.SetDurability(&DurabilityOptions{Mode: DurabilitySync})
+		AssertNil(err)
+		c.Close()
+
+		// Run
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+
+		// Check
+		AssertEqual(c.GetDurability().Mode, DurabilitySync)
+		AssertNotNil(c.SetDurability(&DurabilityOptions{Mode: "invented"}))
+	})
+}
+
+func TestDurability_Concurrent(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+
+		// Run: go test -race complains if the policy is not protected
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				c.Insert(map[string]interface{}{"hello": "world"})
+			}()
+			go func(i int) {
+				defer wg.Done()
+				mode := DurabilityNone
+				if i%2 == 0 {
+					mode = DurabilitySync
+				}
+				c.SetDurability(&DurabilityOptions{Mode: mode})
+			}(i)
+		}
+		wg.Wait()
+
+		// Check
+		AssertEqual(len(c.Rows), 20)
+		AssertNotNil(c.Durability())
+	})
+}
@@ -0,0 +1,318 @@
+package collection
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Encrypted journal records are sealed with AES-GCM and stored in an envelope
+// that names the key, so records written under different keys can coexist:
+//
+//	{"key":"1a2b3c4d","data":"<base64 of nonce + ciphertext>"}
+//
+// The plaintext is the complete record, including its checksum trailer.
+
+var sealedPrefix = []byte(`{"key":"`)
+
+// ErrUnknownKey means a record is encrypted with a key that is not in the
+// keyring. It is never treated as a torn record.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+type Keyring struct {
+	current *journalKey
+	keys    map[string]*journalKey
+}
+
+type journalKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+type sealedRecord struct {
+	Key  string `json:"key"`
+	Data []byte `json:"data"`
+}
+
+// NewKeyring builds a keyring from AES keys (16, 24 or 32 bytes). The first one
+// encrypts new records, the rest are only used to read older records.
+func NewKeyring(keys ...[]byte) (*Keyring, error) {
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
+	}
+
+	keyring := &Keyring{
+		keys: map[string]*journalKey{},
+	}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		k := &journalKey{
+			id:   hex.EncodeToString(sum[:4]),
+			aead: aead,
+		}
+		if keyring.current == nil {
+			keyring.current = k
+		}
+		keyring.keys[k.id] = k
+	}
+
+	return keyring, nil
+}
+
+// ParseKeyring reads keys encoded in hex or base64, separated by commas or
+// whitespace, the first one is the current key. Returns nil if there are none.
+func ParseKeyring(text string) (*Keyring, error) {
+
+	keys := [][]byte{}
+	for _, field := range strings.Fields(strings.ReplaceAll(text, ",", " ")) {
+		key, err := hex.DecodeString(field)
+		if err != nil {
+			key, err = base64.StdEncoding.DecodeString(field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d: expected hex or base64", len(keys)+1)
+		}
+		switch len(key) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("key %d: expected 16, 24 or 32 bytes, got %d", len(keys)+1, len(key))
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return NewKeyring(keys...)
+}
+
+// CurrentId returns the id of the key used for new records, empty means
+// records are not encrypted
+func (k *Keyring) CurrentId() string {
+	if k == nil {
+		return ""
+	}
+	return k.current.id
+}
+
+// seal appends to dst the envelope of a record, ending with '\n'
+func (k *Keyring) seal(dst, record []byte) ([]byte, error) {
+
+	record = bytes.TrimRight(record, "\n")
+	aead := k.current.aead
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(record)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, fmt.Errorf("nonce: %w", err)
+	}
+	data := aead.Seal(nonce, nonce, record, nil)
+
+	dst = append(dst, sealedPrefix...)
+	dst = append(dst, k.current.id...)
+	dst = append(dst, `","data":"`...)
+	dst = base64.StdEncoding.AppendEncode(dst, data)
+	dst = append(dst, "\"}\n"...)
+	return dst, nil
+}
+
+func isSealed(line []byte) bool {
+	return bytes.HasPrefix(line, sealedPrefix)
+}
+
+// open decrypts an envelope and returns the key id and the record
+func (k *Keyring) open(line []byte) (string, []byte, error) {
+
+	sealed := sealedRecord{}
+	err := json.Unmarshal(line, &sealed)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if k == nil {
+		return sealed.Key, nil, fmt.Errorf("%w '%s': encryption is not configured", ErrUnknownKey, sealed.Key)
+	}
+	key, ok := k.keys[sealed.Key]
+	if !ok {
+		return sealed.Key, nil, fmt.Errorf("%w '%s'", ErrUnknownKey, sealed.Key)
+	}
+
+	nonceSize := key.aead.NonceSize()
+	if len(sealed.Data) < nonceSize {
+		return sealed.Key, nil, fmt.Errorf("sealed record too short")
+	}
+	record, err := key.aead.Open(nil, sealed.Data[:nonceSize], sealed.Data[nonceSize:], nil)
+	if err != nil {
+		return sealed.Key, nil, fmt.Errorf("decrypt: %w", err)
+	}
+
+	return sealed.Key, record, nil
+}
+
+type KeyRotationStats struct {
+	Key       string        `json:"key"`
+	Segments  int           `json:"segments"`
+	Rewritten int           `json:"rewritten"`
+	Took      time.Duration `json:"took"`
+}
+
+// RotateKey rewrites every segment with records that are not encrypted with
+// the current key of Options.Encryption. Once it finishes, the previous keys
+// can be removed from the keyring.
+//
+// The active segment is sealed first, writes are only blocked while each
+// rewritten segment is swapped in.
+func (c *Collection) RotateKey() (*KeyRotationStats, error) {
+
+	if !c.compacting.CompareAndSwap(false, true) {
+		return nil, ErrCompactionInProgress
+	}
+	defer c.compacting.Store(false)
+
+	t0 := time.Now()
+	keyring := c.Options.Encryption
+
+	c.journalMutex.Lock()
+	if c.file == nil {
+		c.journalMutex.Unlock()
+		return nil, fmt.Errorf("collection is closed")
+	}
+	_, err := c.sealActiveSegment()
+	if err != nil {
+		c.journalMutex.Unlock()
+		return nil, err
+	}
+	segments := append([]*Segment{}, c.manifest.Segments...)
+	c.journalMutex.Unlock()
+
+	stats := &KeyRotationStats{
+		Key:      keyring.CurrentId(),
+		Segments: len(segments),
+	}
+
+	dir := SegmentsDir(c.Filename)
+	for _, segment := range segments {
+		filename := path.Join(dir, segment.File)
+		tmpFilename, size, err := rewriteSegment(filename, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite segment '%s': %w", segment.File, err)
+		}
+		if tmpFilename == "" {
+			continue // already encrypted with the current key
+		}
+
+		c.journalMutex.Lock()
+		err = os.Rename(tmpFilename, filename)
+		if err == nil {
+			segment.Size = size
+			err = writeManifest(dir, c.manifest)
+		}
+		c.journalMutex.Unlock()
+		if err != nil {
+			os.Remove(tmpFilename)
+			return nil, fmt.Errorf("replace segment '%s': %w", segment.File, err)
+		}
+		stats.Rewritten++
+	}
+
+	stats.Took = time.Since(t0)
+
+	return stats, nil
+}
+
+// rewriteSegment writes a copy of a segment with all its records encrypted
+// with the current key (or in plain text if keyring is nil). Records are
+// copied byte by byte, only the envelope changes. Returns an empty filename
+// if the segment does not need to be rewritten.
+func rewriteSegment(filename string, keyring *Keyring) (string, int64, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	tmpFilename := filename + ".tmp"
+	out, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return "", 0, err
+	}
+	defer out.Close()
+
+	w := bufio.NewWriterSize(out, 1024*1024)
+	current := keyring.CurrentId()
+	stale := false
+	sealed := []byte{}
+
+	j := NewJournalReader(f, keyring)
+	command := &Command{}
+	for {
+		err := j.Next(command)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.Remove(tmpFilename)
+			return "", 0, err
+		}
+		if j.KeyId() != current {
+			stale = true
+		}
+		if keyring == nil {
+			w.Write(j.Record())
+			w.WriteByte('\n')
+			continue
+		}
+		sealed, err = keyring.seal(sealed[:0], j.Record())
+		if err != nil {
+			os.Remove(tmpFilename)
+			return "", 0, err
+		}
+		w.Write(sealed)
+	}
+
+	if !stale {
+		os.Remove(tmpFilename)
+		return "", 0, nil
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return "", 0, err
+	}
+
+	info, err := out.Stat()
+	if err != nil {
+		os.Remove(tmpFilename)
+		return "", 0, err
+	}
+
+	return tmpFilename, info.Size(), nil
+}
@@ -0,0 +1,113 @@
+package collection
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+func newTestKeyring(keys ...string) *Keyring {
+	k := [][]byte{}
+	for _, key := range keys {
+		k = append(k, bytes.Repeat([]byte(key), 32))
+	}
+	keyring, _ := NewKeyring(k...)
+	return keyring
+}
+
+func TestEncryption(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		keyring := newTestKeyring("a")
+		c, _ := OpenCollectionWithOptions(filename, &Options{Encryption: keyring})
+
+		// Run
+		c.Insert(map[string]interface{}{"name": "Pablo"})
+		c.Close()
+
+		// Check
+		fileContent, _ := os.ReadFile(filename)
+		AssertFalse(bytes.Contains(fileContent, []byte("Pablo")))
+		AssertTrue(bytes.HasPrefix(fileContent, []byte(`{"key":"`)))
+
+		c, err := OpenCollectionWithOptions(filename, &Options{Encryption: keyring})
+		AssertNil(err)
+		AssertEqual(string(c.Rows[0].Payload), `{"name":"Pablo"}`)
+		c.Close()
+
+		_, err = OpenCollection(filename)
+		AssertTrue(errors.Is(err, ErrUnknownKey))
+	})
+}
+
+func TestEncryption_TornTail(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		keyring := newTestKeyring("a")
+		c, _ := OpenCollectionWithOptions(filename, &Options{Encryption: keyring})
+		c.Insert(map[string]interface{}{"name": "Pablo"})
+		c.Close()
+		fileContent, _ := os.ReadFile(filename)
+		os.WriteFile(filename, append(fileContent, fileContent[:30]...), 0666)
+
+		// Run
+		c, err := OpenCollectionWithOptions(filename, &Options{Encryption: keyring})
+
+		// Check
+		AssertNil(err)
+		AssertEqual(len(c.Rows), 1)
+		c.Close()
+		truncated, _ := os.ReadFile(filename)
+		AssertEqual(string(truncated), string(fileContent))
+	})
+}
+
+func TestEncryption_RotateKey(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup: plain text records, then records encrypted with key 'a'
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"name": "Pablo"})
+		c.Close()
+		c, _ = OpenCollectionWithOptions(filename, &Options{Encryption: newTestKeyring("a")})
+		c.Insert(map[string]interface{}{"name": "Sara"})
+		c.Close()
+
+		// Run
+		keyring := newTestKeyring("b", "a")
+		c, _ = OpenCollectionWithOptions(filename, &Options{Encryption: keyring})
+		stats, err := c.RotateKey()
+		AssertNil(err)
+		c.Insert(map[string]interface{}{"name": "Ana"})
+		c.Close()
+
+		// Check
+		AssertEqual(stats.Key, keyring.CurrentId())
+		AssertEqual(stats.Rewritten, 1)
+
+		c, err = OpenCollectionWithOptions(filename, &Options{Encryption: newTestKeyring("b")})
+		AssertNil(err)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 3)
+		AssertEqual(string(c.Rows[1].Payload), `{"name":"Sara"}`)
+	})
+}
+
+func TestParseKeyring(t *testing.T) {
+
+	keyring, err := ParseKeyring(" 000102030405060708090a0b0c0d0e0f,\n AAECAwQFBgcICQoLDA0ODw== ")
+	AssertNil(err)
+	AssertEqual(len(keyring.keys), 1) // same key in hex and base64
+
+	keyring, err = ParseKeyring("")
+	AssertNil(err)
+	AssertNil(keyring)
+
+	_, err = ParseKeyring("0001")
+	AssertNotNil(err)
+}
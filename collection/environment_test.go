@@ -3,12 +3,20 @@ package collection
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 )
 
 func Environment(f func(filename string)) {
 	filename := fmt.Sprintf("temp-%v", time.Now().UnixNano())
 	defer os.Remove(filename)
+	defer os.RemoveAll(SegmentsDir(filename))
+	defer func() {
+		rewound, _ := filepath.Glob(filename + rewoundInfix + "*")
+		for _, file := range rewound {
+			os.Remove(file)
+		}
+	}()
 
 	f(filename)
 }
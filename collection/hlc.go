@@ -0,0 +1,71 @@
+package collection
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// HLC is a hybrid logical clock: versions follow the wall clock but never go
+// backwards and are always greater than the versions observed from other
+// instances. Versions are strings that sort in the same order.
+type HLC struct {
+	Node    string // tie breaker, must be unique among the instances
+	mutex   *sync.Mutex
+	wall    int64
+	logical int64
+}
+
+func NewHLC(node string) *HLC {
+	return &HLC{
+		Node:  node,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Now returns a new version
+func (h *HLC) Now() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now().UnixNano()
+	if now > h.wall {
+		h.wall = now
+		h.logical = 0
+	} else {
+		h.logical++
+	}
+
+	return h.format()
+}
+
+// Update observes a version of another instance
+func (h *HLC) Update(version string) {
+
+	var wall, logical int64
+	_, err := fmt.Sscanf(version, "%16x-%8x-", &wall, &logical)
+	if err != nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now().UnixNano()
+	switch {
+	case now > h.wall && now > wall:
+		h.wall = now
+		h.logical = 0
+	case h.wall == wall:
+		h.logical = max(h.logical, logical) + 1
+	case h.wall > wall:
+		h.logical++
+	default:
+		h.wall = wall
+		h.logical = logical + 1
+	}
+}
+
+func (h *HLC) format() string {
+	return fmt.Sprintf("%016x-%08x-%s", h.wall, h.logical, h.Node)
+}
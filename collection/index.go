@@ -4,4 +4,5 @@ type Index interface {
 	AddRow(row *Row) error
 	RemoveRow(row *Row) error
 	Traverse(options []byte, f func(row *Row) bool) // todo: return error?
+	Len() int                                       // number of entries
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/google/btree"
 )
@@ -12,6 +13,7 @@ import (
 type IndexBtree struct {
 	Btree   *btree.BTreeG[*RowOrdered]
 	Options *IndexBTreeOptions
+	mutex   sync.Mutex // Traverse works on a clone of Btree
 }
 
 func (b *IndexBtree) RemoveRow(r *Row) error {
@@ -25,10 +27,12 @@ func (b *IndexBtree) RemoveRow(r *Row) error {
 		values = append(values, data[field])
 	}
 
+	b.mutex.Lock()
 	b.Btree.Delete(&RowOrdered{
 		Row:    r, // probably r is not needed
 		Values: values,
 	})
+	b.mutex.Unlock()
 
 	return nil
 }
@@ -120,6 +124,9 @@ func (b *IndexBtree) AddRow(r *Row) error {
 		return fmt.Errorf("field '%s' not defined", field)
 	}
 
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
 	if b.Btree.Has(&RowOrdered{Values: values}) {
 		errKey := ""
 		for i, field := range b.Options.Fields {
@@ -150,6 +157,11 @@ func (b *IndexBtree) Traverse(optionsData []byte, f func(*Row) bool) {
 		return f(r.Row)
 	}
 
+	// The clone is lazy, so f can modify the collection
+	b.mutex.Lock()
+	tree := b.Btree.Clone()
+	b.mutex.Unlock()
+
 	hasFrom := len(options.From) > 0
 	hasTo := len(options.To) > 0
 
@@ -171,28 +183,34 @@ func (b *IndexBtree) Traverse(optionsData []byte, f func(*Row) bool) {
 
 	if !hasFrom && !hasTo {
 		if options.Reverse {
-			b.Btree.Descend(iterator)
+			tree.Descend(iterator)
 		} else {
-			b.Btree.Ascend(iterator)
+			tree.Ascend(iterator)
 		}
 	} else if hasFrom && !hasTo {
 		if options.Reverse {
-			b.Btree.DescendGreaterThan(pivotFrom, iterator)
+			tree.DescendGreaterThan(pivotFrom, iterator)
 		} else {
-			b.Btree.AscendGreaterOrEqual(pivotFrom, iterator)
+			tree.AscendGreaterOrEqual(pivotFrom, iterator)
 		}
 	} else if !hasFrom && hasTo {
 		if options.Reverse {
-			b.Btree.DescendLessOrEqual(pivotTo, iterator)
+			tree.DescendLessOrEqual(pivotTo, iterator)
 		} else {
-			b.Btree.AscendLessThan(pivotTo, iterator)
+			tree.AscendLessThan(pivotTo, iterator)
 		}
 	} else {
 		if options.Reverse {
-			b.Btree.DescendRange(pivotTo, pivotFrom, iterator)
+			tree.DescendRange(pivotTo, pivotFrom, iterator)
 		} else {
-			b.Btree.AscendRange(pivotFrom, pivotTo, iterator)
+			tree.AscendRange(pivotFrom, pivotTo, iterator)
 		}
 	}
 
 }
+
+func (b *IndexBtree) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.Btree.Len()
+}
@@ -134,3 +134,9 @@ type IndexMapOptions struct {
 	Field  string `json:"field"`
 	Sparse bool   `json:"sparse"`
 }
+
+func (i *IndexMap) Len() int {
+	i.RWmutex.RLock()
+	defer i.RWmutex.RUnlock()
+	return len(i.Entries)
+}
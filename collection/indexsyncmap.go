@@ -115,3 +115,12 @@ func (i *IndexSyncMap) Traverse(optionsData []byte, f func(row *Row) bool) {
 
 	f(row.(*Row))
 }
+
+func (i *IndexSyncMap) Len() int { // todo: keep a counter instead of traversing
+	n := 0
+	i.Entries.Range(func(key, value any) bool {
+		n++
+		return true
+	})
+	return n
+}
@@ -0,0 +1,228 @@
+package collection
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+
+	json2 "github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// Journal records are JSON lines. Each record ends with a trailer that holds the
+// CRC-32 (Castagnoli) and the length of the record encoded without trailer:
+//
+//	{"name":"insert",...,"payload":{...},"checksum":123456,"length":98}
+//
+// Records without trailer (written by older versions) are accepted as is, but
+// only until the first record with trailer: after it a missing or unreadable
+// trailer is corruption. Records can also be encrypted, see Keyring.
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var trailerPrefix = []byte(`,"checksum":`)
+
+// ErrTornTail means the last record of the journal is incomplete, usually
+// because the process died in the middle of a write.
+var ErrTornTail = errors.New("torn record at the end of the journal")
+
+var errMissingTrailer = errors.New("missing checksum trailer")
+
+// CorruptionError reports an invalid record followed by more data, which can
+// not be explained by an interrupted write.
+type CorruptionError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupted record at byte %d: %s", e.Offset, e.Err.Error())
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// appendRecordTrailer turns an encoded command (ending with '\n') into a
+// journal record
+func appendRecordTrailer(b []byte) []byte {
+	b = bytes.TrimRight(b, "\n")
+	length := len(b)
+	checksum := crc32.Checksum(b, crcTable)
+
+	b = b[:length-1] // remove closing '}'
+	b = append(b, trailerPrefix...)
+	b = strconv.AppendUint(b, uint64(checksum), 10)
+	b = append(b, `,"length":`...)
+	b = strconv.AppendInt(b, int64(length), 10)
+	b = append(b, "}\n"...)
+	return b
+}
+
+// verifyRecord checks the trailer of a record (without '\n'), found is false
+// if the record has no trailer
+func verifyRecord(line []byte) (found bool, err error) {
+
+	i := bytes.LastIndex(line, trailerPrefix)
+	if i < 0 {
+		return false, nil // legacy record
+	}
+
+	trailer := line[i+len(trailerPrefix):]
+	j := bytes.Index(trailer, []byte(`,"length":`))
+	if j < 0 || len(trailer) == 0 || trailer[len(trailer)-1] != '}' {
+		return false, nil // not a trailer, the text belongs to the payload
+	}
+	checksum, err := strconv.ParseUint(string(trailer[:j]), 10, 32)
+	if err != nil {
+		return false, nil
+	}
+	length, err := strconv.Atoi(string(trailer[j+len(`,"length":`) : len(trailer)-1]))
+	if err != nil {
+		return false, nil
+	}
+
+	record := make([]byte, 0, i+1)
+	record = append(record, line[:i]...)
+	record = append(record, '}')
+
+	if len(record) != length {
+		return true, fmt.Errorf("length mismatch: expected %d, got %d", length, len(record))
+	}
+	if crc32.Checksum(record, crcTable) != uint32(checksum) {
+		return true, fmt.Errorf("checksum mismatch")
+	}
+
+	return true, nil
+}
+
+// JournalReader reads commands from a journal, checking their integrity
+type JournalReader struct {
+	r              *bufio.Reader
+	keyring        *Keyring
+	line           []byte
+	record         []byte
+	keyId          string
+	offset         int64 // bytes of valid records read so far
+	missingNewline bool
+	checksummed    bool // a record with trailer has been read, the next ones need it too
+}
+
+// NewJournalReader reads a journal, keyring is only needed if it has encrypted
+// records
+func NewJournalReader(r io.Reader, keyring *Keyring) *JournalReader {
+	return &JournalReader{
+		r:       bufio.NewReaderSize(r, 1024*1024),
+		keyring: keyring,
+	}
+}
+
+// Offset returns the position just after the last valid record. After
+// ErrTornTail it is the size the journal should be truncated to.
+func (j *JournalReader) Offset() int64 {
+	return j.offset
+}
+
+// Record returns the last record read (decrypted and without newline), it is
+// only valid until the next call to Next
+func (j *JournalReader) Record() []byte {
+	return j.record
+}
+
+// KeyId returns the key the last record was encrypted with, empty if it was
+// not encrypted
+func (j *JournalReader) KeyId() string {
+	return j.keyId
+}
+
+// MissingNewline reports whether the last record read was not terminated by a
+// newline, so it must be added before appending new records.
+func (j *JournalReader) MissingNewline() bool {
+	return j.missingNewline
+}
+
+// Next decodes the next command. It returns io.EOF at the end of the journal,
+// ErrTornTail if the last record is incomplete or a *CorruptionError.
+func (j *JournalReader) Next(command *Command) error {
+
+	for {
+		line, err := j.readLine()
+		if err == io.EOF && len(line) == 0 {
+			return io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		complete := err == nil
+		size := int64(len(line))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			j.offset += size
+			if !complete {
+				return io.EOF
+			}
+			continue
+		}
+
+		var decodeErr error
+		j.record = line
+		j.keyId = ""
+		if isSealed(line) {
+			j.keyId, j.record, decodeErr = j.keyring.open(line)
+			if errors.Is(decodeErr, ErrUnknownKey) {
+				return decodeErr
+			}
+		}
+		checksummed := false
+		if decodeErr == nil {
+			checksummed, decodeErr = verifyRecord(j.record)
+		}
+		if decodeErr == nil && !checksummed && j.checksummed {
+			decodeErr = errMissingTrailer
+		}
+		if decodeErr == nil {
+			*command = Command{}
+			decodeErr = json2.Unmarshal(j.record, command,
+				jsontext.AllowDuplicateNames(true),
+				jsontext.AllowInvalidUTF8(true),
+			)
+		}
+
+		if decodeErr != nil {
+			if !complete || j.atEOF() {
+				return ErrTornTail
+			}
+			return &CorruptionError{
+				Offset: j.offset,
+				Err:    decodeErr,
+			}
+		}
+
+		j.offset += size
+		j.missingNewline = !complete
+		j.checksummed = j.checksummed || checksummed
+		return nil
+	}
+}
+
+func (j *JournalReader) readLine() ([]byte, error) {
+	j.line = j.line[:0]
+	for {
+		chunk, err := j.r.ReadSlice('\n')
+		j.line = append(j.line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return j.line, err
+	}
+}
+
+func (j *JournalReader) atEOF() bool {
+	_, err := j.r.Peek(1)
+	return err == io.EOF
+}
@@ -0,0 +1,178 @@
+package collection
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestJournal_RecordTrailer(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"hello": "world"})
+		c.Close()
+
+		// Check
+		fileContent, _ := os.ReadFile(filename)
+		line := strings.Split(strings.TrimSpace(string(fileContent)), "\n")[1]
+		AssertTrue(strings.Contains(line, `,"checksum":`))
+		found, err := verifyRecord([]byte(line))
+		AssertTrue(found)
+		AssertNil(err)
+
+		tampered := strings.Replace(line, "world", "w0rld", 1)
+		_, err = verifyRecord([]byte(tampered))
+		AssertNotNil(err)
+	})
+}
+
+func TestJournal_TornTail(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Close()
+
+		fileContent, _ := os.ReadFile(filename)
+		validSize := len(fileContent)
+		torn := append(fileContent, []byte(`{"name":"insert","uuid":"x","timestamp":1,"start_byte":0,"payload":{"id":"3"`)...)
+		os.WriteFile(filename, torn, 0666)
+
+		// Run
+		c, err := OpenCollection(filename)
+		AssertNil(err)
+		c.Insert(map[string]interface{}{"id": "4"})
+		c.Close()
+
+		// Check
+		c, err = OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 3)
+		AssertEqual(string(c.Rows[2].Payload), `{"id":"4"}`)
+
+		fileContent, _ = os.ReadFile(filename)
+		AssertTrue(len(fileContent) > validSize)
+	})
+}
+
+func TestJournal_TornTail_BadChecksum(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Close()
+
+		fileContent, _ := os.ReadFile(filename)
+		fileContent = []byte(strings.Replace(string(fileContent), `{"id":"2"}`, `{"id":"X"}`, 1))
+		os.WriteFile(filename, fileContent, 0666)
+
+		// Run
+		c, err := OpenCollection(filename)
+
+		// Check
+		AssertNil(err)
+		AssertEqual(len(c.Rows), 1)
+		c.Close()
+	})
+}
+
+func TestJournal_Corruption(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Insert(map[string]interface{}{"id": "3"})
+		c.Close()
+
+		fileContent, _ := os.ReadFile(filename)
+		offset := strings.Index(string(fileContent), `{"id":"2"}`)
+		offset = strings.LastIndex(string(fileContent[:offset]), "\n") + 1
+		fileContent = []byte(strings.Replace(string(fileContent), `{"id":"2"}`, `{"id":"X"}`, 1))
+		os.WriteFile(filename, fileContent, 0666)
+
+		// Run
+		_, err := OpenCollection(filename)
+
+		// Check
+		corruption := &CorruptionError{}
+		AssertTrue(errors.As(err, &corruption))
+		AssertEqual(corruption.Offset, int64(offset))
+	})
+}
+
+func TestJournal_DamagedTrailer(t *testing.T) {
+
+	damages := map[string]func(line string) string{
+		"missing trailer": func(line string) string {
+			return line[:strings.Index(line, `,"checksum":`)] + "}"
+		},
+		"missing length": func(line string) string {
+			return line[:strings.Index(line, `,"length":`)] + "}"
+		},
+		"bad checksum": func(line string) string {
+			return strings.Replace(line, `,"checksum":`, `,"checksum":x`, 1)
+		},
+		"bad length": func(line string) string {
+			return strings.Replace(line, `,"length":`, `,"length":x`, 1)
+		},
+	}
+
+	for name, damage := range damages {
+		t.Run(name, func(t *testing.T) {
+			Environment(func(filename string) {
+
+				// Setup
+				c, _ := OpenCollection(filename)
+				c.Insert(map[string]interface{}{"id": "1"})
+				c.Insert(map[string]interface{}{"id": "2"})
+				c.Insert(map[string]interface{}{"id": "3"})
+				c.Close()
+
+				fileContent, _ := os.ReadFile(filename)
+				lines := strings.Split(string(fileContent), "\n")
+				lines[2] = damage(lines[2])
+				os.WriteFile(filename, []byte(strings.Join(lines, "\n")), 0666)
+
+				// Run
+				_, err := OpenCollection(filename)
+
+				// Check
+				corruption := &CorruptionError{}
+				AssertTrue(errors.As(err, &corruption))
+			})
+		})
+	}
+}
+
+func TestJournal_LegacyRecords(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		os.WriteFile(filename, []byte(`{"name":"insert","uuid":"a","timestamp":1,"start_byte":0,"payload":{"id":"1"}}
+{"name":"insert","uuid":"b","timestamp":2,"start_byte":0,"payload":{"id":"2"}}`), 0666)
+
+		// Run
+		c, err := OpenCollection(filename)
+		AssertNil(err)
+		c.Insert(map[string]interface{}{"id": "3"})
+		c.Close()
+
+		// Check
+		c, err = OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 3)
+	})
+}
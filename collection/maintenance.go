@@ -0,0 +1,190 @@
+package collection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+)
+
+// JournalError locates an error reading or replaying a journal
+type JournalError struct {
+	File   string
+	Offset int64
+	Err    error
+}
+
+func (e *JournalError) Error() string {
+	return fmt.Sprintf("'%s' at byte %d: %s", e.File, e.Offset, e.Err.Error())
+}
+
+func (e *JournalError) Unwrap() error {
+	return e.Err
+}
+
+// JournalFiles returns the files of a collection journal in replay order, the
+// active segment is the last one
+func JournalFiles(filename string) ([]string, error) {
+
+	dir := SegmentsDir(filename)
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+
+	files := []string{}
+	for _, segment := range manifest.Segments {
+		files = append(files, path.Join(dir, segment.File))
+	}
+	files = append(files, filename)
+
+	return files, nil
+}
+
+// ReadJournal calls f with every command of a collection journal, in replay
+// order, without modifying any file. Invalid records (including torn tails)
+// are returned as *JournalError.
+func ReadJournal(filename string, keyring *Keyring, f func(file string, offset int64, command *Command) error) error {
+
+	files, err := JournalFiles(filename)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		err := readJournalFile(file, keyring, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readJournalFile(filename string, keyring *Keyring, f func(file string, offset int64, command *Command) error) error {
+
+	fd, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	j := NewJournalReader(fd, keyring)
+	command := &Command{}
+	for {
+		offset := j.Offset()
+		err := j.Next(command)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &JournalError{
+				File:   filename,
+				Offset: j.Offset(),
+				Err:    err,
+			}
+		}
+
+		err = f(filename, offset, command)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReplayCollection rebuilds a collection in memory without modifying any file,
+// it can be read but not written. If the journal can not be replayed
+// completely, the collection is returned along with the error.
+func ReplayCollection(filename string, options *Options) (*Collection, error) {
+
+	if options == nil {
+		options = DefaultOptions()
+	}
+
+	c := newCollection(filename, options)
+
+	manifest, err := readManifest(SegmentsDir(filename))
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	c.manifest = manifest
+
+	err = ReadJournal(filename, options.Encryption, func(file string, offset int64, command *Command) error {
+		c.commands++
+		err := c.applyCommand(command)
+		if err != nil {
+			return &JournalError{
+				File:   file,
+				Offset: offset,
+				Err:    err,
+			}
+		}
+		return nil
+	})
+
+	return c, err
+}
+
+type RepairStats struct {
+	File           string `json:"file,omitempty"`
+	Offset         int64  `json:"offset"`
+	Reason         string `json:"reason,omitempty"`
+	DiscardedBytes int64  `json:"discarded_bytes"`
+}
+
+// Repair cuts a closed collection journal at the first invalid record,
+// discarding it and everything after it. A valid journal is not modified.
+func Repair(filename string, keyring *Keyring) (*RepairStats, error) {
+
+	dir := SegmentsDir(filename)
+	manifest, err := loadManifest(dir, keyring)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+
+	commands := map[string]int64{}
+	err = ReadJournal(filename, keyring, func(file string, offset int64, command *Command) error {
+		commands[file]++
+		return nil
+	})
+	if err == nil {
+		return &RepairStats{}, nil
+	}
+
+	journalErr := &JournalError{}
+	corruption := &CorruptionError{}
+	if !errors.As(err, &journalErr) || !(errors.Is(err, ErrTornTail) || errors.As(err, &corruption)) {
+		return nil, err // not caused by an invalid record
+	}
+
+	files, err := JournalFiles(filename)
+	if err != nil {
+		return nil, err
+	}
+	cutFile := slices.Index(files, journalErr.File)
+
+	stats := &RepairStats{
+		File:   journalErr.File,
+		Offset: journalErr.Offset,
+		Reason: journalErr.Err.Error(),
+	}
+	for i, file := range files[cutFile:] {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		stats.DiscardedBytes += info.Size()
+		if i == 0 {
+			stats.DiscardedBytes -= journalErr.Offset
+		}
+	}
+
+	err = cutJournal(filename, manifest, cutFile, journalErr.Offset, commands[journalErr.File])
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
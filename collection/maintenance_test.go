@@ -0,0 +1,77 @@
+package collection
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestReplayCollection(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Close()
+		f, _ := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0666)
+		f.WriteString(`{"name":"insert","uu`)
+		f.Close()
+		before, _ := os.ReadFile(filename)
+
+		// Run
+		c, err := ReplayCollection(filename, nil)
+
+		// Check
+		AssertTrue(errors.Is(err, ErrTornTail))
+		AssertEqual(len(c.Rows), 2)
+		after, _ := os.ReadFile(filename)
+		AssertEqual(string(after), string(before))
+		_, err = c.Insert(map[string]interface{}{"id": "3"})
+		AssertNotNil(err)
+	})
+}
+
+func TestRepair(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup: corrupt the first sealed segment
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "3"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "4"})
+		c.Close()
+		segment := path.Join(SegmentsDir(filename), c.Segments()[0].File)
+		data, _ := os.ReadFile(segment)
+		corrupted := []byte(string(data))
+		corrupted[len(corrupted)-20] = 'X'
+		os.WriteFile(segment, corrupted, 0666)
+		_, err := OpenCollection(filename)
+		AssertNotNil(err)
+
+		// Run
+		stats, err := Repair(filename, nil)
+
+		// Check
+		AssertNil(err)
+		AssertEqual(stats.File, segment)
+		AssertTrue(stats.DiscardedBytes > 0)
+
+		c, err = OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 1)
+		AssertEqual(len(c.Segments()), 1)
+
+		stats, err = Repair(filename, nil)
+		AssertNil(err)
+		AssertEqual(stats.File, "")
+	})
+}
@@ -0,0 +1,263 @@
+package collection
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// RecoveryTarget is the point a journal is rewound to. The journal is cut at
+// the first command that reaches the target, so what is kept is always a
+// prefix of the journal, even if timestamps are not strictly monotonic.
+type RecoveryTarget struct {
+	// Timestamp keeps the commands up to this time (unix nanoseconds), zero
+	// means no limit
+	Timestamp int64
+	// Uuid discards the command with this uuid and everything after it
+	Uuid string
+	// DryRun only counts the commands that would be kept and discarded
+	DryRun bool
+}
+
+func (t *RecoveryTarget) reached(command *Command) bool {
+	if t.Uuid != "" && command.Uuid == t.Uuid {
+		return true
+	}
+	if t.Timestamp != 0 && command.Timestamp > t.Timestamp {
+		return true
+	}
+	return false
+}
+
+var ErrRecoveryTargetNotFound = errors.New("recovery target not found in the journal")
+
+type RewindStats struct {
+	Kept      int64  `json:"kept"`
+	Discarded int64  `json:"discarded"`
+	Rewound   string `json:"rewound,omitempty"` // file with the discarded commands
+}
+
+// rewoundInfix names the files with the commands discarded by Rewind:
+// <collection>.rewound-<unix nanoseconds>
+const rewoundInfix = ".rewound-"
+
+// IsRewoundFile tells if filename keeps the commands discarded by Rewind
+func IsRewoundFile(filename string) bool {
+	return strings.Contains(path.Base(filename), rewoundInfix)
+}
+
+// Rewind discards the commands of a closed collection journal from the
+// recovery target on. The discarded commands are copied first, in journal
+// format, to a file next to the journal (see RewindStats.Rewound). Commands
+// that were compacted into a snapshot can not be rewound.
+func Rewind(filename string, target *RecoveryTarget, keyring *Keyring) (*RewindStats, error) {
+
+	dir := SegmentsDir(filename)
+	manifest, err := loadManifest(dir, keyring)
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %w", err)
+	}
+
+	files := []string{}
+	for _, segment := range manifest.Segments {
+		files = append(files, path.Join(dir, segment.File))
+	}
+	files = append(files, filename)
+
+	// Find the cut point
+	stats := &RewindStats{}
+	cutFile := -1
+	var cutOffset int64
+	var cutCommands int64
+	for i, file := range files {
+		offset, commands, reached, total, err := scanRecoveryTarget(file, target, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("read '%s': %w", file, err)
+		}
+		if cutFile >= 0 {
+			stats.Discarded += total
+			continue
+		}
+		stats.Kept += commands
+		if !reached {
+			continue
+		}
+		if i < len(manifest.Segments) && manifest.Segments[i].Snapshot && commands == 0 {
+			at := time.Unix(0, manifest.Segments[i].FirstTimestamp).UTC()
+			return nil, fmt.Errorf("the journal was compacted at %s, it can not be rewound before that", at.Format(time.RFC3339Nano))
+		}
+		cutFile = i
+		cutOffset = offset
+		cutCommands = commands
+		stats.Discarded += total - commands
+	}
+
+	if cutFile < 0 {
+		if target.Uuid != "" {
+			return nil, ErrRecoveryTargetNotFound
+		}
+		return stats, nil // nothing to discard
+	}
+	if target.DryRun {
+		return stats, nil
+	}
+
+	stats.Rewound, err = saveRewound(filename, files[cutFile:], cutOffset)
+	if err != nil {
+		return nil, fmt.Errorf("save discarded commands: %w", err)
+	}
+
+	err = cutJournal(filename, manifest, cutFile, cutOffset, cutCommands)
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// saveRewound copies the journal files from offset of the first one on
+func saveRewound(filename string, files []string, offset int64) (string, error) {
+
+	rewound := fmt.Sprintf("%s%s%d", filename, rewoundInfix, time.Now().UnixNano())
+	f, err := os.OpenFile(rewound, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for i, file := range files {
+		if i > 0 {
+			offset = 0
+		}
+		err := appendTail(f, file, offset)
+		if err != nil {
+			os.Remove(rewound)
+			return "", fmt.Errorf("copy '%s': %w", file, err)
+		}
+	}
+
+	err = f.Sync()
+	if err != nil {
+		os.Remove(rewound)
+		return "", err
+	}
+	syncDir(path.Dir(rewound))
+
+	return rewound, nil
+}
+
+// appendTail copies file from offset on, ending with a new line
+func appendTail(w io.Writer, file string, offset int64) error {
+
+	src, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() <= offset {
+		return nil
+	}
+
+	_, err = io.Copy(w, io.NewSectionReader(src, offset, info.Size()-offset))
+	if err != nil {
+		return err
+	}
+
+	last := make([]byte, 1)
+	_, err = src.ReadAt(last, info.Size()-1)
+	if err != nil {
+		return err
+	}
+	if last[0] != '\n' {
+		_, err = w.Write([]byte("\n")) // torn record
+	}
+	return err
+}
+
+// cutJournal discards everything from offset of the file number cutFile (in
+// replay order, the active segment is the last one) on
+func cutJournal(filename string, manifest *Manifest, cutFile int, offset, commands int64) error {
+
+	dir := SegmentsDir(filename)
+	if cutFile < len(manifest.Segments) {
+		obsolete := manifest.Segments[cutFile+1:]
+		manifest.Segments = manifest.Segments[:cutFile+1]
+		segment := manifest.Segments[cutFile]
+		if commands == 0 {
+			obsolete = append(obsolete, segment)
+			manifest.Segments = manifest.Segments[:cutFile]
+		} else {
+			segment.Size = offset
+			segment.Commands = commands
+			err := os.Truncate(path.Join(dir, segment.File), offset)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := writeManifest(dir, manifest)
+		if err != nil {
+			return err
+		}
+		for _, segment := range obsolete {
+			os.Remove(path.Join(dir, segment.File))
+		}
+		offset = 0
+	}
+
+	return os.Truncate(filename, offset)
+}
+
+// scanRecoveryTarget looks for the target in a journal file. It returns the
+// offset and number of commands before it, whether it was found and the total
+// number of commands in the file.
+func scanRecoveryTarget(filename string, target *RecoveryTarget, keyring *Keyring) (offset, commands int64, reached bool, total int64, err error) {
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return 0, 0, false, 0, nil
+	}
+	if err != nil {
+		return 0, 0, false, 0, err
+	}
+	defer f.Close()
+
+	j := NewJournalReader(f, keyring)
+	command := &Command{}
+	for {
+		previous := j.Offset()
+		err := j.Next(command)
+		if err == io.EOF || err == ErrTornTail {
+			break
+		}
+		if err != nil {
+			return 0, 0, false, 0, err
+		}
+		total++
+		if !reached && target.reached(command) {
+			reached = true
+			offset = previous
+		}
+		if !reached {
+			commands++
+		}
+	}
+
+	if !reached {
+		offset = j.Offset()
+	}
+
+	return offset, commands, reached, total, nil
+}
@@ -0,0 +1,150 @@
+package collection
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/fulldump/biff"
+)
+
+func readCommands(filename string) []*Command {
+	f, _ := os.Open(filename)
+	defer f.Close()
+
+	commands := []*Command{}
+	j := NewJournalReader(f, nil)
+	for {
+		command := &Command{}
+		if j.Next(command) == io.EOF {
+			return commands
+		}
+		commands = append(commands, command)
+	}
+}
+
+func TestRewind_Uuid(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		row, _ := c.Insert(map[string]interface{}{"id": "1", "name": "Pablo"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "2", "name": "Sara"})
+		c.Patch(row, map[string]interface{}{"name": "Wrong"}) // bad patch
+		c.Insert(map[string]interface{}{"id": "3", "name": "Ana"})
+		c.Close()
+		badPatch := readCommands(filename)[1]
+		AssertEqual(badPatch.Name, "patch")
+
+		// Run
+		stats, err := Rewind(filename, &RecoveryTarget{Uuid: badPatch.Uuid}, nil)
+
+		// Check
+		AssertNil(err)
+		AssertEqual(stats.Kept, int64(3))
+		AssertEqual(stats.Discarded, int64(2))
+		AssertTrue(IsRewoundFile(stats.Rewound))
+		rewound := readCommands(stats.Rewound)
+		AssertEqual(len(rewound), 2)
+		AssertEqual(rewound[0].Uuid, badPatch.Uuid)
+		AssertEqual(string(rewound[1].Payload), `{"id":"3","name":"Ana"}`)
+
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 2)
+		AssertEqual(string(c.Rows[0].Payload), `{"id":"1","name":"Pablo"}`)
+	})
+}
+
+func TestRewind_DryRun(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		until := time.Now().UnixNano()
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Close()
+		before, _ := os.ReadFile(filename)
+
+		// Run
+		stats, err := Rewind(filename, &RecoveryTarget{Timestamp: until, DryRun: true}, nil)
+
+		// Check
+		AssertNil(err)
+		AssertEqual(stats.Kept, int64(2))
+		AssertEqual(stats.Discarded, int64(1))
+		AssertEqual(stats.Rewound, "")
+		after, _ := os.ReadFile(filename)
+		AssertEqual(string(after), string(before))
+	})
+}
+
+func TestRewind_Timestamp(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "2"})
+		until := time.Now().UnixNano()
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "3"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "4"})
+		c.Close()
+
+		// Run
+		_, err := Rewind(filename, &RecoveryTarget{Timestamp: until}, nil)
+
+		// Check
+		AssertNil(err)
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 2)
+		AssertEqual(len(c.Segments()), 2)
+		entries, _ := os.ReadDir(SegmentsDir(filename))
+		AssertEqual(len(entries), 3) // 2 segments and manifest
+	})
+}
+
+func TestRewind_BeforeCompaction(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		until := time.Now().UnixNano()
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Compact()
+		c.Close()
+
+		// Run
+		_, err := Rewind(filename, &RecoveryTarget{Timestamp: until}, nil)
+
+		// Check
+		AssertNotNil(err)
+		c, _ = OpenCollection(filename)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 2)
+	})
+}
+
+func TestRewind_NotFound(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Close()
+
+		// Run
+		_, err := Rewind(filename, &RecoveryTarget{Uuid: "not-found"}, nil)
+
+		// Check
+		AssertEqual(err, ErrRecoveryTargetNotFound)
+	})
+}
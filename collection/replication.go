@@ -0,0 +1,187 @@
+package collection
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JournalHead describes the last command of a journal
+type JournalHead struct {
+	Position  int64  `json:"position"`
+	Uuid      string `json:"uuid"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (c *Collection) trackLast(command *Command) {
+	c.lastUuid = command.Uuid
+	c.lastTimestamp = command.Timestamp
+}
+
+// Head returns the last command replayed or written. After a compaction the
+// uuid may not be in the journal anymore.
+func (c *Collection) Head() *JournalHead {
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+	c.encoderMutex.Lock()
+	defer c.encoderMutex.Unlock()
+
+	return &JournalHead{
+		Position:  c.commands,
+		Uuid:      c.lastUuid,
+		Timestamp: c.lastTimestamp,
+	}
+}
+
+// ApplyCommand replays a command written by another instance, or proposed to
+// Options.Replicate (see packages replication and cluster), and appends it to
+// the journal as is
+func (c *Collection) ApplyCommand(command *Command) error {
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+
+	if c.file == nil {
+		return fmt.Errorf("collection is closed")
+	}
+
+	record := *command
+	record.Checksum = 0
+	record.Length = 0
+
+	err := c.applyCommand(&record)
+	if err != nil {
+		return err
+	}
+	if record.Name == "set_durability" {
+		c.startFlusher()
+	}
+
+	return c.EncodeCommand(&record)
+}
+
+// propose hands a command to Options.Replicate, it is applied once the
+// replicas commit it
+func (c *Collection) propose(command *Command) error {
+
+	wait, err := c.Options.Replicate(command)
+	if err != nil {
+		return fmt.Errorf("replicate: %w", err)
+	}
+	if wait == nil {
+		return nil
+	}
+
+	return wait()
+}
+
+// proposeInsert reserves the row id, so the row can be returned once applied.
+// The reserved rows count for Options.MaxDocuments.
+func (c *Collection) proposeInsert(item map[string]any) (*Row, error) {
+
+	payload, version, err := c.newDocument(item)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := c.maxDocuments.Load()
+	c.rowsMutex.Lock()
+	if limit > 0 && int64(len(c.Rows))+c.proposedRows >= limit {
+		c.rowsMutex.Unlock()
+		return nil, fmt.Errorf("%w: %d", ErrDocumentLimit, limit)
+	}
+	c.proposedRows++
+	c.lastRowId++
+	id := c.lastRowId
+	c.rowsMutex.Unlock()
+
+	defer func() {
+		c.rowsMutex.Lock()
+		c.proposedRows--
+		c.rowsMutex.Unlock()
+	}()
+
+	key := ""
+	if c.Options.Clock != nil {
+		key = DocumentKey(payload)
+	}
+	err = c.propose(&Command{
+		Name:      "insert",
+		Uuid:      uuid.New().String(),
+		Timestamp: time.Now().UnixNano(),
+		RowId:     id,
+		Payload:   payload,
+		Key:       key,
+		Version:   version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	row := c.GetRowById(id)
+	if row == nil {
+		row = &Row{Id: id, Payload: payload} // already removed
+	}
+	return row, nil
+}
+
+func (c *Collection) proposeRemove(row *Row) error {
+
+	if c.GetRowById(row.Id) != row {
+		return fmt.Errorf("row %d does not exist", row.Id)
+	}
+
+	return c.propose(newRemoveCommand(row, ""))
+}
+
+func (c *Collection) proposePatch(row *Row, patch interface{}) error {
+
+	original, patched, changed, err := patchValue(row.GetPayload(), patch)
+	if err != nil || !changed {
+		return err
+	}
+	diff, changed := createMergeDiff(original, patched)
+	if !changed {
+		return nil
+	}
+
+	command, err := newPatchCommand(row, diff)
+	if err != nil {
+		return err
+	}
+
+	return c.propose(command)
+}
+
+// proposeIndex checks the index can be created before proposing it, applying
+// the command only logs the errors (see applyCommand)
+func (c *Collection) proposeIndex(name string, options interface{}) error {
+
+	index, err := newCollectionIndex(options)
+	if err != nil {
+		return err
+	}
+
+	err = lockBlock(c.rowsMutex.RLocker(), func() error {
+		if _, exists := c.Indexes[name]; exists {
+			return fmt.Errorf("index '%s' already exists", name)
+		}
+		for _, row := range c.Rows {
+			err := index.AddRow(row) // the replicas build their own
+			if err != nil {
+				return fmt.Errorf("index row: %s, data: %s", err.Error(), string(row.Payload))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	command, err := newIndexCommand(name, index)
+	if err != nil {
+		return err
+	}
+
+	return c.propose(command)
+}
@@ -0,0 +1,96 @@
+package collection
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestApplyCommand(t *testing.T) {
+	Environment(func(leaderFilename string) {
+		Environment(func(filename string) {
+
+			// Setup
+			leader, _ := OpenCollection(leaderFilename)
+			defer leader.Close()
+			row, _ := leader.Insert(map[string]interface{}{"id": "1"})
+			leader.Patch(row, map[string]interface{}{"name": "one"})
+			leader.Index("by-id", &IndexMapOptions{Field: "id"})
+			w, _ := leader.Watch(&WatchOptions{Replay: true})
+			defer w.Close()
+
+			follower, _ := OpenCollection(filename)
+
+			// Run
+			for i := int64(0); i < leader.Head().Position; i++ {
+				change, _ := w.Next(context.Background())
+				err := follower.ApplyCommand(change.Command)
+				AssertNil(err)
+			}
+			follower.Close()
+
+			// Check
+			follower, _ = OpenCollection(filename)
+			defer follower.Close()
+			AssertEqual(len(follower.Rows), 1)
+			AssertEqual(string(follower.Rows[0].Payload), `{"id":"1","name":"one"}`)
+			AssertNotNil(follower.Indexes["by-id"])
+			AssertEqual(follower.Head().Uuid, leader.Head().Uuid)
+		})
+	})
+}
+
+func TestApplyCommand_ConcurrentReaders(t *testing.T) {
+	Environment(func(leaderFilename string) {
+		Environment(func(filename string) {
+
+			// Setup
+			leader, _ := OpenCollection(leaderFilename)
+			defer leader.Close()
+			leader.SetDefaults(map[string]any{"id": "uuid()"})
+			leader.Index("by-n", &IndexBTreeOptions{Fields: []string{"n"}})
+			for i := 0; i < 200; i++ {
+				row, _ := leader.Insert(map[string]any{"n": float64(i)})
+				leader.Patch(row, map[string]any{"name": "x"})
+			}
+			leader.Index("by-id", &IndexMapOptions{Field: "id"})
+			w, _ := leader.Watch(&WatchOptions{Replay: true})
+			defer w.Close()
+
+			follower, _ := OpenCollection(filename)
+			defer follower.Close()
+
+			// Run
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := int64(0); i < leader.Head().Position; i++ {
+					change, _ := w.Next(context.Background())
+					follower.ApplyCommand(change.Command)
+				}
+			}()
+			reads := 0
+			for running := true; running; reads++ {
+				select {
+				case <-done:
+					running = false
+				default:
+				}
+				follower.Traverse(func(data []byte) {})
+				follower.GetDefaults()
+				for _, index := range follower.GetIndexes() {
+					index.Traverse([]byte(`{}`), func(row *Row) bool {
+						row.GetPayload()
+						return true
+					})
+				}
+			}
+
+			// Check
+			AssertEqual(follower.Len(), 200)
+			AssertEqual(len(follower.GetIndexes()), 2)
+			AssertTrue(reads > 0)
+		})
+	})
+}
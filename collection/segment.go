@@ -0,0 +1,416 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The journal of a collection is split into segments:
+//
+//	<collection>                          active segment, the only one that is written
+//	<collection>.segments/manifest.json   list of sealed segments, in replay order
+//	<collection>.segments/000001.jsonl    sealed segments, immutable
+//	<collection>.segments/000007.snapshot.jsonl
+//
+// When the active segment grows beyond SegmentOptions.MaxSize it is sealed:
+// renamed into the segments directory and registered in the manifest. A
+// snapshot segment holds the state after applying all segments with a lower
+// or equal id, which are deleted once the snapshot is in the manifest.
+
+const segmentsDirSuffix = ".segments"
+
+const manifestFilename = "manifest.json"
+
+const ManifestVersion = 1
+
+var segmentFilenameRegexp = regexp.MustCompile(`^(\d+)(\.snapshot)?\.jsonl$`)
+
+func SegmentsDir(filename string) string {
+	return filename + segmentsDirSuffix
+}
+
+func IsSegmentsDir(filename string) bool {
+	return strings.HasSuffix(filename, segmentsDirSuffix)
+}
+
+type SegmentOptions struct {
+	// MaxSize in bytes of the active segment before it is sealed, zero
+	// disables rotation
+	MaxSize int64
+}
+
+type Manifest struct {
+	Version  int        `json:"version"`
+	LastId   int64      `json:"last_id"`
+	Segments []*Segment `json:"segments"`
+}
+
+type Segment struct {
+	Id             int64  `json:"id"`
+	File           string `json:"file"`
+	Snapshot       bool   `json:"snapshot,omitempty"`
+	Size           int64  `json:"size"`
+	Commands       int64  `json:"commands"`
+	FirstTimestamp int64  `json:"first_timestamp"`
+	LastTimestamp  int64  `json:"last_timestamp"`
+	SealedAt       int64  `json:"sealed_at"`
+}
+
+// activeSegment tracks the commands of the active segment to describe it
+// once it is sealed
+type activeSegment struct {
+	size           int64
+	commands       int64
+	firstTimestamp int64
+	lastTimestamp  int64
+}
+
+func (a *activeSegment) track(command *Command) {
+	a.commands++
+	if a.firstTimestamp == 0 {
+		a.firstTimestamp = command.Timestamp
+	}
+	a.lastTimestamp = command.Timestamp
+}
+
+// loadManifest reads the manifest of a segments directory and repairs the
+// effects of an interrupted rotation or compaction:
+//   - sealed segments not registered yet are adopted
+//   - segments already covered by a snapshot and temporary files are removed
+func loadManifest(dir string, keyring *Keyring) (*Manifest, error) {
+
+	manifest, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	changed := false
+	registered := map[string]bool{}
+	var base int64 // segments up to this id are included in a snapshot
+	for _, segment := range manifest.Segments {
+		registered[segment.File] = true
+		if segment.Snapshot {
+			base = segment.Id
+		}
+		info, err := os.Stat(path.Join(dir, segment.File))
+		if err != nil {
+			return nil, fmt.Errorf("segment '%s': %w", segment.File, err)
+		}
+		if info.Size() != segment.Size {
+			segment.Size = info.Size() // rewritten by a key rotation
+			changed = true
+		}
+	}
+
+	orphans := []*Segment{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == manifestFilename || registered[name] || entry.IsDir() {
+			continue
+		}
+
+		filename := path.Join(dir, name)
+		match := segmentFilenameRegexp.FindStringSubmatch(name)
+		if match == nil {
+			if strings.HasSuffix(name, ".tmp") {
+				os.Remove(filename)
+			}
+			continue
+		}
+
+		id, _ := strconv.ParseInt(match[1], 10, 64)
+		snapshot := match[2] != ""
+		if snapshot || id <= base || id <= manifest.LastId {
+			slog.Warn("removing obsolete segment", "file", filename)
+			os.Remove(filename)
+			continue
+		}
+
+		segment, err := scanSegment(filename, keyring)
+		if err != nil {
+			return nil, fmt.Errorf("adopt segment '%s': %w", name, err)
+		}
+		segment.Id = id
+		segment.File = name
+		orphans = append(orphans, segment)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Id < orphans[j].Id
+	})
+	for _, segment := range orphans {
+		slog.Warn("adopting sealed segment", "file", segment.File)
+		manifest.Segments = append(manifest.Segments, segment)
+		manifest.LastId = segment.Id
+		changed = true
+	}
+
+	if changed {
+		err = writeManifest(dir, manifest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// readManifest reads the manifest of a segments directory as is
+func readManifest(dir string) (*Manifest, error) {
+
+	manifest := &Manifest{
+		Version:  ManifestVersion,
+		Segments: []*Segment{},
+	}
+
+	data, err := os.ReadFile(path.Join(dir, manifestFilename))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+
+	if manifest.Version > ManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+
+	return manifest, nil
+}
+
+func writeManifest(dir string, manifest *Manifest) error {
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFilename := path.Join(dir, manifestFilename+".tmp")
+	f, err := os.OpenFile(tmpFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	err = os.Rename(tmpFilename, path.Join(dir, manifestFilename))
+	if err != nil {
+		return fmt.Errorf("replace manifest: %w", err)
+	}
+	syncDir(dir)
+
+	return nil
+}
+
+// scanSegment describes an existing journal file
+func scanSegment(filename string, keyring *Keyring) (*Segment, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	active := &activeSegment{}
+	j := NewJournalReader(f, keyring)
+	command := &Command{}
+	for {
+		err := j.Next(command)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		active.track(command)
+	}
+
+	return &Segment{
+		Size:           j.Offset(),
+		Commands:       active.commands,
+		FirstTimestamp: active.firstTimestamp,
+		LastTimestamp:  active.lastTimestamp,
+		SealedAt:       time.Now().UnixNano(),
+	}, nil
+}
+
+// Segments returns a copy of the sealed segments, in replay order
+func (c *Collection) Segments() []Segment {
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+
+	result := make([]Segment, len(c.manifest.Segments))
+	for i, segment := range c.manifest.Segments {
+		result[i] = *segment
+	}
+	return result
+}
+
+// JournalSize returns the bytes of all the segments, including the active one
+func (c *Collection) JournalSize() int64 {
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+	return c.journalSize()
+}
+
+func (c *Collection) journalSize() int64 {
+	var size int64
+	for _, segment := range c.manifest.Segments {
+		size += segment.Size
+	}
+
+	c.encoderMutex.Lock()
+	size += c.active.size
+	c.encoderMutex.Unlock()
+
+	return size
+}
+
+func (c *Collection) needsRotation() bool {
+
+	options := c.Options.Segments
+	if options == nil || options.MaxSize <= 0 {
+		return false
+	}
+
+	c.encoderMutex.Lock()
+	size := c.active.size
+	c.encoderMutex.Unlock()
+
+	return size >= options.MaxSize
+}
+
+func (c *Collection) autoRotate() {
+	if !c.rotating.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.rotating.Store(false)
+
+	c.journalMutex.Lock()
+	defer c.journalMutex.Unlock()
+
+	if c.file == nil || !c.needsRotation() {
+		return
+	}
+
+	_, err := c.sealActiveSegment()
+	if err != nil {
+		slog.Error("rotate", "collection", c.Filename, "error", err)
+	}
+}
+
+// Rotate seals the active segment, even if it is smaller than MaxSize
+func (c *Collection) Rotate() (*Segment, error) {
+	c.journalMutex.Lock()
+	defer c.journalMutex.Unlock()
+
+	if c.file == nil {
+		return nil, fmt.Errorf("collection is closed")
+	}
+
+	return c.sealActiveSegment()
+}
+
+// sealActiveSegment moves the active segment into the segments directory and
+// starts a new one. It must be called holding journalMutex in write mode.
+// Returns nil if the active segment is empty.
+func (c *Collection) sealActiveSegment() (*Segment, error) {
+
+	err := c.flushBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("flush: %w", err)
+	}
+
+	if c.active.size == 0 {
+		return nil, nil
+	}
+
+	err = c.file.Sync()
+	if err != nil {
+		return nil, fmt.Errorf("fsync: %w", err)
+	}
+
+	dir := SegmentsDir(c.Filename)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	id := c.manifest.LastId + 1
+	segment := &Segment{
+		Id:             id,
+		File:           fmt.Sprintf("%06d.jsonl", id),
+		Size:           c.active.size,
+		Commands:       c.active.commands,
+		FirstTimestamp: c.active.firstTimestamp,
+		LastTimestamp:  c.active.lastTimestamp,
+		SealedAt:       time.Now().UnixNano(),
+	}
+
+	err = c.file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("close active segment: %w", err)
+	}
+
+	renameErr := os.Rename(c.Filename, path.Join(dir, segment.File))
+	if renameErr == nil {
+		syncDir(dir)
+		syncDir(filepath.Dir(c.Filename))
+		c.manifest.LastId = id
+		c.manifest.Segments = append(c.manifest.Segments, segment)
+		c.active = activeSegment{}
+	}
+
+	// Reopen the active segment in any case, so the collection keeps working
+	c.file, err = os.OpenFile(c.Filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		c.file = nil
+		return nil, fmt.Errorf("open active segment: %w", err)
+	}
+	c.buffer.Reset(c.file)
+
+	if renameErr != nil {
+		return nil, fmt.Errorf("seal active segment: %w", renameErr)
+	}
+
+	// If this fails, the segment will be adopted the next time the collection is opened
+	err = writeManifest(dir, c.manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return segment, nil
+}
@@ -0,0 +1,132 @@
+package collection
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestSegments_Rotation(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollectionWithOptions(filename, &Options{
+			Segments: &SegmentOptions{MaxSize: 1024},
+		})
+
+		// Run
+		for i := 0; i < 100; i++ {
+			c.Insert(map[string]interface{}{"id": i, "name": "Pablo"})
+		}
+
+		// Check
+		for i := 0; i < 100 && len(c.Segments()) == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		AssertTrue(len(c.Segments()) > 0)
+		c.Close()
+
+		c, err := OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 100)
+		AssertEqual(c.Rows[99].Id, int64(100))
+	})
+}
+
+func TestSegments_Replay(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+
+		// Run
+		for i := 0; i < 30; i++ {
+			c.Insert(map[string]interface{}{"id": i})
+			if i%10 == 9 {
+				c.Rotate()
+			}
+		}
+		c.Close()
+
+		// Check
+		segments := c.Segments()
+		AssertEqual(len(segments), 3)
+		commands := int64(0)
+		for _, segment := range segments {
+			info, err := os.Stat(path.Join(SegmentsDir(filename), segment.File))
+			AssertNil(err)
+			AssertEqual(info.Size(), segment.Size)
+			commands += segment.Commands
+		}
+		AssertEqual(commands, int64(31))
+
+		c, err := OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 30)
+		AssertEqual(c.Rows[29].Id, int64(30))
+		AssertEqual(len(c.Segments()), 3)
+	})
+}
+
+func TestSegments_Compact(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		row, _ := c.Insert(map[string]interface{}{"id": "1"})
+		c.Rotate()
+		for i := 0; i < 10; i++ {
+			c.Patch(row, map[string]interface{}{"n": i})
+		}
+		c.Rotate()
+
+		// Run
+		_, err := c.Compact()
+		AssertNil(err)
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Close()
+
+		// Check
+		segments := c.Segments()
+		AssertEqual(len(segments), 1)
+		AssertTrue(segments[0].Snapshot)
+		entries, _ := os.ReadDir(SegmentsDir(filename))
+		AssertEqual(len(entries), 2) // snapshot and manifest
+
+		c, err = OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+		AssertEqual(len(c.Rows), 2)
+		AssertEqual(string(c.Rows[0].Payload), `{"id":"1","n":9}`)
+	})
+}
+
+func TestSegments_AdoptOrphan(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup: crash after sealing a segment but before updating the manifest
+		c, _ := OpenCollection(filename)
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "2"})
+		c.Close()
+		os.Rename(filename, path.Join(SegmentsDir(filename), "000002.jsonl"))
+
+		// Run
+		c, err := OpenCollection(filename)
+		AssertNil(err)
+		defer c.Close()
+
+		// Check
+		AssertEqual(len(c.Rows), 2)
+		segments := c.Segments()
+		AssertEqual(len(segments), 2)
+		AssertEqual(segments[1].File, "000002.jsonl")
+		AssertEqual(segments[1].Commands, int64(1))
+	})
+}
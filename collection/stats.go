@@ -0,0 +1,43 @@
+package collection
+
+// Stats describes the size and the journal activity of a collection, the
+// counters start at zero when the collection is opened
+type Stats struct {
+	Rows         int            `json:"rows"`
+	Indexes      map[string]int `json:"indexes"` // entries of every index
+	JournalBytes int64          `json:"journal_bytes"`
+	BytesWritten int64          `json:"bytes_written"`
+	Unflushed    int64          `json:"unflushed"` // bytes in the write buffer
+	Flushes      int64          `json:"flushes"`   // explicit flushes of the write buffer
+}
+
+func (c *Collection) Stats() *Stats {
+
+	stats := &Stats{
+		Indexes:      map[string]int{},
+		JournalBytes: c.JournalSize(),
+		Flushes:      c.flushes.Load(),
+	}
+
+	c.rowsMutex.RLock()
+	stats.Rows = len(c.Rows)
+	for name, index := range c.Indexes {
+		stats.Indexes[name] = index.Len()
+	}
+	c.rowsMutex.RUnlock()
+
+	c.encoderMutex.Lock()
+	stats.BytesWritten = c.bytesWritten
+	if c.buffer != nil {
+		stats.Unflushed = int64(c.buffer.Buffered())
+	}
+	c.encoderMutex.Unlock()
+
+	return stats
+}
+
+// flushBuffer writes the buffered commands into the journal file
+func (c *Collection) flushBuffer() error {
+	c.flushes.Add(1)
+	return c.buffer.Flush()
+}
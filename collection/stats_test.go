@@ -0,0 +1,34 @@
+package collection
+
+import (
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestStats(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		c.Index("by-id", &IndexMapOptions{Field: "id"})
+		c.Index("by-name", &IndexBTreeOptions{Fields: []string{"name"}, Sparse: true})
+
+		// Run
+		c.Insert(map[string]any{"id": "1", "name": "Alfonso"})
+		c.Insert(map[string]any{"id": "2"})
+		before := c.Stats()
+		c.Sync()
+		after := c.Stats()
+
+		// Check
+		AssertEqual(before.Rows, 2)
+		AssertEqual(before.Indexes, map[string]int{"by-id": 2, "by-name": 1})
+		AssertTrue(before.BytesWritten > 0)
+		AssertEqual(before.Unflushed, before.BytesWritten)
+		AssertEqual(after.Unflushed, int64(0))
+		AssertEqual(after.Flushes, before.Flushes+1)
+		AssertEqual(after.JournalBytes, before.BytesWritten)
+	})
+}
@@ -0,0 +1,378 @@
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// In multi-primary mode (Options.Clock is set) every document keeps in
+// VersionsField the HLC version of the last change of each top-level field,
+// including the removed ones. Changes of other primaries are merged field by
+// field: the greatest version wins. Documents are identified by KeyField.
+const (
+	VersionsField = "_hlc"
+	KeyField      = "id"
+)
+
+var ErrMultiPrimaryDisabled = errors.New("multi-primary mode is disabled")
+
+// DocumentKey returns the id of a document, empty if it has none
+func DocumentKey(payload json.RawMessage) string {
+	doc := struct {
+		Id any `json:"id"`
+	}{}
+	json.Unmarshal(payload, &doc)
+	if doc.Id == nil {
+		return ""
+	}
+	if s, ok := doc.Id.(string); ok {
+		return s
+	}
+	return fmt.Sprint(doc.Id)
+}
+
+func documentVersions(doc map[string]any) map[string]string {
+	versions := map[string]string{}
+	if m, ok := doc[VersionsField].(map[string]any); ok {
+		for field, version := range m {
+			if s, ok := version.(string); ok {
+				versions[field] = s
+			}
+		}
+	}
+	return versions
+}
+
+func maxVersion(versions map[string]string) string {
+	result := ""
+	for _, version := range versions {
+		result = max(result, version)
+	}
+	return result
+}
+
+// stampDocument sets all the fields of a new document to version
+func stampDocument(item map[string]any, version string) {
+	versions := map[string]any{}
+	for field := range item {
+		if field != VersionsField {
+			versions[field] = version
+		}
+	}
+	item[VersionsField] = versions
+}
+
+// stampPatch sets the fields changed by a merge patch to version
+func stampPatch(patch any, version string) any {
+	m, ok := patch.(map[string]any)
+	if !ok {
+		return patch // replaces the whole document, nothing to merge
+	}
+	stamped := make(map[string]any, len(m)+1)
+	versions := map[string]any{}
+	for field, value := range m {
+		if field == VersionsField {
+			continue
+		}
+		stamped[field] = value
+		versions[field] = version
+	}
+	stamped[VersionsField] = versions
+	return stamped
+}
+
+// trackKey must be called holding rowsMutex
+func (c *Collection) trackKey(row *Row, key string) {
+	if row.key != "" && c.rowsByKey[row.key] == row {
+		delete(c.rowsByKey, row.key)
+	}
+	row.key = key
+	if key != "" {
+		c.rowsByKey[key] = row
+	}
+}
+
+// setTombstone must be called holding rowsMutex
+func (c *Collection) setTombstone(key, version string) {
+	if version > c.tombstones[key] {
+		c.tombstones[key] = version
+	}
+}
+
+// Merge applies an insert, patch or remove command of another primary. The
+// defaults are taken if the collection has none and the rest of commands
+// (indexes, durability) are ignored. Changes are merged with the local document
+// with the same KeyField, field by field, and written as new commands (none if
+// nothing changes), so merging the same command twice is harmless.
+func (c *Collection) Merge(command *Command) error {
+	c.journalMutex.RLock()
+	defer c.journalMutex.RUnlock()
+
+	if c.Options.Clock == nil {
+		return ErrMultiPrimaryDisabled
+	}
+	if c.file == nil {
+		return fmt.Errorf("collection is closed")
+	}
+
+	c.mergeMutex.Lock()
+	defer c.mergeMutex.Unlock()
+
+	if command.Version != "" {
+		c.Options.Clock.Update(command.Version)
+	}
+
+	switch command.Name {
+	case "set_defaults":
+		if c.GetDefaults() != nil {
+			return nil // keep the local ones
+		}
+		defaults := map[string]any{}
+		json.Unmarshal(command.Payload, &defaults)
+		return c.setDefaults(defaults, true)
+	case "insert":
+		return c.mergeInsert(command)
+	case "patch":
+		return c.mergePatch(command)
+	case "remove":
+		return c.mergeRemove(command)
+	}
+
+	return nil
+}
+
+func (c *Collection) mergeInsert(command *Command) error {
+
+	remote := map[string]any{}
+	err := json.Unmarshal(command.Payload, &remote)
+	if err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+	key := DocumentKey(command.Payload)
+	if key == "" {
+		return nil // cannot be matched
+	}
+	versions := documentVersions(remote)
+
+	c.rowsMutex.Lock()
+	row := c.rowsByKey[key]
+	tombstone := c.tombstones[key]
+	c.rowsMutex.Unlock()
+
+	if row == nil {
+		if tombstone != "" && tombstone >= maxVersion(versions) {
+			return nil // removed afterwards
+		}
+		row, err := c.addRow(command.Payload, 0, 0)
+		if err != nil {
+			return err
+		}
+		return c.EncodeCommand(&Command{
+			Name:      "insert",
+			Uuid:      uuid.New().String(),
+			Timestamp: time.Now().UnixNano(),
+			RowId:     row.Id,
+			Payload:   command.Payload,
+			Key:       key,
+			Version:   maxVersion(versions),
+		})
+	}
+
+	fields := []string{}
+	for field := range remote {
+		fields = append(fields, field)
+	}
+	for field := range versions {
+		if _, exists := remote[field]; !exists {
+			fields = append(fields, field) // removed in the remote document
+		}
+	}
+
+	return c.mergeRow(row, remote, versions, fields, true)
+}
+
+func (c *Collection) mergePatch(command *Command) error {
+
+	params := struct {
+		Diff map[string]any
+	}{}
+	err := json.Unmarshal(command.Payload, &params)
+	if err != nil {
+		return fmt.Errorf("decode payload: %w", err)
+	}
+	if command.Key == "" || params.Diff == nil {
+		return nil // cannot be matched
+	}
+
+	c.rowsMutex.Lock()
+	row := c.rowsByKey[command.Key]
+	c.rowsMutex.Unlock()
+	if row == nil {
+		return nil // removed, or it has a different id now
+	}
+
+	fields := []string{}
+	for field := range params.Diff {
+		fields = append(fields, field)
+	}
+
+	return c.mergeRow(row, params.Diff, documentVersions(params.Diff), fields, false)
+}
+
+// mergeRow patches the fields of a row with the remote values that have a
+// greater version. Inserts carry whole values, patches carry merge patches.
+func (c *Collection) mergeRow(row *Row, remote map[string]any, versions map[string]string, fields []string, whole bool) error {
+
+	row.PatchMutex.Lock()
+	defer row.PatchMutex.Unlock()
+
+	local := map[string]any{}
+	err := json.Unmarshal(row.GetPayload(), &local)
+	if err != nil {
+		return fmt.Errorf("decode row payload: %w", err)
+	}
+	localVersions := documentVersions(local)
+
+	patch := map[string]any{}
+	patchVersions := map[string]any{}
+	for _, field := range fields {
+		if field == VersionsField || versions[field] <= localVersions[field] {
+			continue
+		}
+		patchVersions[field] = versions[field]
+		value, exists := remote[field]
+		if !exists {
+			patch[field] = nil // removed
+			continue
+		}
+		if whole {
+			diff, changed := createMergeDiff(local[field], value)
+			if !changed {
+				continue // same value, only the version is newer
+			}
+			value = diff
+		}
+		patch[field] = value
+	}
+	if len(patchVersions) == 0 {
+		return nil
+	}
+	patch[VersionsField] = patchVersions
+
+	return c.patchByRow(row, patch, true)
+}
+
+func (c *Collection) mergeRemove(command *Command) error {
+
+	if command.Key == "" || command.Version == "" {
+		return nil // cannot be matched
+	}
+
+	c.rowsMutex.Lock()
+	row := c.rowsByKey[command.Key]
+	known := c.tombstones[command.Key] >= command.Version
+	c.rowsMutex.Unlock()
+
+	if known {
+		return nil
+	}
+
+	keep := false
+	if row != nil {
+		row.PatchMutex.Lock()
+		defer row.PatchMutex.Unlock()
+		local := map[string]any{}
+		json.Unmarshal(row.GetPayload(), &local)
+		keep = maxVersion(documentVersions(local)) > command.Version
+	}
+	if row != nil && !keep {
+		return c.removeByRow(row, true, command.Version)
+	}
+
+	// Keep the tombstone, so older changes do not bring the document back
+	c.rowsMutex.Lock()
+	c.setTombstone(command.Key, command.Version)
+	c.rowsMutex.Unlock()
+
+	err := c.EncodeCommand(&Command{
+		Name:      "remove",
+		Uuid:      uuid.New().String(),
+		Timestamp: time.Now().UnixNano(),
+		Payload:   json.RawMessage("{}"),
+		Key:       command.Key,
+		Version:   command.Version,
+	})
+	if err != nil || !keep {
+		return err
+	}
+
+	// The document changed after it was removed by the other primary, insert
+	// it again so the other primary gets it back. The row is replaced in
+	// place, readers never miss the document.
+	renewed, err := c.renewRow(row)
+	if err != nil {
+		return err
+	}
+	err = c.EncodeCommand(&Command{
+		Name:      "remove",
+		Uuid:      uuid.New().String(),
+		Timestamp: time.Now().UnixNano(),
+		RowId:     row.Id,
+		Payload:   json.RawMessage("{}"),
+		Key:       row.key,
+	})
+	if err != nil {
+		return err
+	}
+	local := map[string]any{}
+	json.Unmarshal(renewed.Payload, &local)
+	return c.EncodeCommand(&Command{
+		Name:      "insert",
+		Uuid:      uuid.New().String(),
+		Timestamp: time.Now().UnixNano(),
+		RowId:     renewed.Id,
+		Payload:   renewed.Payload,
+		Key:       renewed.key,
+		Version:   maxVersion(documentVersions(local)),
+	})
+}
+
+// renewRow replaces a row with a copy that has a new id, at the same position
+func (c *Collection) renewRow(row *Row) (*Row, error) {
+
+	c.rowsMutex.Lock()
+	defer c.rowsMutex.Unlock()
+
+	if c.rowsById[row.Id] != row {
+		return nil, fmt.Errorf("row %d does not exist", row.Id)
+	}
+
+	c.lastRowId++
+	renewed := &Row{
+		I:       row.I,
+		Id:      c.lastRowId,
+		Payload: row.Payload,
+		mutex:   c.rowsMutex,
+	}
+
+	err := indexRemove(c.Indexes, row)
+	if err != nil {
+		return nil, fmt.Errorf("indexRemove: %w", err)
+	}
+	err = indexInsert(c.Indexes, renewed)
+	if err != nil {
+		indexInsert(c.Indexes, row)
+		return nil, fmt.Errorf("indexInsert: %w", err)
+	}
+
+	c.Rows[row.I] = renewed
+	delete(c.rowsById, row.Id)
+	c.rowsById[renewed.Id] = renewed
+	c.trackKey(renewed, row.key)
+
+	return renewed, nil
+}
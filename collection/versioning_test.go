@@ -0,0 +1,185 @@
+package collection
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	. "github.com/fulldump/biff"
+)
+
+// mergeAll merges all the commands of a collection into another one
+func mergeAll(from, to *Collection) {
+	w, _ := from.Watch(&WatchOptions{Replay: true})
+	defer w.Close()
+	for i := int64(0); i < from.Head().Position; i++ {
+		change, _ := w.Next(context.Background())
+		err := to.Merge(change.Command)
+		AssertNil(err)
+	}
+}
+
+func openPrimaries(f func(a, b *Collection)) {
+	Environment(func(filenameA string) {
+		Environment(func(filenameB string) {
+			optionsA := DefaultOptions()
+			optionsA.Clock = NewHLC("a")
+			a, _ := OpenCollectionWithOptions(filenameA, optionsA)
+			defer a.Close()
+
+			optionsB := DefaultOptions()
+			optionsB.Clock = NewHLC("b")
+			b, _ := OpenCollectionWithOptions(filenameB, optionsB)
+			defer b.Close()
+
+			f(a, b)
+		})
+	})
+}
+
+func documents(c *Collection) map[string]map[string]any {
+	result := map[string]map[string]any{}
+	c.Traverse(func(data []byte) {
+		doc := map[string]any{}
+		json.Unmarshal(data, &doc)
+		delete(doc, VersionsField)
+		result[DocumentKey(data)] = doc
+	})
+	return result
+}
+
+func TestMerge_ConcurrentPatches(t *testing.T) {
+	openPrimaries(func(a, b *Collection) {
+
+		// Setup
+		a.Insert(map[string]any{"id": "1", "name": "Alice", "age": 30})
+		mergeAll(a, b)
+
+		// Run
+		a.Patch(a.rowsByKey["1"], map[string]any{"name": "Alicia", "city": "Madrid"})
+		b.Patch(b.rowsByKey["1"], map[string]any{"age": 31, "city": "Paris"}) // last writer
+		mergeAll(a, b)
+		mergeAll(b, a)
+
+		// Check
+		expected := map[string]map[string]any{
+			"1": {"id": "1", "name": "Alicia", "age": float64(31), "city": "Paris"},
+		}
+		AssertEqual(documents(a), expected)
+		AssertEqual(documents(b), expected)
+	})
+}
+
+func TestMerge_ConcurrentInserts(t *testing.T) {
+	openPrimaries(func(a, b *Collection) {
+
+		// Run
+		a.Insert(map[string]any{"id": "1", "a": 5, "b": 1})
+		b.Insert(map[string]any{"id": "1", "a": 5, "b": 2}) // last writer
+		mergeAll(a, b)
+		mergeAll(b, a)
+
+		// Check: the fields with the same value are kept
+		expected := map[string]map[string]any{
+			"1": {"id": "1", "a": float64(5), "b": float64(2)},
+		}
+		AssertEqual(documents(a), expected)
+		AssertEqual(documents(b), expected)
+	})
+}
+
+func TestMerge_Idempotent(t *testing.T) {
+	openPrimaries(func(a, b *Collection) {
+
+		a.Insert(map[string]any{"id": "1", "name": "Alice"})
+		a.Patch(a.rowsByKey["1"], map[string]any{"name": "Alicia"})
+		mergeAll(a, b)
+		mergeAll(b, a)
+		headA, headB := a.Head().Position, b.Head().Position
+
+		// Merging again writes nothing
+		mergeAll(a, b)
+		mergeAll(b, a)
+		AssertEqual(a.Head().Position, headA)
+		AssertEqual(b.Head().Position, headB)
+	})
+}
+
+func TestMerge_Remove(t *testing.T) {
+	openPrimaries(func(a, b *Collection) {
+
+		// Setup
+		a.Insert(map[string]any{"id": "1", "name": "Alice"})
+		a.Insert(map[string]any{"id": "2", "name": "Bob"})
+		mergeAll(a, b)
+
+		// Run
+		b.Patch(b.rowsByKey["1"], map[string]any{"name": "Alicia"})
+		a.Remove(a.rowsByKey["1"]) // removed after the patch
+		a.Remove(a.rowsByKey["2"])
+		b.Patch(b.rowsByKey["2"], map[string]any{"name": "Robert"}) // patched after the remove
+		mergeAll(a, b)
+		mergeAll(b, a)
+
+		// Check
+		AssertEqual(documents(a), documents(b))
+		AssertEqual(len(documents(a)), 1)
+		AssertEqual(documents(a)["2"]["name"], "Robert")
+		AssertEqual(documents(a)["2"]["id"], "2")
+	})
+}
+
+func TestMerge_RemoveKeepsNewerDocument(t *testing.T) {
+	openPrimaries(func(a, b *Collection) {
+
+		// Setup
+		a.Insert(map[string]any{"id": "1", "name": "Alice"})
+		a.Insert(map[string]any{"id": "2", "name": "Bob"})
+		mergeAll(a, b)
+		a.Remove(a.rowsByKey["1"])
+		b.Patch(b.rowsByKey["1"], map[string]any{"name": "Alicia"}) // patched after the remove
+		row := b.rowsByKey["1"]
+
+		// Run
+		mergeAll(a, b)
+
+		// Check: the row is replaced in place
+		renewed := b.rowsByKey["1"]
+		AssertTrue(renewed != row)
+		AssertEqual(renewed.I, row.I)
+		AssertEqual(b.Len(), 2)
+		AssertNil(b.GetRowById(row.Id))
+
+		// Check: the journal gives the same documents
+		expected := documents(b)
+		b.Close()
+		options := DefaultOptions()
+		options.Clock = NewHLC("b")
+		b, _ = OpenCollectionWithOptions(b.Filename, options)
+		defer b.Close()
+		AssertEqual(documents(b), expected)
+		AssertEqual(documents(b)["1"]["name"], "Alicia")
+	})
+}
+
+func TestMerge_TombstoneSurvivesReopen(t *testing.T) {
+	openPrimaries(func(a, b *Collection) {
+
+		// Setup
+		a.Insert(map[string]any{"id": "1", "name": "Alice"})
+		mergeAll(a, b)
+		a.Remove(a.rowsByKey["1"])
+		a.Compact()
+
+		// Run
+		a.Close()
+		options := DefaultOptions()
+		options.Clock = NewHLC("a")
+		a, _ = OpenCollectionWithOptions(a.Filename, options)
+		defer a.Close()
+		mergeAll(b, a) // the old insert comes back
+
+		// Check
+		AssertEqual(len(documents(a)), 0)
+	})
+}
@@ -0,0 +1,275 @@
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Change is a command written into the journal. Position is the number of
+// commands in the journal up to this one (included), compaction renumbers
+// them, so the command uuid is a more reliable point to resume from.
+type Change struct {
+	Position int64
+	Command  *Command
+}
+
+// WatchOptions sets where a Watcher starts, by default it only receives the
+// commands written after it is created
+type WatchOptions struct {
+	// Replay starts reading the journal after AfterPosition (zero is the
+	// beginning)
+	Replay        bool
+	AfterPosition int64
+	// AfterUuid starts after the command with this uuid
+	AfterUuid string
+	// MaxPending is the number of changes a watcher can fall behind before it
+	// is discarded with ErrWatcherOverflow, zero means DefaultWatchMaxPending
+	MaxPending int
+}
+
+const DefaultWatchMaxPending = 10000
+
+var ErrWatchPositionNotFound = errors.New("position not found in the journal")
+var ErrWatcherOverflow = errors.New("watcher is too slow, resume from the last change received")
+var ErrWatcherClosed = errors.New("watcher is closed")
+
+// Watcher reads the journal from a starting point and then follows the new
+// commands as they are written
+type Watcher struct {
+	collection *Collection
+
+	// Journal files up to the moment the watcher was created
+	snapshot *JournalSnapshot
+	files    []*JournalFile
+	reader   *JournalReader
+	position int64
+
+	mutex      *sync.Mutex
+	pending    []*Change
+	maxPending int
+	notify     chan struct{}
+	err        error
+}
+
+// Watch returns a Watcher that must be closed when it is not needed anymore
+func (c *Collection) Watch(options *WatchOptions) (*Watcher, error) {
+
+	if options == nil {
+		options = &WatchOptions{}
+	}
+
+	w := &Watcher{
+		collection: c,
+		mutex:      &sync.Mutex{},
+		maxPending: options.MaxPending,
+		notify:     make(chan struct{}, 1),
+	}
+	if w.maxPending <= 0 {
+		w.maxPending = DefaultWatchMaxPending
+	}
+
+	snapshot, err := c.FreezeJournal()
+	if err != nil {
+		return nil, err
+	}
+	c.watchersMutex.Lock()
+	c.watchers[w] = struct{}{}
+	c.watchersMutex.Unlock()
+	snapshot.Thaw()
+
+	w.snapshot = snapshot
+	for _, file := range snapshot.Files {
+		if file.file != nil { // skip the manifest
+			w.files = append(w.files, file)
+		}
+	}
+
+	switch {
+	case options.AfterUuid != "":
+		err = w.skipUntil(func(change *Change) bool {
+			return change.Command.Uuid == options.AfterUuid
+		})
+	case options.Replay && options.AfterPosition > snapshot.Commands:
+		err = fmt.Errorf("%w: %d is beyond the last position %d", ErrWatchPositionNotFound, options.AfterPosition, snapshot.Commands)
+	case options.Replay && options.AfterPosition > 0:
+		err = w.skipUntil(func(change *Change) bool {
+			return change.Position == options.AfterPosition
+		})
+	case options.Replay:
+		// from the beginning
+	default:
+		w.files = nil // only new commands
+		w.position = snapshot.Commands
+	}
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// skipUntil discards the journal changes up to the first one that matches f
+// (included)
+func (w *Watcher) skipUntil(f func(change *Change) bool) error {
+	for {
+		change, err := w.nextJournal()
+		if err == io.EOF {
+			return ErrWatchPositionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if f(change) {
+			return nil
+		}
+	}
+}
+
+// nextJournal reads the next change from the journal files of the snapshot
+func (w *Watcher) nextJournal() (*Change, error) {
+	for len(w.files) > 0 {
+		if w.reader == nil {
+			w.reader = NewJournalReader(w.files[0].Reader(), w.collection.Options.Encryption)
+		}
+		command := &Command{}
+		err := w.reader.Next(command)
+		if err == io.EOF {
+			w.files = w.files[1:]
+			w.reader = nil
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read '%s': %w", w.files[0].Name, err)
+		}
+		w.position++
+		return &Change{
+			Position: w.position,
+			Command:  command,
+		}, nil
+	}
+
+	if w.snapshot != nil {
+		w.snapshot.Close() // not needed anymore
+		w.snapshot = nil
+	}
+	return nil, io.EOF
+}
+
+// Position returns the position of the last change returned by Next or, if
+// there is none, the position the watcher started after
+func (w *Watcher) Position() int64 {
+	return w.position
+}
+
+// Next blocks until there is a change or ctx is done
+func (w *Watcher) Next(ctx context.Context) (*Change, error) {
+
+	change, err := w.nextJournal()
+	if err != io.EOF {
+		return change, err
+	}
+
+	for {
+		w.mutex.Lock()
+		if len(w.pending) > 0 {
+			change := w.pending[0]
+			w.pending[0] = nil
+			w.pending = w.pending[1:]
+			w.mutex.Unlock()
+			w.position = change.Position
+			return change, nil
+		}
+		err := w.err
+		w.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-w.notify:
+		}
+	}
+}
+
+// push enqueues a new change, it must not block the writer
+func (w *Watcher) push(change *Change) {
+	w.mutex.Lock()
+	if w.err == nil {
+		if len(w.pending) >= w.maxPending {
+			w.err = ErrWatcherOverflow
+			w.pending = nil
+		} else {
+			w.pending = append(w.pending, change)
+		}
+	}
+	w.mutex.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// stop makes Next return err once the pending changes are consumed
+func (w *Watcher) stop(err error) {
+	w.mutex.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mutex.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Watcher) Close() error {
+	c := w.collection
+	c.watchersMutex.Lock()
+	delete(c.watchers, w)
+	c.watchersMutex.Unlock()
+
+	w.stop(ErrWatcherClosed)
+
+	if w.snapshot == nil {
+		return nil
+	}
+	return w.snapshot.Close()
+}
+
+// notifyWatchers must be called holding encoderMutex, so changes are
+// delivered in journal order
+func (c *Collection) notifyWatchers(command *Command, position int64) {
+	c.watchersMutex.Lock()
+	defer c.watchersMutex.Unlock()
+
+	if len(c.watchers) == 0 {
+		return
+	}
+
+	change := &Change{
+		Position: position,
+		Command:  command,
+	}
+	for w := range c.watchers {
+		w.push(change)
+	}
+}
+
+// stopWatchers ends all the watchers with err
+func (c *Collection) stopWatchers(err error) {
+	c.watchersMutex.Lock()
+	defer c.watchersMutex.Unlock()
+
+	for w := range c.watchers {
+		w.stop(err)
+		delete(c.watchers, w)
+	}
+}
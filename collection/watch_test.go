@@ -0,0 +1,86 @@
+package collection
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/fulldump/biff"
+)
+
+func TestWatch(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Rotate()
+		c.Insert(map[string]interface{}{"id": "2"})
+
+		// Run
+		w, err := c.Watch(&WatchOptions{Replay: true, AfterPosition: 2}) // format and first insert
+		AssertNil(err)
+		defer w.Close()
+		row, _ := c.Insert(map[string]interface{}{"id": "3"})
+		c.Patch(row, map[string]interface{}{"name": "three"})
+
+		// Check
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		expected := []string{`{"id":"2"}`, `{"id":"3"}`, `{"diff":{"name":"three"}}`}
+		for i, payload := range expected {
+			change, err := w.Next(ctx)
+			AssertNil(err)
+			AssertEqual(change.Position, int64(i+3))
+			AssertEqual(string(change.Command.Payload), payload)
+		}
+	})
+}
+
+func TestWatch_Uuid(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		c.Insert(map[string]interface{}{"id": "1"})
+		c.Insert(map[string]interface{}{"id": "2"})
+		first, _ := c.Watch(&WatchOptions{Replay: true, AfterPosition: 1})
+		change, _ := first.Next(context.Background())
+		first.Close()
+
+		// Run
+		w, err := c.Watch(&WatchOptions{AfterUuid: change.Command.Uuid})
+		AssertNil(err)
+		defer w.Close()
+
+		// Check
+		change, err = w.Next(context.Background())
+		AssertNil(err)
+		AssertEqual(string(change.Command.Payload), `{"id":"2"}`)
+
+		_, err = c.Watch(&WatchOptions{AfterUuid: "invented"})
+		AssertEqual(err, ErrWatchPositionNotFound)
+	})
+}
+
+func TestWatch_Overflow(t *testing.T) {
+	Environment(func(filename string) {
+
+		// Setup
+		c, _ := OpenCollection(filename)
+		defer c.Close()
+		w, _ := c.Watch(&WatchOptions{MaxPending: 2})
+		defer w.Close()
+
+		// Run
+		for i := 0; i < 3; i++ {
+			c.Insert(map[string]interface{}{"i": i})
+		}
+
+		// Check
+		_, err := w.Next(context.Background())
+		AssertEqual(err, ErrWatcherOverflow)
+	})
+}
@@ -1,5 +1,7 @@
 package configuration
 
+import "time"
+
 type Configuration struct {
 	HttpAddr          string `usage:"HTTP address"`
 	HttpsEnabled      bool   `usage:""`
@@ -10,4 +12,52 @@ type Configuration struct {
 	ShowBanner        bool   `usage:"show big banner"`
 	ShowConfig        bool   `usage:"print config"`
 	EnableCompression bool   `usage:"enable http compression (gzip)"`
+	LoadWorkers       int    `usage:"collections loaded concurrently at startup (0 means one per CPU)"`
+	Backup            string `usage:"write a backup of the data directory to this file and exit"`
+	Restore           string `usage:"extract this backup archive into the data directory (must be empty) and exit"`
+	RestoreUntil      string `usage:"point in time recovery: discard journal commands after this time (RFC3339 or unix nanoseconds) and exit"`
+	RestoreUntilUuid  string `usage:"point in time recovery: discard journal commands from the one with this uuid and exit"`
+	RestoreDatabase   string `usage:"point in time recovery: only rewind the collections of this database"`
+	RestoreDryRun     bool   `usage:"point in time recovery: only report the commands that would be discarded"`
+	RestoreCollection string `usage:"point in time recovery: only rewind this collection (of the default database unless RestoreDatabase is set)"`
+
+	CompactionGarbageRatio float64 `usage:"compact a collection journal when this fraction of its commands is garbage (0 disables it)"`
+	CompactionMinCommands  int64   `usage:"do not compact journals with fewer commands than this"`
+
+	Durability         string        `usage:"journal durability: none | interval | sync (collections can override it)"`
+	DurabilityInterval time.Duration `usage:"flush and fsync period for interval durability"`
+
+	SegmentSize int64 `usage:"seal the active journal segment of a collection when it exceeds this size in bytes (0 disables rotation)"`
+
+	EncryptionKey     string `usage:"AES keys (hex or base64) to encrypt journals, comma separated, the first one encrypts new records"`
+	EncryptionKeyFile string `usage:"file with the encryption keys, same format as EncryptionKey"`
+
+	Follow string `usage:"run as a read only follower of the leader at this base url (e.g. http://10.0.0.1:8080)"`
+
+	ClusterNodes string `usage:"run in cluster mode (raft) with these nodes, base urls comma separated including this one (e.g. http://10.0.0.1:8080,http://10.0.0.2:8080,http://10.0.0.3:8080)"`
+	ClusterNode  string `usage:"base url of this node, one of ClusterNodes"`
+	ClusterDir   string `usage:"raft log directory (default: the data directory followed by .raft)"`
+
+	ClusterMaxLogEntries int64 `usage:"writes are rejected when the raft log has these entries, it is not compacted yet and every node keeps it in memory (0 means no limit)"`
+
+	Peers string `usage:"run in multi-primary mode merging the changes of these instances, base urls comma separated (e.g. http://10.0.0.2:8080,http://10.0.0.3:8080)"`
+
+	AuthAdminKey string `usage:"enable API key authentication, this key has full access and manages the other keys in /v1/apikeys"`
+	AuthPeerKey  string `usage:"API key sent to the leader, peers or cluster nodes when they have authentication enabled"`
+
+	HttpsCert               string `usage:"serve https with this certificate file (PEM), it is reloaded when it changes"`
+	HttpsKey                string `usage:"private key file (PEM) of HttpsCert"`
+	HttpsClientCa           string `usage:"verify client certificates with this CA bundle (PEM), their subjects can be bound to API keys"`
+	HttpsClientCertRequired bool   `usage:"reject clients without a valid certificate (needs HttpsClientCa)"`
+
+	AuthJwks        string `usage:"enable JWT bearer tokens verified with the keys of this JWKS file or url"`
+	AuthJwtIssuer   string `usage:"expected issuer (iss) of the JWTs, any if empty"`
+	AuthJwtAudience string `usage:"expected audience (aud) of the JWTs, any if empty"`
+	AuthJwtClaim    string `usage:"JWT claim with the scopes: database:collection:role entries (role is optional) or admin"`
+
+	AuditLog string `usage:"append who changed what and when to this file (outside the data directory), query it in /v1/audit"`
+
+	LogLevel  string `usage:"debug | info | warn | error"`
+	LogFormat string `usage:"text | json"`
+	LogFile   string `usage:"write the log to this file instead of stdout"`
 }
@@ -1,10 +1,27 @@
 package configuration
 
+import "time"
+
 func Default() *Configuration {
 	return &Configuration{
 		Dir:               "data",
 		HttpAddr:          "127.0.0.1:8080",
 		ShowBanner:        true,
 		EnableCompression: false,
+
+		CompactionGarbageRatio: 0.5,
+		CompactionMinCommands:  100000,
+
+		Durability:         "interval",
+		DurabilityInterval: time.Second,
+
+		SegmentSize: 64 * 1024 * 1024,
+
+		ClusterMaxLogEntries: 10_000_000,
+
+		AuthJwtClaim: "inceptiondb_scopes",
+
+		LogLevel:  "info",
+		LogFormat: "text",
 	}
 }
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+// A backup is a tar archive with the journal files of every collection, and
+// the logical databases with their settings, under BackupCollectionsDir (ready
+// to be used as data directory) and, at the end, BackupManifestFilename
+// describing its content.
+const (
+	BackupVersion          = 1
+	BackupManifestFilename = "backup.json"
+	BackupCollectionsDir   = "collections"
+)
+
+type BackupManifest struct {
+	Version     int                 `json:"version"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Encrypted   bool                `json:"encrypted"`
+	Databases   []*BackupDatabase   `json:"databases"`
+	Collections []*BackupCollection `json:"collections"`
+	// Quarantined collections can not be read, they are not in the backup
+	Quarantined []*BackupQuarantined `json:"quarantined,omitempty"`
+}
+
+// BackupDatabase is a logical database, the default one is not listed
+type BackupDatabase struct {
+	Name     string      `json:"name"`
+	Settings *BackupFile `json:"settings"`
+}
+
+type BackupCollection struct {
+	Database      string        `json:"database"`
+	Name          string        `json:"name"`
+	Commands      int64         `json:"commands"`
+	LastTimestamp int64         `json:"last_timestamp"`
+	Files         []*BackupFile `json:"files"`
+}
+
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+type BackupQuarantined struct {
+	Database string `json:"database"`
+	Name     string `json:"name"`
+	Error    string `json:"error"`
+}
+
+// Backup writes a tar archive with the state of all the collections, of all
+// the logical databases, at the same point in time. Writes are only blocked
+// while that point is captured, the archive is written afterwards.
+// Quarantined collections are left out (and listed in the manifest).
+func (db *Database) Backup(w io.Writer) (*BackupManifest, error) {
+
+	status := db.GetStatus()
+	if status != StatusOperating {
+		return nil, fmt.Errorf("database is %s", status)
+	}
+
+	manifest := &BackupManifest{
+		Version:     BackupVersion,
+		Encrypted:   db.Config.Encryption != nil,
+		Databases:   []*BackupDatabase{},
+		Collections: []*BackupCollection{},
+	}
+
+	databases := db.ListDatabases()
+	databaseNames := make([]string, 0, len(databases))
+	for name := range databases {
+		databaseNames = append(databaseNames, name)
+	}
+	sort.Strings(databaseNames)
+
+	type backupSource struct {
+		collection *collection.Collection
+		backup     *BackupCollection
+		dir        string // in the archive
+	}
+	sources := []*backupSource{}
+	settings := map[string]*Settings{}
+	for _, databaseName := range databaseNames {
+		database := databases[databaseName]
+		if database.GetStatus() != StatusOperating {
+			slog.Warn("backup without database", "database", databaseName, "status", database.GetStatus())
+			continue
+		}
+		dir := BackupCollectionsDir
+		if database.parent != nil {
+			dir = path.Join(BackupCollectionsDir, DatabasesDir, databaseName)
+			settings[databaseName] = database.Settings()
+		}
+
+		collections := database.ListCollections()
+		names := make([]string, 0, len(collections))
+		for name := range collections {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sources = append(sources, &backupSource{
+				collection: collections[name],
+				backup: &BackupCollection{
+					Database: databaseName,
+					Name:     name,
+					Files:    []*BackupFile{},
+				},
+				dir: path.Join(dir, path.Dir(name)),
+			})
+		}
+
+		for _, quarantined := range database.Quarantined() {
+			slog.Warn("backup without quarantined collection", "database", databaseName, "collection", quarantined.Name, "error", quarantined.Error)
+			manifest.Quarantined = append(manifest.Quarantined, &BackupQuarantined{
+				Database: databaseName,
+				Name:     quarantined.Name,
+				Error:    quarantined.Error,
+			})
+		}
+	}
+
+	// Capture a consistent point
+	snapshots := make([]*collection.JournalSnapshot, 0, len(sources))
+	defer func() {
+		for _, snapshot := range snapshots {
+			snapshot.Close()
+		}
+	}()
+	for _, source := range sources {
+		snapshot, err := source.collection.FreezeJournal()
+		if err != nil {
+			return nil, fmt.Errorf("freeze '%s/%s': %w", source.backup.Database, source.backup.Name, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	manifest.CreatedAt = time.Now().UTC()
+	for _, snapshot := range snapshots {
+		snapshot.Thaw()
+	}
+
+	// Write the archive
+	tw := tar.NewWriter(w)
+	for _, databaseName := range databaseNames {
+		if settings[databaseName] == nil {
+			continue
+		}
+		data, err := json.MarshalIndent(settings[databaseName], "", "    ")
+		if err != nil {
+			return nil, err
+		}
+		name := path.Join(BackupCollectionsDir, DatabasesDir, databaseName+".json")
+		sum, err := writeTarFile(tw, name, int64(len(data)), manifest.CreatedAt, bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("write '%s': %w", name, err)
+		}
+		manifest.Databases = append(manifest.Databases, &BackupDatabase{
+			Name: databaseName,
+			Settings: &BackupFile{
+				Name:   name,
+				Size:   int64(len(data)),
+				Sha256: sum,
+			},
+		})
+	}
+	for i, snapshot := range snapshots {
+		c := sources[i].backup
+		c.Commands = snapshot.Commands
+		c.LastTimestamp = snapshot.LastTimestamp
+		for _, file := range snapshot.Files {
+			name := path.Join(sources[i].dir, file.Name)
+			sum, err := writeTarFile(tw, name, file.Size, manifest.CreatedAt, file.Reader())
+			if err != nil {
+				return nil, fmt.Errorf("write '%s': %w", name, err)
+			}
+			c.Files = append(c.Files, &BackupFile{
+				Name:   name,
+				Size:   file.Size,
+				Sha256: sum,
+			})
+		}
+		manifest.Collections = append(manifest.Collections, c)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	_, err = writeTarFile(tw, BackupManifestFilename, int64(len(data)), manifest.CreatedAt, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("write manifest: %w", err)
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) (string, error) {
+
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tw, h), r)
+	if err != nil {
+		return "", err
+	}
+	if n != size {
+		return "", fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
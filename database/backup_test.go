@@ -0,0 +1,103 @@
+package database
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+func TestBackup_Restore(t *testing.T) {
+
+	// Setup
+	db := NewDatabase(&Config{Dir: t.TempDir()})
+	biff.AssertNil(db.Load())
+	users, _ := db.CreateCollection("users")
+	users.Insert(map[string]any{"name": "Alice"})
+	team, err := db.CreateDatabase("team", &Settings{MaxDocuments: 10})
+	biff.AssertNil(err)
+	tasks, _ := team.CreateCollection("tasks")
+	tasks.Insert(map[string]any{"title": "Backup"})
+	db.CreateDatabase("empty", nil)
+
+	archive := &bytes.Buffer{}
+	manifest, err := db.Backup(archive)
+	db.Stop()
+	biff.AssertNil(err)
+	biff.AssertEqual(len(manifest.Databases), 2)
+	biff.AssertEqual(len(manifest.Collections), 2)
+
+	// Run
+	dir := filepath.Join(t.TempDir(), "restored")
+	_, err = Restore(archive, dir)
+	biff.AssertNil(err)
+
+	// Check
+	db = NewDatabase(&Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+	users, err = db.GetCollection("users")
+	biff.AssertNil(err)
+	biff.AssertEqual(users.Len(), 1)
+	team, err = db.GetDatabase("team")
+	biff.AssertNil(err)
+	biff.AssertEqual(team.Settings().MaxDocuments, 10)
+	tasks, err = team.GetCollection("tasks")
+	biff.AssertNil(err)
+	biff.AssertEqual(tasks.Len(), 1)
+	_, err = db.GetDatabase("empty")
+	biff.AssertNil(err)
+}
+
+func TestBackup_Quarantined(t *testing.T) {
+
+	// Setup
+	db, _ := newCorruptDatabase(t)
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+
+	// Run
+	manifest, err := db.Backup(&bytes.Buffer{})
+
+	// Check
+	biff.AssertNil(err)
+	biff.AssertEqual(len(manifest.Collections), 1)
+	biff.AssertEqual(len(manifest.Quarantined), 1)
+	biff.AssertEqual(manifest.Quarantined[0].Database, DefaultDatabase)
+	biff.AssertEqual(manifest.Quarantined[0].Name, "bad")
+}
+
+func TestRewind_LogicalDatabase(t *testing.T) {
+
+	// Setup
+	dir := t.TempDir()
+	db := NewDatabase(&Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	team, _ := db.CreateDatabase("team", nil)
+	tasks, _ := team.CreateCollection("tasks")
+	tasks.Insert(map[string]any{"title": "Keep"})
+	tasks.Insert(map[string]any{"title": "Discard"})
+	target := &collection.RecoveryTarget{Uuid: tasks.Head().Uuid}
+	db.Stop()
+
+	// Run
+	results, err := Rewind(dir, "team", "tasks", target, nil)
+
+	// Check
+	biff.AssertNil(err)
+	biff.AssertEqual(len(results), 1)
+	biff.AssertEqual(results[0].Database, "team")
+	biff.AssertEqual(results[0].Collection, "tasks")
+	biff.AssertEqual(results[0].Discarded, int64(1))
+	db = NewDatabase(&Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+	team, _ = db.GetDatabase("team")
+	tasks, err = team.GetCollection("tasks")
+	biff.AssertNil(err)
+	biff.AssertEqual(tasks.Len(), 1)
+	biff.AssertEqual(len(team.ListCollections()), 1) // the discarded commands are not loaded
+}
@@ -1,12 +1,12 @@
 package database
 
 import (
+	"errors"
 	"fmt"
-	"io/fs"
-	"os"
+	"log/slog"
 	"path"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fulldump/inceptiondb/collection"
@@ -19,14 +19,60 @@ const (
 )
 
 type Config struct {
-	Dir string
+	Dir        string
+	Compaction collection.CompactionOptions
+	Durability collection.DurabilityOptions
+	Segments   collection.SegmentOptions
+	Encryption *collection.Keyring // nil disables encryption at rest
+	// LoadWorkers is the number of collections loaded at the same time, zero
+	// means one per CPU
+	LoadWorkers int
+	// Replicator is notified of every change if not nil, see package cluster
+	Replicator Replicator
+	// Clock enables the multi-primary mode, see collection.Collection.Merge
+	Clock *collection.HLC
+}
+
+// Replicator is called with the changes of the database instead of applying
+// them, holding the locks needed to keep their order. The replicator applies
+// them (with ApplyCreateCollection, ApplyDropCollection and
+// collection.Collection.ApplyCommand) once they are committed, and the change
+// waits for the returned function, that returns the error of applying it.
+type Replicator interface {
+	CreateCollection(name string) (wait func() error, err error)
+	DropCollection(name string) (wait func() error, err error)
+	Command(name string, command *collection.Command) (wait func() error, err error)
 }
 
 type Database struct {
 	Config      *Config
 	status      string
-	Collections map[string]*collection.Collection
+	Collections map[string]*collection.Collection // only ready collections, use GetCollection
+	loads       map[string]*CollectionLoad
+	scanned     bool // the collections of Config.Dir are registered in loads
+	loadTook    time.Duration
+	mutex       *sync.RWMutex
 	exit        chan struct{}
+	readOnly    string // why writes are rejected, empty if they are accepted
+
+	// Logical databases, see CreateDatabase
+	name      string    // empty for the default database
+	settings  *Settings // nil for the default database
+	parent    *Database
+	databases map[string]*Database
+}
+
+var ErrCollectionNotFound = errors.New("collection not found")
+var ErrCollectionLoading = errors.New("collection is loading")
+var ErrCollectionQuarantined = errors.New("collection is quarantined")
+var ErrReadOnly = errors.New("read only")
+
+// SystemCollectionPrefix is reserved for the internal collections (e.g. the
+// API keys, see package auth), they are hidden by the service layer
+const SystemCollectionPrefix = "_system."
+
+func IsSystemCollection(name string) bool {
+	return strings.HasPrefix(name, SystemCollectionPrefix)
 }
 
 func NewDatabase(config *Config) *Database { // todo: return error?
@@ -34,97 +80,215 @@ func NewDatabase(config *Config) *Database { // todo: return error?
 		Config:      config,
 		status:      StatusOpening,
 		Collections: map[string]*collection.Collection{},
+		loads:       map[string]*CollectionLoad{},
+		mutex:       &sync.RWMutex{},
 		exit:        make(chan struct{}),
+		databases:   map[string]*Database{},
 	}
 
 	return s
 }
 
 func (db *Database) GetStatus() string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
 	return db.status
 }
 
+func (db *Database) setStatus(status string) {
+	db.mutex.Lock()
+	db.status = status
+	db.mutex.Unlock()
+}
+
+// SetReadOnly makes the API reject writes explaining why, an empty reason
+// accepts them again. It does not affect the methods of Database.
+func (db *Database) SetReadOnly(reason string) {
+	db.mutex.Lock()
+	db.readOnly = reason
+	db.mutex.Unlock()
+}
+
+// ReadOnly returns why writes are rejected, empty if they are accepted
+func (db *Database) ReadOnly() string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.readOnly
+}
+
+// GetCollection returns ErrCollectionLoading if the collection exists but it
+// is not ready yet (or Load has not found it yet) and ErrCollectionQuarantined
+// if it could not be opened
+func (db *Database) GetCollection(name string) (*collection.Collection, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	col, exists := db.Collections[name]
+	if exists {
+		return col, nil
+	}
+
+	load, exists := db.loads[name]
+	if exists && (load.Status == CollectionPending || load.Status == CollectionLoading) {
+		return nil, ErrCollectionLoading
+	}
+	if exists && load.Status == CollectionQuarantined {
+		return nil, fmt.Errorf("%w: %s", ErrCollectionQuarantined, load.Error)
+	}
+	if db.unscanned() {
+		return nil, ErrCollectionLoading // it could be on disk
+	}
+
+	return nil, ErrCollectionNotFound
+}
+
+// unscanned tells if Load has not registered the collections on disk yet, it
+// must be called holding the mutex
+func (db *Database) unscanned() bool {
+	return db.status == StatusOpening && !db.scanned
+}
+
+// ListCollections returns the collections that are ready
+func (db *Database) ListCollections() map[string]*collection.Collection {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	result := make(map[string]*collection.Collection, len(db.Collections))
+	for name, col := range db.Collections {
+		result[name] = col
+	}
+	return result
+}
+
 func (db *Database) CreateCollection(name string) (*collection.Collection, error) {
+	return db.createCollection(name, true)
+}
+
+// ApplyCreateCollection creates a collection without notifying
+// Config.Replicator, it is used to apply the changes of other instances
+func (db *Database) ApplyCreateCollection(name string) (*collection.Collection, error) {
+	return db.createCollection(name, false)
+}
+
+func (db *Database) createCollection(name string, replicate bool) (*collection.Collection, error) {
+	db.mutex.Lock()
 
 	_, exists := db.Collections[name]
 	if exists {
+		db.mutex.Unlock()
+		return nil, fmt.Errorf("collection '%s' already exists", name)
+	}
+	if _, loading := db.loads[name]; loading {
+		db.mutex.Unlock()
 		return nil, fmt.Errorf("collection '%s' already exists", name)
 	}
+	if db.unscanned() {
+		db.mutex.Unlock()
+		return nil, ErrCollectionLoading
+	}
+	err := db.checkCollectionLimit()
+	if err != nil {
+		db.mutex.Unlock()
+		return nil, err
+	}
+
+	if replicate && db.Config.Replicator != nil {
+		wait, err := db.Config.Replicator.CreateCollection(name)
+		db.mutex.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("replicate: %w", err)
+		}
+		if wait != nil {
+			err = wait()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return db.GetCollection(name)
+	}
 
 	filename := path.Join(db.Config.Dir, name)
-	col, err := collection.OpenCollection(filename)
+	col, err := collection.OpenCollectionWithOptions(filename, db.collectionOptions(name))
 	if err != nil {
+		db.mutex.Unlock()
 		return nil, err
 	}
 
 	db.Collections[name] = col
+	db.mutex.Unlock()
 
 	return col, nil
 }
 
-func (db *Database) DropCollection(name string) error { // TODO: rename drop?
-
-	col, exists := db.Collections[name]
-	if !exists {
-		return fmt.Errorf("collection '%s' not found", name)
+func (db *Database) collectionOptions(name string) *collection.Options {
+	compaction := db.Config.Compaction
+	durability := db.Config.Durability
+	if durability.Mode == "" {
+		durability.Mode = collection.DurabilityNone
 	}
-
-	filename := path.Join(db.Config.Dir, name)
-
-	err := os.Remove(filename)
-	if err != nil {
-		return err // TODO: wrap?
+	segments := db.Config.Segments
+	options := &collection.Options{
+		Compaction:   &compaction,
+		Durability:   &durability,
+		Segments:     &segments,
+		Encryption:   db.Config.Encryption,
+		Clock:        db.Config.Clock,
+		MaxDocuments: db.maxDocuments(),
 	}
+	if replicator := db.Config.Replicator; replicator != nil {
+		options.Replicate = func(command *collection.Command) (func() error, error) {
+			return replicator.Command(name, command)
+		}
+	}
+	return options
+}
 
-	delete(db.Collections, name) // TODO: protect section! not threadsafe
+func (db *Database) DropCollection(name string) error { // TODO: rename drop?
+	return db.dropCollection(name, true)
+}
 
-	return col.Close()
+// ApplyDropCollection drops a collection without notifying Config.Replicator,
+// it is used to apply the changes of other instances
+func (db *Database) ApplyDropCollection(name string) error {
+	return db.dropCollection(name, false)
 }
 
-func (db *Database) Load() error {
+func (db *Database) dropCollection(name string, replicate bool) error {
 
-	fmt.Printf("Loading database %s...\n", db.Config.Dir) // todo: move to logger
-	dir := db.Config.Dir
-	err := os.MkdirAll(dir, 0755)
-	if err != nil {
-		return err
+	db.mutex.Lock()
+	col, exists := db.Collections[name]
+	load, quarantined := db.loads[name]
+	quarantined = quarantined && load.Status == CollectionQuarantined
+	if !exists && !quarantined {
+		db.mutex.Unlock()
+		return fmt.Errorf("collection '%s' not found", name)
 	}
-	err = filepath.WalkDir(dir, func(filename string, d fs.DirEntry, err error) error {
+	if replicate && db.Config.Replicator != nil {
+		wait, err := db.Config.Replicator.DropCollection(name)
+		db.mutex.Unlock()
 		if err != nil {
-			return err
+			return fmt.Errorf("replicate: %w", err)
 		}
-		if d.IsDir() {
+		if wait == nil {
 			return nil
 		}
+		return wait()
+	}
+	delete(db.Collections, name)
+	delete(db.loads, name)
+	db.mutex.Unlock()
 
-		name := filename
-		name = strings.TrimPrefix(name, dir)
-		name = strings.TrimPrefix(name, "/")
-
-		t0 := time.Now()
-		col, err := collection.OpenCollection(filename)
-		if err != nil {
-			fmt.Printf("ERROR: open collection '%s': %s\n", filename, err.Error()) // todo: move to logger
-			return err
-		}
-		fmt.Println(name, len(col.Rows), time.Since(t0)) // todo: move to logger
-
-		db.Collections[name] = col
-
-		return nil
-	})
-
+	var err error
+	if exists {
+		err = col.Drop()
+	} else {
+		err = dropFiles(path.Join(db.Config.Dir, name))
+	}
 	if err != nil {
-		db.status = StatusClosing
 		return err
 	}
 
-	fmt.Println("Ready")
-
-	db.status = StatusOperating
-
 	return nil
-
 }
 
 func (db *Database) Start() error {
@@ -140,14 +304,27 @@ func (db *Database) Stop() error {
 
 	defer close(db.exit)
 
-	db.status = StatusClosing
+	db.setStatus(StatusClosing)
 
 	var lastErr error
-	for name, col := range db.Collections {
-		fmt.Printf("Closing '%s'...\n", name)
+	db.mutex.RLock()
+	children := make([]*Database, 0, len(db.databases))
+	for _, child := range db.databases {
+		children = append(children, child)
+	}
+	db.mutex.RUnlock()
+	for _, child := range children {
+		err := child.Stop()
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	for name, col := range db.ListCollections() {
+		slog.Info("closing collection", "database", db.Name(), "collection", name)
 		err := col.Close()
 		if err != nil {
-			fmt.Printf("ERROR: close(%s): %s", name, err.Error())
+			slog.Error("close collection", "database", db.Name(), "collection", name, "error", err)
 			lastErr = err
 		}
 	}
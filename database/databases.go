@@ -0,0 +1,293 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Logical databases live in DatabasesDir inside Config.Dir, each one in its
+// own directory with its Settings next to it ({name}.json). The collections
+// of Config.Dir are the DefaultDatabase.
+const (
+	DatabasesDir    = ".databases"
+	DefaultDatabase = "default"
+)
+
+var ErrDatabaseNotFound = errors.New("database not found")
+var ErrDatabaseAlreadyExists = errors.New("database already exists")
+var ErrDatabasesNotReplicated = errors.New("logical databases are not replicated, they are not available in cluster or multi-primary mode")
+var ErrCollectionLimit = errors.New("collection limit reached")
+
+var databaseNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type Settings struct {
+	// Defaults of the collections created without defaults
+	Defaults       map[string]any `json:"defaults,omitempty"`
+	MaxCollections int            `json:"max_collections,omitempty"` // zero means no limit
+	MaxDocuments   int            `json:"max_documents,omitempty"`   // per collection, zero means no limit
+}
+
+func (s *Settings) Validate() error {
+	if s.MaxCollections < 0 || s.MaxDocuments < 0 {
+		return fmt.Errorf("limits must be positive, zero means no limit")
+	}
+	return nil
+}
+
+// Name returns DefaultDatabase for the root database
+func (db *Database) Name() string {
+	if db.name == "" {
+		return DefaultDatabase
+	}
+	return db.name
+}
+
+// Settings returns nil for the default database
+func (db *Database) Settings() *Settings {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	if db.settings == nil {
+		return nil
+	}
+	settings := *db.settings
+	return &settings
+}
+
+// GetDatabase returns a logical database, or db itself for DefaultDatabase
+func (db *Database) GetDatabase(name string) (*Database, error) {
+	if name == DefaultDatabase {
+		return db, nil
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	child, exists := db.databases[name]
+	if !exists {
+		return nil, ErrDatabaseNotFound
+	}
+	return child, nil
+}
+
+// ListDatabases returns the logical databases, including DefaultDatabase
+func (db *Database) ListDatabases() map[string]*Database {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	result := make(map[string]*Database, len(db.databases)+1)
+	result[DefaultDatabase] = db
+	for name, child := range db.databases {
+		result[name] = child
+	}
+	return result
+}
+
+func (db *Database) CreateDatabase(name string, settings *Settings) (*Database, error) {
+
+	if !databaseNameRegexp.MatchString(name) || name == DefaultDatabase {
+		return nil, fmt.Errorf("invalid database name '%s', use up to 64 letters, digits, '-' or '_'", name)
+	}
+	if db.Config.Replicator != nil || db.Config.Clock != nil {
+		return nil, ErrDatabasesNotReplicated
+	}
+	if settings == nil {
+		settings = &Settings{}
+	}
+	err := settings.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, exists := db.databases[name]; exists {
+		return nil, ErrDatabaseAlreadyExists
+	}
+
+	err = os.MkdirAll(path.Join(db.Config.Dir, DatabasesDir, name), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = db.writeSettings(name, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	child := db.newChild(name, settings)
+	child.status = StatusOperating
+	db.databases[name] = child
+
+	return child, nil
+}
+
+// SetSettings replaces the settings of a logical database, the limits apply
+// to the next writes
+func (db *Database) SetSettings(settings *Settings) error {
+
+	if db.parent == nil {
+		return fmt.Errorf("the %s database has no settings", DefaultDatabase)
+	}
+	err := settings.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = db.parent.writeSettings(db.name, settings)
+	if err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	db.settings = settings
+	db.mutex.Unlock()
+
+	for _, col := range db.ListCollections() {
+		col.SetMaxDocuments(settings.MaxDocuments)
+	}
+
+	return nil
+}
+
+// DropDatabase closes and removes a logical database with all its collections
+func (db *Database) DropDatabase(name string) error {
+
+	db.mutex.Lock()
+	child, exists := db.databases[name]
+	delete(db.databases, name)
+	db.mutex.Unlock()
+	if !exists {
+		return ErrDatabaseNotFound
+	}
+
+	child.setStatus(StatusClosing)
+	for colName, col := range child.ListCollections() {
+		err := col.Close()
+		if err != nil {
+			slog.Warn("close collection", "database", name, "collection", colName, "error", err)
+		}
+	}
+
+	err := os.RemoveAll(child.Config.Dir)
+	if err != nil {
+		return err
+	}
+	return os.Remove(db.settingsFilename(name))
+}
+
+func (db *Database) newChild(name string, settings *Settings) *Database {
+	config := *db.Config
+	config.Dir = path.Join(db.Config.Dir, DatabasesDir, name)
+	config.Replicator = nil
+	config.Clock = nil
+
+	child := NewDatabase(&config)
+	child.name = name
+	child.settings = settings
+	child.parent = db
+	return child
+}
+
+func (db *Database) settingsFilename(name string) string {
+	return path.Join(db.Config.Dir, DatabasesDir, name+".json")
+}
+
+// writeSettings replaces the settings file atomically
+func (db *Database) writeSettings(name string, settings *Settings) error {
+
+	data, err := json.MarshalIndent(settings, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	filename := db.settingsFilename(name)
+	tmp := filename + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// databaseNames returns the logical databases of a data directory, sorted
+func databaseNames(dir string) ([]string, error) {
+
+	filenames, err := filepath.Glob(path.Join(dir, DatabasesDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(filenames)
+
+	names := []string{}
+	for _, filename := range filenames {
+		name := strings.TrimSuffix(filepath.Base(filename), ".json")
+		if databaseNameRegexp.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// openDatabases registers the logical databases found in Config.Dir, Load
+// loads them after the collections of db
+func (db *Database) openDatabases() ([]*Database, error) {
+
+	names, err := databaseNames(db.Config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	children := []*Database{}
+	for _, name := range names {
+		data, err := os.ReadFile(db.settingsFilename(name))
+		if err != nil {
+			return nil, err
+		}
+		settings := &Settings{}
+		err = json.Unmarshal(data, settings)
+		if err != nil {
+			return nil, fmt.Errorf("database '%s' settings: %w", name, err)
+		}
+		children = append(children, db.newChild(name, settings))
+	}
+
+	db.mutex.Lock()
+	for _, child := range children {
+		db.databases[child.name] = child
+	}
+	db.mutex.Unlock()
+
+	return children, nil
+}
+
+// checkCollectionLimit must be called holding the mutex
+func (db *Database) checkCollectionLimit() error {
+	if db.settings == nil || db.settings.MaxCollections <= 0 {
+		return nil
+	}
+	n := len(db.Collections)
+	for name := range db.loads {
+		if _, ready := db.Collections[name]; !ready {
+			n++
+		}
+	}
+	if n >= db.settings.MaxCollections {
+		return fmt.Errorf("%w: %d", ErrCollectionLimit, db.settings.MaxCollections)
+	}
+	return nil
+}
+
+func (db *Database) maxDocuments() int {
+	if db.settings == nil {
+		return 0
+	}
+	return db.settings.MaxDocuments
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+func TestDatabases_Reopen(t *testing.T) {
+
+	// Setup
+	dir := t.TempDir()
+	db := NewDatabase(&Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	db.CreateCollection("users")
+	team, err := db.CreateDatabase("team", &Settings{MaxDocuments: 1})
+	biff.AssertNil(err)
+	users, err := team.CreateCollection("users")
+	biff.AssertNil(err)
+	_, err = users.Insert(map[string]any{"name": "Alice"})
+	biff.AssertNil(err)
+	db.Stop()
+
+	// Run
+	db = NewDatabase(&Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+
+	// Check
+	biff.AssertEqual(db.LoadProgress().Total, 1) // logical databases are not collections
+	team, err = db.GetDatabase("team")
+	biff.AssertNil(err)
+	biff.AssertEqual(team.GetStatus(), StatusOperating)
+	users, err = team.GetCollection("users")
+	biff.AssertNil(err)
+	biff.AssertEqual(len(users.Rows), 1)
+
+	_, err = users.Insert(map[string]any{"name": "Bob"})
+	biff.AssertTrue(errors.Is(err, collection.ErrDocumentLimit))
+}
+
+func TestDatabases_RegisteredBeforeLoading(t *testing.T) {
+
+	// Setup
+	dir := t.TempDir()
+	db := NewDatabase(&Config{Dir: dir})
+	biff.AssertNil(db.Load())
+	team, _ := db.CreateDatabase("team", nil)
+	team.CreateCollection("users")
+	db.Stop()
+
+	// Run: the root collections would be loaded now
+	db = NewDatabase(&Config{Dir: dir})
+	defer db.Stop()
+	children, err := db.register()
+	biff.AssertNil(err)
+
+	// Check
+	biff.AssertEqual(len(children), 1)
+	team, err = db.GetDatabase("team")
+	biff.AssertNil(err)
+	_, err = team.GetCollection("users")
+	biff.AssertEqual(err, ErrCollectionLoading)
+	_, err = team.CreateCollection("users")
+	biff.AssertNotNil(err)
+	_, err = team.GetCollection("other")
+	biff.AssertEqual(err, ErrCollectionNotFound)
+}
+
+func TestDatabases_Drop(t *testing.T) {
+
+	db := NewDatabase(&Config{Dir: t.TempDir()})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+
+	team, _ := db.CreateDatabase("team", &Settings{MaxCollections: 1})
+	_, err := team.CreateCollection("a")
+	biff.AssertNil(err)
+	_, err = team.CreateCollection("b")
+	biff.AssertTrue(errors.Is(err, ErrCollectionLimit))
+
+	biff.AssertNil(db.DropDatabase("team"))
+	_, err = db.GetDatabase("team")
+	biff.AssertEqual(err, ErrDatabaseNotFound)
+
+	// The name can be used again
+	team, err = db.CreateDatabase("team", nil)
+	biff.AssertNil(err)
+	biff.AssertEqual(len(team.ListCollections()), 0)
+}
@@ -0,0 +1,317 @@
+package database
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+// Load status of a collection
+const (
+	CollectionPending = "pending"
+	CollectionLoading = "loading"
+	CollectionReady   = "ready"
+	// CollectionQuarantined could not be opened, it is excluded from traffic
+	// until it is retried or repaired
+	CollectionQuarantined = "quarantined"
+)
+
+type CollectionLoad struct {
+	Name   string        `json:"name"`
+	Status string        `json:"status"`
+	Bytes  int64         `json:"bytes"`
+	Rows   int           `json:"rows"`
+	Took   time.Duration `json:"took"`
+	Error  string        `json:"error,omitempty"`
+}
+
+type LoadProgress struct {
+	Status      string            `json:"status"`
+	Total       int               `json:"total"`
+	Ready       int               `json:"ready"`
+	Quarantined int               `json:"quarantined"`
+	Bytes       int64             `json:"bytes"`
+	LoadedBytes int64             `json:"loaded_bytes"`
+	Took        time.Duration     `json:"took"` // zero until Load finishes
+	Collections []*CollectionLoad `json:"collections"`
+}
+
+// Load opens all the collections in Config.Dir, up to Config.LoadWorkers at
+// the same time (biggest first). Each collection can be used as soon as it is
+// ready, see GetCollection. Collections that can not be opened are
+// quarantined, the rest of the database keeps working. The collections of the
+// logical databases are loaded afterwards, but they are registered first so
+// they cannot be created again meanwhile.
+func (db *Database) Load() error {
+
+	slog.Info("loading database", "database", db.Name(), "dir", db.Config.Dir)
+	t0 := time.Now()
+
+	children, err := db.register()
+	if err != nil {
+		db.setStatus(StatusClosing)
+		return err
+	}
+
+	db.loadCollections(db.pendingLoads())
+
+	for _, child := range children {
+		t1 := time.Now()
+		child.loadCollections(child.pendingLoads())
+		child.loaded(t1)
+	}
+
+	db.loaded(t0)
+
+	return nil
+}
+
+// register finds the collections of db and of its logical databases, from
+// then on they cannot be created again. It returns the logical databases to
+// load.
+func (db *Database) register() ([]*Database, error) {
+
+	err := db.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := db.openDatabases()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Database{}
+	for _, child := range children {
+		err := child.scan()
+		if err != nil {
+			slog.Warn("database could not be loaded", "database", child.name, "error", err)
+			child.setStatus(StatusClosing)
+			continue
+		}
+		result = append(result, child)
+	}
+
+	return result, nil
+}
+
+// scan registers the collections in Config.Dir as pending
+func (db *Database) scan() error {
+
+	dir := db.Config.Dir
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	names, err := collectionNames(dir)
+	if err != nil {
+		return err
+	}
+
+	db.mutex.Lock()
+	for _, name := range names {
+		db.loads[name] = &CollectionLoad{
+			Name:   name,
+			Status: CollectionPending,
+			Bytes:  journalSize(path.Join(dir, name)),
+		}
+	}
+	db.scanned = true
+	db.mutex.Unlock()
+
+	return nil
+}
+
+// pendingLoads returns the collections to load, biggest first
+func (db *Database) pendingLoads() []*CollectionLoad {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	loads := []*CollectionLoad{}
+	for _, load := range db.loads {
+		if load.Status == CollectionPending {
+			loads = append(loads, load)
+		}
+	}
+	sort.Slice(loads, func(i, j int) bool {
+		if loads[i].Bytes != loads[j].Bytes {
+			return loads[i].Bytes > loads[j].Bytes
+		}
+		return loads[i].Name < loads[j].Name
+	})
+
+	return loads
+}
+
+// loadCollections opens loads, up to Config.LoadWorkers at the same time
+func (db *Database) loadCollections(loads []*CollectionLoad) {
+
+	workers := db.Config.LoadWorkers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	pending := make(chan *CollectionLoad)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for load := range pending {
+				db.loadCollection(load)
+			}
+		}()
+	}
+	for _, load := range loads {
+		pending <- load
+	}
+	close(pending)
+	wg.Wait()
+
+	for _, load := range loads {
+		if load.Status == CollectionQuarantined {
+			slog.Warn("collection quarantined", "database", db.Name(), "collection", load.Name, "error", load.Error)
+		}
+	}
+}
+
+func (db *Database) loaded(t0 time.Time) {
+	db.mutex.Lock()
+	db.loadTook = time.Since(t0)
+	db.mutex.Unlock()
+	slog.Info("database ready", "database", db.Name(), "took", db.loadTook)
+	db.setStatus(StatusOperating)
+}
+
+// loadCollection never replaces an open collection, there can only be one
+// writer per journal
+func (db *Database) loadCollection(load *CollectionLoad) error {
+
+	db.mutex.Lock()
+	if _, exists := db.Collections[load.Name]; exists {
+		load.Status = CollectionReady
+		db.mutex.Unlock()
+		return fmt.Errorf("collection '%s' is already open", load.Name)
+	}
+	load.Status = CollectionLoading
+	db.mutex.Unlock()
+
+	filename := path.Join(db.Config.Dir, load.Name)
+
+	t0 := time.Now()
+	col, err := collection.OpenCollectionWithOptions(filename, db.collectionOptions(load.Name))
+
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	load.Took = time.Since(t0)
+	if _, exists := db.Collections[load.Name]; exists {
+		if err == nil {
+			col.Close()
+		}
+		load.Status = CollectionReady
+		return fmt.Errorf("collection '%s' is already open", load.Name)
+	}
+	if err != nil {
+		slog.Error("open collection", "database", db.Name(), "collection", load.Name, "error", err)
+		load.Status = CollectionQuarantined
+		load.Error = err.Error()
+		return err
+	}
+	slog.Info("collection loaded", "database", db.Name(), "collection", load.Name, "rows", col.Len(), "took", load.Took)
+
+	load.Status = CollectionReady
+	load.Rows = col.Len()
+	load.Error = ""
+	db.Collections[load.Name] = col
+
+	return nil
+}
+
+// LoadProgress describes the state of the collections found by Load
+func (db *Database) LoadProgress() *LoadProgress {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	progress := &LoadProgress{
+		Status:      db.status,
+		Total:       len(db.loads),
+		Took:        db.loadTook,
+		Collections: make([]*CollectionLoad, 0, len(db.loads)),
+	}
+	for _, load := range db.loads {
+		l := *load
+		progress.Collections = append(progress.Collections, &l)
+		progress.Bytes += load.Bytes
+		switch load.Status {
+		case CollectionReady:
+			progress.Ready++
+			progress.LoadedBytes += load.Bytes
+		case CollectionQuarantined:
+			progress.Quarantined++
+		}
+	}
+	sort.Slice(progress.Collections, func(i, j int) bool {
+		return progress.Collections[i].Name < progress.Collections[j].Name
+	})
+
+	return progress
+}
+
+// collectionNames lists the collections stored in dir
+func collectionNames(dir string) ([]string, error) {
+
+	names := []string{}
+	err := filepath.WalkDir(dir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if collection.IsSegmentsDir(filename) || filename == filepath.Join(dir, DatabasesDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if collection.IsRewoundFile(filename) {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	sort.Strings(names)
+
+	return names, err
+}
+
+func journalSize(filename string) int64 {
+
+	files, err := collection.JournalFiles(filename)
+	if err != nil {
+		return 0
+	}
+
+	var size int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
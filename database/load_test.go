@@ -0,0 +1,83 @@
+package database
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+func TestLoad(t *testing.T) {
+
+	// Setup
+	dir := t.TempDir()
+	os.MkdirAll(path.Join(dir, "nested"), 0755)
+	for _, name := range []string{"a", "b", "nested/c"} {
+		c, _ := collection.OpenCollection(path.Join(dir, name))
+		c.Insert(map[string]any{"name": name})
+		c.Close()
+	}
+	db := NewDatabase(&Config{Dir: dir, LoadWorkers: 2})
+
+	// Run
+	err := db.Load()
+	defer db.Stop()
+
+	// Check
+	biff.AssertNil(err)
+	biff.AssertEqual(db.GetStatus(), StatusOperating)
+
+	progress := db.LoadProgress()
+	biff.AssertEqual(progress.Total, 3)
+	biff.AssertEqual(progress.Ready, 3)
+	biff.AssertEqual(progress.LoadedBytes, progress.Bytes)
+	biff.AssertEqual(progress.Collections[2].Name, "nested/c")
+	biff.AssertEqual(progress.Collections[2].Rows, 1)
+
+	c, err := db.GetCollection("nested/c")
+	biff.AssertNil(err)
+	biff.AssertEqual(len(c.Rows), 1)
+}
+
+func TestGetCollection_Loading(t *testing.T) {
+
+	db := NewDatabase(&Config{Dir: t.TempDir()})
+
+	_, err := db.GetCollection("other")
+	biff.AssertEqual(err, ErrCollectionLoading) // not scanned yet
+	_, err = db.CreateCollection("other")
+	biff.AssertEqual(err, ErrCollectionLoading)
+
+	db.loads["big"] = &CollectionLoad{Name: "big", Status: CollectionLoading}
+	db.scanned = true
+
+	_, err = db.GetCollection("big")
+	biff.AssertEqual(err, ErrCollectionLoading)
+
+	_, err = db.CreateCollection("big")
+	biff.AssertNotNil(err)
+
+	_, err = db.GetCollection("other")
+	biff.AssertEqual(err, ErrCollectionNotFound)
+}
+
+func TestLoadCollection_AlreadyOpen(t *testing.T) {
+
+	// Setup
+	db := NewDatabase(&Config{Dir: t.TempDir()})
+	biff.AssertNil(db.Load())
+	defer db.Stop()
+	c, err := db.CreateCollection("users")
+	biff.AssertNil(err)
+
+	// Run
+	err = db.loadCollection(&CollectionLoad{Name: "users"})
+
+	// Check
+	biff.AssertNotNil(err)
+	current, _ := db.GetCollection("users")
+	biff.AssertTrue(current == c)
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+var ErrCollectionNotQuarantined = errors.New("collection is not quarantined")
+
+// QuarantinedCollection is a collection that could not be opened
+type QuarantinedCollection struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// Quarantined returns the collections that could not be opened, sorted by name
+func (db *Database) Quarantined() []*QuarantinedCollection {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	result := []*QuarantinedCollection{}
+	for name, load := range db.loads {
+		if load.Status != CollectionQuarantined {
+			continue
+		}
+		result = append(result, &QuarantinedCollection{
+			Name:  name,
+			Error: load.Error,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// RetryCollection opens again a quarantined collection, for example after
+// fixing its files or the encryption keys
+func (db *Database) RetryCollection(name string) (*collection.Collection, error) {
+
+	load, err := db.takeQuarantined(name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.loadCollection(load)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetCollection(name)
+}
+
+// RepairCollection cuts the journal of a quarantined collection at the first
+// invalid record (see collection.Repair) and opens it again
+func (db *Database) RepairCollection(name string) (*collection.RepairStats, error) {
+
+	load, err := db.takeQuarantined(name)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := collection.Repair(path.Join(db.Config.Dir, name), db.Config.Encryption)
+	if err != nil {
+		db.mutex.Lock()
+		load.Status = CollectionQuarantined
+		db.mutex.Unlock()
+		return nil, fmt.Errorf("repair: %w", err)
+	}
+
+	err = db.loadCollection(load)
+	if err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// takeQuarantined marks a quarantined collection as loading so nobody else
+// retries, repairs or drops it at the same time
+func (db *Database) takeQuarantined(name string) (*CollectionLoad, error) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, exists := db.Collections[name]; exists {
+		return nil, ErrCollectionNotQuarantined
+	}
+
+	load, exists := db.loads[name]
+	if !exists {
+		return nil, ErrCollectionNotFound
+	}
+	if load.Status != CollectionQuarantined {
+		return nil, ErrCollectionNotQuarantined
+	}
+	load.Status = CollectionLoading
+
+	return load, nil
+}
+
+// dropFiles removes the journal of a collection that is not open
+func dropFiles(filename string) error {
+	err := os.RemoveAll(collection.SegmentsDir(filename))
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
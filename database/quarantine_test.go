@@ -0,0 +1,118 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fulldump/biff"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+func newCorruptDatabase(t *testing.T) (*Database, string) {
+
+	dir := t.TempDir()
+	for _, name := range []string{"good", "bad"} {
+		c, _ := collection.OpenCollection(path.Join(dir, name))
+		c.Insert(map[string]any{"id": "1"})
+		c.Insert(map[string]any{"id": "2"})
+		c.Close()
+	}
+
+	// Corrupt the first record, so it is not a torn tail
+	filename := path.Join(dir, "bad")
+	data, _ := os.ReadFile(filename)
+	data[10] = '#'
+	os.WriteFile(filename, data, 0666)
+
+	return NewDatabase(&Config{Dir: dir}), filename
+}
+
+func TestLoad_Quarantine(t *testing.T) {
+
+	db, _ := newCorruptDatabase(t)
+
+	err := db.Load()
+	defer db.Stop()
+
+	biff.AssertNil(err)
+	biff.AssertEqual(db.GetStatus(), StatusOperating)
+
+	good, err := db.GetCollection("good")
+	biff.AssertNil(err)
+	biff.AssertEqual(len(good.Rows), 2)
+
+	_, err = db.GetCollection("bad")
+	biff.AssertTrue(errors.Is(err, ErrCollectionQuarantined))
+
+	quarantined := db.Quarantined()
+	biff.AssertEqual(len(quarantined), 1)
+	biff.AssertEqual(quarantined[0].Name, "bad")
+	biff.AssertNotEqual(quarantined[0].Error, "")
+
+	progress := db.LoadProgress()
+	biff.AssertEqual(progress.Ready, 1)
+	biff.AssertEqual(progress.Quarantined, 1)
+
+	_, err = db.CreateCollection("bad")
+	biff.AssertNotNil(err)
+
+	_, err = db.RetryCollection("good")
+	biff.AssertEqual(err, ErrCollectionNotQuarantined)
+
+	_, err = db.RetryCollection("bad")
+	biff.AssertNotNil(err)
+	biff.AssertEqual(len(db.Quarantined()), 1)
+}
+
+func TestRepairCollection(t *testing.T) {
+
+	db, _ := newCorruptDatabase(t)
+	db.Load()
+	defer db.Stop()
+
+	stats, err := db.RepairCollection("bad")
+	biff.AssertNil(err)
+	biff.AssertEqual(stats.Offset, int64(0))
+	biff.AssertEqual(len(db.Quarantined()), 0)
+
+	bad, err := db.GetCollection("bad")
+	biff.AssertNil(err)
+	biff.AssertEqual(len(bad.Rows), 0)
+
+	_, err = db.RepairCollection("bad")
+	biff.AssertEqual(err, ErrCollectionNotQuarantined)
+}
+
+func TestRetryCollection(t *testing.T) {
+
+	db, filename := newCorruptDatabase(t)
+	db.Load()
+	defer db.Stop()
+
+	// Fix the file by hand
+	os.WriteFile(filename, []byte{}, 0666)
+
+	_, err := db.RetryCollection("bad")
+	biff.AssertNil(err)
+
+	_, err = db.GetCollection("bad")
+	biff.AssertNil(err)
+}
+
+func TestDropCollection_Quarantined(t *testing.T) {
+
+	db, filename := newCorruptDatabase(t)
+	db.Load()
+	defer db.Stop()
+
+	err := db.DropCollection("bad")
+	biff.AssertNil(err)
+
+	_, err = os.Stat(filename)
+	biff.AssertTrue(os.IsNotExist(err))
+	_, err = db.GetCollection("bad")
+	biff.AssertEqual(err, ErrCollectionNotFound)
+}
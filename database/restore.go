@@ -0,0 +1,228 @@
+package database
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+// Restore extracts a backup archive (see Backup) into dir, which must not
+// exist or be empty. The content is checked against the backup manifest
+// before dir is populated.
+func Restore(r io.Reader, dir string) (*BackupManifest, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("directory '%s' is not empty", dir)
+	}
+
+	tmpDir := strings.TrimSuffix(dir, "/") + ".restoring"
+	err = os.RemoveAll(tmpDir)
+	if err == nil {
+		err = os.MkdirAll(tmpDir, 0755)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir) // Only takes effect if something goes wrong
+
+	var manifest *BackupManifest
+	extracted := map[string]*BackupFile{}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+
+		if header.Name == BackupManifestFilename {
+			manifest = &BackupManifest{}
+			err := json.NewDecoder(tr).Decode(manifest)
+			if err != nil {
+				return nil, fmt.Errorf("decode backup manifest: %w", err)
+			}
+			continue
+		}
+
+		name := path.Clean(header.Name)
+		if header.Typeflag != tar.TypeReg || !strings.HasPrefix(name, BackupCollectionsDir+"/") {
+			continue
+		}
+
+		file, err := extractFile(tr, tmpDir, strings.TrimPrefix(name, BackupCollectionsDir+"/"))
+		if err != nil {
+			return nil, fmt.Errorf("extract '%s': %w", name, err)
+		}
+		file.Name = name
+		extracted[name] = file
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%s not found, the archive is incomplete", BackupManifestFilename)
+	}
+	if manifest.Version > BackupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+
+	expected := []*BackupFile{}
+	for _, d := range manifest.Databases {
+		if d.Settings != nil {
+			expected = append(expected, d.Settings)
+		}
+		// Databases without collections have no files
+		err := os.MkdirAll(filepath.Join(tmpDir, DatabasesDir, d.Name), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range manifest.Collections {
+		expected = append(expected, c.Files...)
+	}
+	for _, e := range expected {
+		file, ok := extracted[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("file '%s' is missing", e.Name)
+		}
+		if file.Size != e.Size || file.Sha256 != e.Sha256 {
+			return nil, fmt.Errorf("file '%s' is corrupted", e.Name)
+		}
+	}
+
+	os.Remove(dir) // empty or not existing
+	err = os.MkdirAll(filepath.Dir(filepath.Clean(dir)), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Rename(tmpDir, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+func extractFile(r io.Reader, dir, name string) (*BackupFile, error) {
+
+	if name == "" || !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("invalid path")
+	}
+
+	filename := filepath.Join(dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return nil, err
+	}
+
+	return &BackupFile{
+		Size:   size,
+		Sha256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+type RewindResult struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	*collection.RewindStats
+}
+
+// Rewind discards the journal commands from target on, for all the
+// collections in dir (the logical databases included), only the ones of
+// database or only the one called name (of database, DefaultDatabase if
+// empty). The database must not be running. A uuid target only rewinds the
+// collection that contains it.
+func Rewind(dir string, database string, name string, target *collection.RecoveryTarget, keyring *collection.Keyring) ([]*RewindResult, error) {
+
+	databases, err := databaseNames(dir)
+	if err != nil {
+		return nil, err
+	}
+	databases = append([]string{DefaultDatabase}, databases...)
+
+	if database == "" && name != "" {
+		database = DefaultDatabase
+	}
+	if database != "" {
+		if !slices.Contains(databases, database) {
+			return nil, fmt.Errorf("%w: '%s'", ErrDatabaseNotFound, database)
+		}
+		databases = []string{database}
+	}
+
+	results := []*RewindResult{}
+	for _, database := range databases {
+		databaseDir := dir
+		if database != DefaultDatabase {
+			databaseDir = filepath.Join(dir, DatabasesDir, database)
+		}
+		names, err := collectionNames(databaseDir)
+		if os.IsNotExist(err) {
+			continue // without collections
+		}
+		if err != nil {
+			return results, err
+		}
+
+		if name != "" {
+			if !slices.Contains(names, name) {
+				return nil, fmt.Errorf("collection '%s' not found in database '%s'", name, database)
+			}
+			names = []string{name}
+		}
+
+		for _, name := range names {
+			stats, err := collection.Rewind(filepath.Join(databaseDir, name), target, keyring)
+			if errors.Is(err, collection.ErrRecoveryTargetNotFound) {
+				continue
+			}
+			if err != nil {
+				return results, fmt.Errorf("rewind '%s/%s': %w", database, name, err)
+			}
+			results = append(results, &RewindResult{
+				Database:    database,
+				Collection:  name,
+				RewindStats: stats,
+			})
+		}
+	}
+
+	if target.Uuid != "" && len(results) == 0 {
+		return nil, collection.ErrRecoveryTargetNotFound
+	}
+
+	return results, nil
+}
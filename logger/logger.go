@@ -0,0 +1,106 @@
+// Package logger configures the structured logger of the server. The rest of
+// the packages log with log/slog (the default logger, see Configure) and use
+// the Context variants (e.g. slog.InfoContext) when serving a request, so the
+// request id given by WithRequestId is attached to the line.
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+type Options struct {
+	Level  string // debug, info, warn or error
+	Format string // text or json
+	File   string // empty means stdout
+}
+
+func DefaultOptions() *Options {
+	return &Options{
+		Level:  "info",
+		Format: "text",
+	}
+}
+
+// New returns a logger writing to w, options.File is ignored
+func New(w io.Writer, options *Options) (*slog.Logger, error) {
+
+	level := slog.LevelInfo
+	if options.Level != "" {
+		err := level.UnmarshalText([]byte(options.Level))
+		if err != nil {
+			return nil, fmt.Errorf("log level '%s': use debug, info, warn or error", options.Level)
+		}
+	}
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(options.Format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, handlerOptions)
+	case "json":
+		h = slog.NewJSONHandler(w, handlerOptions)
+	default:
+		return nil, fmt.Errorf("log format '%s': use text or json", options.Format)
+	}
+
+	return slog.New(&handler{Handler: h}), nil
+}
+
+// Configure makes the logger described by options the default one, also for
+// the log package
+func Configure(options *Options) error {
+
+	var w io.Writer = os.Stdout
+	if options.File != "" {
+		f, err := os.OpenFile(options.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w = f // open until the process exits
+	}
+
+	l, err := New(w, options)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l)
+
+	return nil
+}
+
+type requestIdKey struct{}
+
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId returns the id given by WithRequestId, empty if there is none
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// handler adds the request id of the context to every record
+type handler struct {
+	slog.Handler
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	return &handler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/fulldump/biff"
+)
+
+func TestLogger(t *testing.T) {
+
+	// Setup
+	b := &bytes.Buffer{}
+	l, err := New(b, &Options{Level: "warn", Format: "json"})
+	biff.AssertNil(err)
+	ctx := WithRequestId(context.Background(), "req-1")
+
+	// Run
+	l.InfoContext(ctx, "ignored")
+	l.With("collection", "users").WarnContext(ctx, "slow", "took", 3)
+
+	// Check
+	line := map[string]any{}
+	biff.AssertNil(json.Unmarshal(b.Bytes(), &line))
+	delete(line, "time")
+	biff.AssertEqual(line, map[string]any{
+		"level":      "WARN",
+		"msg":        "slow",
+		"collection": "users",
+		"took":       float64(3),
+		"request_id": "req-1",
+	})
+}
+
+func TestLogger_Invalid(t *testing.T) {
+
+	_, err := New(&bytes.Buffer{}, &Options{Level: "verbose"})
+	biff.AssertNotNil(err)
+
+	_, err = New(&bytes.Buffer{}, &Options{Format: "xml"})
+	biff.AssertNotNil(err)
+}
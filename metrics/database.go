@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/database"
+)
+
+// WriteDatabases writes the metrics of the collections of every database
+// (see database.Database.ListDatabases), including the system ones
+func WriteDatabases(w *Writer, databases map[string]*database.Database) {
+
+	type collectionStats struct {
+		database   string
+		collection string
+		*collection.Stats
+	}
+
+	names := make([]string, 0, len(databases))
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := []collectionStats{}
+	for _, name := range names {
+		collections := databases[name].ListCollections()
+		collectionNames := make([]string, 0, len(collections))
+		for collectionName := range collections {
+			collectionNames = append(collectionNames, collectionName)
+		}
+		sort.Strings(collectionNames)
+		for _, collectionName := range collectionNames {
+			stats = append(stats, collectionStats{
+				database:   name,
+				collection: collectionName,
+				Stats:      collections[collectionName].Stats(),
+			})
+		}
+	}
+
+	families := []struct {
+		name  string
+		kind  string
+		help  string
+		value func(s *collection.Stats) int64
+	}{
+		{"collection_rows", "gauge", "Documents in the collection.", func(s *collection.Stats) int64 { return int64(s.Rows) }},
+		{"journal_size_bytes", "gauge", "Size of the journal of the collection, all segments included.", func(s *collection.Stats) int64 { return s.JournalBytes }},
+		{"journal_written_bytes_total", "counter", "Bytes written into the journal since the collection was opened.", func(s *collection.Stats) int64 { return s.BytesWritten }},
+		{"journal_unflushed_bytes", "gauge", "Bytes in the write buffer not flushed to the journal yet.", func(s *collection.Stats) int64 { return s.Unflushed }},
+		{"journal_flushes_total", "counter", "Flushes of the write buffer since the collection was opened.", func(s *collection.Stats) int64 { return s.Flushes }},
+	}
+	for _, family := range families {
+		w.Header(Prefix+family.name, family.kind, family.help)
+		for _, s := range stats {
+			w.Sample(Prefix+family.name, float64(family.value(s.Stats)), "database", s.database, "collection", s.collection)
+		}
+	}
+
+	w.Header(Prefix+"index_entries", "gauge", "Entries of each index.")
+	for _, s := range stats {
+		indexes := make([]string, 0, len(s.Indexes))
+		for index := range s.Indexes {
+			indexes = append(indexes, index)
+		}
+		sort.Strings(indexes)
+		for _, index := range indexes {
+			w.Sample(Prefix+"index_entries", float64(s.Indexes[index]), "database", s.database, "collection", s.collection, "index", index)
+		}
+	}
+
+	w.Header(Prefix+"load_duration_seconds", "gauge", "Time taken by the last load of each database, zero while loading.")
+	for _, name := range names {
+		w.Sample(Prefix+"load_duration_seconds", databases[name].LoadProgress().Took.Seconds(), "database", name)
+	}
+
+	w.Header(Prefix+"collections_quarantined", "gauge", "Collections that could not be opened.")
+	for _, name := range names {
+		w.Sample(Prefix+"collections_quarantined", float64(databases[name].LoadProgress().Quarantined), "database", name)
+	}
+}
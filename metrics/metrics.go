@@ -0,0 +1,81 @@
+// Package metrics writes the server metrics in the Prometheus text exposition
+// format (https://prometheus.io/docs/instrumenting/exposition_formats/):
+// requests per action (see Requests), collections and journals of every
+// database (see WriteDatabases) and Go runtime stats (see WriteRuntime).
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Prefix of the metrics of the server, the runtime ones start with go_
+const Prefix = "inceptiondb_"
+
+// Writer writes metric families, every family is a header followed by its
+// samples
+type Writer struct {
+	w *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: bufio.NewWriter(w),
+	}
+}
+
+// Header starts a family, kind is counter, gauge or histogram
+func (w *Writer) Header(name, kind, help string) {
+	w.w.WriteString("# HELP " + name + " " + escape(help, false) + "\n")
+	w.w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+// Sample writes a value, labels are pairs of name and value
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.w.WriteString(name)
+	if len(labels) > 0 {
+		w.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.w.WriteByte(',')
+			}
+			w.w.WriteString(labels[i] + `="` + escape(labels[i+1], true) + `"`)
+		}
+		w.w.WriteByte('}')
+	}
+	w.w.WriteByte(' ')
+	w.w.WriteString(formatFloat(value))
+	w.w.WriteByte('\n')
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escape(s string, label bool) string {
+	if label {
+		return labelReplacer.Replace(s)
+	}
+	return helpReplacer.Replace(s)
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fulldump/biff"
+)
+
+func TestWriter(t *testing.T) {
+
+	// Setup
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+
+	// Run
+	w.Header("test_total", "counter", "A test\\counter.")
+	w.Sample("test_total", 1.5, "name", "a \"quoted\"\nvalue")
+	w.Sample("test_total", math.Inf(1))
+	biff.AssertNil(w.Flush())
+
+	// Check
+	biff.AssertEqual(b.String(), `# HELP test_total A test\\counter.
+# TYPE test_total counter
+test_total{name="a \"quoted\"\nvalue"} 1.5
+test_total +Inf
+`)
+}
+
+func TestRequests(t *testing.T) {
+
+	// Setup
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+	requests := NewRequests()
+	requests.buckets = []float64{0.1, 1}
+
+	// Run
+	requests.Observe("POST", "/v1/collections/{collectionName}", "insert", http.StatusCreated, 50*time.Millisecond)
+	requests.Observe("POST", "/v1/collections/{collectionName}", "insert", http.StatusCreated, 500*time.Millisecond)
+	requests.Observe("POST", "/v1/collections/{collectionName}", "insert", http.StatusConflict, 2*time.Second)
+	requests.Write(w)
+	w.Flush()
+
+	// Check
+	labels := `method="POST",path="/v1/collections/{collectionName}",action="insert"`
+	biff.AssertEqual(b.String(), `# HELP inceptiondb_http_requests_total HTTP requests by action and status code.
+# TYPE inceptiondb_http_requests_total counter
+inceptiondb_http_requests_total{`+labels+`,code="201"} 2
+inceptiondb_http_requests_total{`+labels+`,code="409"} 1
+# HELP inceptiondb_http_request_duration_seconds HTTP request duration by action.
+# TYPE inceptiondb_http_request_duration_seconds histogram
+inceptiondb_http_request_duration_seconds_bucket{`+labels+`,le="0.1"} 1
+inceptiondb_http_request_duration_seconds_bucket{`+labels+`,le="1"} 2
+inceptiondb_http_request_duration_seconds_bucket{`+labels+`,le="+Inf"} 3
+inceptiondb_http_request_duration_seconds_sum{`+labels+`} 2.55
+inceptiondb_http_request_duration_seconds_count{`+labels+`} 3
+`)
+}
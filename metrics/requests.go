@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets of the request duration histogram, in seconds
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Requests counts the HTTP requests and their duration per action. The
+// action is identified by the path of its resource (e.g.
+// /v1/collections/{collectionName}) and its name, so the number of series
+// does not grow with the data.
+type Requests struct {
+	buckets []float64
+	mutex   *sync.Mutex
+	counts  map[requestKey]int64
+	latency map[actionKey]*histogram
+}
+
+type actionKey struct {
+	method string
+	path   string
+	action string
+}
+
+type requestKey struct {
+	actionKey
+	status int
+}
+
+type histogram struct {
+	buckets []int64 // non cumulative, the last one is +Inf
+	sum     float64
+	count   int64
+}
+
+func NewRequests() *Requests {
+	return &Requests{
+		buckets: DefaultBuckets,
+		mutex:   &sync.Mutex{},
+		counts:  map[requestKey]int64{},
+		latency: map[actionKey]*histogram{},
+	}
+}
+
+func (r *Requests) Observe(method, path, action string, status int, took time.Duration) {
+
+	key := actionKey{method: method, path: path, action: action}
+	seconds := took.Seconds()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counts[requestKey{actionKey: key, status: status}]++
+
+	h, exists := r.latency[key]
+	if !exists {
+		h = &histogram{buckets: make([]int64, len(r.buckets)+1)}
+		r.latency[key] = h
+	}
+	i := sort.SearchFloat64s(r.buckets, seconds) // first bucket >= seconds
+	h.buckets[i]++
+	h.sum += seconds
+	h.count++
+}
+
+func (r *Requests) Write(w *Writer) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	requests := make([]requestKey, 0, len(r.counts))
+	for key := range r.counts {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].actionKey != requests[j].actionKey {
+			return requests[i].actionKey.less(requests[j].actionKey)
+		}
+		return requests[i].status < requests[j].status
+	})
+
+	w.Header(Prefix+"http_requests_total", "counter", "HTTP requests by action and status code.")
+	for _, key := range requests {
+		w.Sample(Prefix+"http_requests_total", float64(r.counts[key]), key.labels("code", strconv.Itoa(key.status))...)
+	}
+
+	actions := make([]actionKey, 0, len(r.latency))
+	for key := range r.latency {
+		actions = append(actions, key)
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].less(actions[j])
+	})
+
+	name := Prefix + "http_request_duration_seconds"
+	w.Header(name, "histogram", "HTTP request duration by action.")
+	for _, key := range actions {
+		h := r.latency[key]
+		cumulative := int64(0)
+		for i, count := range h.buckets {
+			cumulative += count
+			le := "+Inf"
+			if i < len(r.buckets) {
+				le = formatFloat(r.buckets[i])
+			}
+			w.Sample(name+"_bucket", float64(cumulative), key.labels("le", le)...)
+		}
+		w.Sample(name+"_sum", h.sum, key.labels()...)
+		w.Sample(name+"_count", float64(h.count), key.labels()...)
+	}
+}
+
+func (k actionKey) labels(extra ...string) []string {
+	return append([]string{"method", k.method, "path", k.path, "action", k.action}, extra...)
+}
+
+func (k actionKey) less(other actionKey) bool {
+	if k.path != other.path {
+		return k.path < other.path
+	}
+	if k.action != other.action {
+		return k.action < other.action
+	}
+	return k.method < other.method
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"runtime"
+)
+
+// WriteRuntime writes the Go runtime stats with the names used by the
+// official Prometheus client, so the usual dashboards work
+func WriteRuntime(w *Writer) {
+
+	m := &runtime.MemStats{}
+	runtime.ReadMemStats(m)
+
+	w.Header("go_info", "gauge", "Information about the Go environment.")
+	w.Sample("go_info", 1, "version", runtime.Version())
+
+	w.Header("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	w.Sample("go_goroutines", float64(runtime.NumGoroutine()))
+
+	families := []struct {
+		name  string
+		kind  string
+		help  string
+		value uint64
+	}{
+		{"go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.", m.Alloc},
+		{"go_memstats_alloc_bytes_total", "counter", "Total number of bytes allocated, even if freed.", m.TotalAlloc},
+		{"go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.", m.Sys},
+		{"go_memstats_heap_inuse_bytes", "gauge", "Number of heap bytes that are in use.", m.HeapInuse},
+		{"go_memstats_heap_objects", "gauge", "Number of allocated objects.", m.HeapObjects},
+		{"go_memstats_next_gc_bytes", "gauge", "Number of heap bytes when next garbage collection will take place.", m.NextGC},
+		{"go_memstats_gc_cycles_total", "counter", "Number of completed GC cycles.", uint64(m.NumGC)},
+	}
+	for _, family := range families {
+		w.Header(family.name, family.kind, family.help)
+		w.Sample(family.name, float64(family.value))
+	}
+
+	w.Header("go_gc_pause_seconds_total", "counter", "Total time spent in GC stop-the-world pauses.")
+	w.Sample("go_gc_pause_seconds_total", float64(m.PauseTotalNs)/1e9)
+}
@@ -0,0 +1,545 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fulldump/inceptiondb/database"
+)
+
+var ErrNotFollower = errors.New("this instance is not a follower")
+
+// errResync means the leader does not have the point to resume from anymore
+// (usually because of a compaction) so the collection is copied again
+var errResync = errors.New("resume point not found in the leader journal")
+
+// Follower replicates all the collections of a leader into a database, which
+// rejects writes from the API until the follower is promoted. Logical
+// databases are replicated too, with their settings.
+type Follower struct {
+	Leader string // base url, e.g. http://10.0.0.1:8080
+	// Interval between discoveries of new or dropped collections, and
+	// between retries of failed streams
+	Interval time.Duration
+	Client   *http.Client
+
+	db          *database.Database
+	mutex       *sync.Mutex
+	collections map[collectionKey]*followed
+	err         string
+	promoted    bool
+	cancel      context.CancelFunc
+	wg          *sync.WaitGroup
+}
+
+type collectionKey struct {
+	database string
+	name     string
+}
+
+type followed struct {
+	CollectionStatus
+	db          *database.Database // local database of the collection
+	collections string             // url of its database in the leader, see collectionsUrl
+	uuid        string             // last command applied, where the stream resumes from
+	timestamp   int64              // of the last command applied
+	leader      int64              // timestamp of the last command of the leader
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func NewFollower(db *database.Database, leader string) *Follower {
+	return &Follower{
+		Leader:      strings.TrimSuffix(leader, "/"),
+		Interval:    time.Second,
+		Client:      &http.Client{},
+		db:          db,
+		mutex:       &sync.Mutex{},
+		collections: map[collectionKey]*followed{},
+		wg:          &sync.WaitGroup{},
+	}
+}
+
+// Start makes the database read only and replicates the leader in background
+// until Stop or Promote are called
+func (f *Follower) Start() {
+
+	f.db.SetReadOnly(fmt.Sprintf("this instance is a follower of %s, writes must go to the leader", f.Leader))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+
+	f.wg.Add(1)
+	go f.run(ctx)
+}
+
+// Stop ends the replication, the database is still read only
+func (f *Follower) Stop() {
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.wg.Wait()
+}
+
+// Promote ends the replication and accepts writes, the instance becomes a
+// leader with the data replicated so far
+func (f *Follower) Promote() error {
+
+	f.mutex.Lock()
+	if f.promoted {
+		f.mutex.Unlock()
+		return ErrNotFollower
+	}
+	f.promoted = true
+	f.mutex.Unlock()
+
+	f.Stop()
+	f.db.SetReadOnly("")
+	slog.Info("promoted to leader, replication stopped", "leader", f.Leader)
+
+	return nil
+}
+
+func (f *Follower) Status() *Status {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.promoted {
+		return &Status{Role: RoleLeader}
+	}
+
+	status := &Status{
+		Role:        RoleFollower,
+		Leader:      f.Leader,
+		Error:       f.err,
+		Collections: make([]*CollectionStatus, 0, len(f.collections)),
+	}
+	for _, fc := range f.collections {
+		s := fc.CollectionStatus
+		s.LagCommands = max(0, s.LeaderPosition-s.Position)
+		s.Lag = time.Duration(max(0, fc.leader-fc.timestamp))
+		status.Collections = append(status.Collections, &s)
+	}
+	sort.Slice(status.Collections, func(i, j int) bool {
+		if status.Collections[i].Database != status.Collections[j].Database {
+			return status.Collections[i].Database < status.Collections[j].Database
+		}
+		return status.Collections[i].Name < status.Collections[j].Name
+	})
+
+	return status
+}
+
+func (f *Follower) run(ctx context.Context) {
+	defer f.wg.Done()
+
+	for {
+		if f.db.GetStatus() == database.StatusOperating {
+			err := f.discover(ctx)
+			f.mutex.Lock()
+			f.err = ""
+			if err != nil {
+				f.err = err.Error()
+			}
+			f.mutex.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			f.mutex.Lock()
+			for _, fc := range f.collections {
+				fc.cancel()
+			}
+			f.mutex.Unlock()
+			return
+		case <-time.After(f.Interval):
+		}
+	}
+}
+
+// discover starts following the new databases and collections of the leader
+// and drops the ones that do not exist there anymore. System collections (e.g.
+// the API keys) are not replicated.
+func (f *Follower) discover(ctx context.Context) error {
+
+	leaderDatabases, err := listDatabases(ctx, f.Client, f.Leader)
+	if err != nil {
+		return fmt.Errorf("list leader databases: %w", err)
+	}
+
+	exists := map[collectionKey]bool{}
+	databases := map[string]bool{}
+	for _, d := range leaderDatabases {
+		databases[d.Name] = true
+		db, err := f.database(d)
+		if err != nil {
+			return fmt.Errorf("database '%s': %w", d.Name, err)
+		}
+
+		collections := collectionsUrl(f.Leader, d.Name)
+		leaderCollections, err := listCollections(ctx, f.Client, collections)
+		if err != nil {
+			return fmt.Errorf("list leader collections: %w", err)
+		}
+		for _, c := range leaderCollections {
+			key := collectionKey{database: d.Name, name: c.Name}
+			exists[key] = true
+			if c.Status != "" {
+				continue // not available in the leader, keep the local copy as is
+			}
+			f.mutex.Lock()
+			_, following := f.collections[key]
+			f.mutex.Unlock()
+			if !following {
+				f.follow(ctx, db, collections, key)
+			}
+		}
+	}
+
+	for name, db := range f.db.ListDatabases() {
+		for _, key := range f.localCollections(name, db) {
+			if exists[key] || database.IsSystemCollection(key.name) {
+				continue // system collections are local, the leader does not list them
+			}
+			f.unfollow(key)
+			err := db.DropCollection(key.name)
+			if err == nil {
+				slog.Info("replication: collection dropped", "database", name, "collection", key.name)
+			}
+		}
+		if !databases[name] {
+			err := f.db.DropDatabase(name)
+			if err == nil {
+				slog.Info("replication: database dropped", "database", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// database returns the local copy of a leader database, it is created or its
+// settings updated as needed
+func (f *Follower) database(remote *remoteDatabase) (*database.Database, error) {
+
+	if remote.Name == database.DefaultDatabase {
+		return f.db, nil
+	}
+
+	db, err := f.db.GetDatabase(remote.Name)
+	if err == database.ErrDatabaseNotFound {
+		slog.Info("replication: database created", "database", remote.Name)
+		return f.db.CreateDatabase(remote.Name, &remote.Settings)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !reflect.DeepEqual(db.Settings(), &remote.Settings) {
+		err = db.SetSettings(&remote.Settings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// localCollections returns the collections of a local database, including
+// the quarantined ones and the ones being followed
+func (f *Follower) localCollections(name string, db *database.Database) []collectionKey {
+
+	keys := []collectionKey{}
+	for collectionName := range db.ListCollections() {
+		keys = append(keys, collectionKey{database: name, name: collectionName})
+	}
+	for _, q := range db.Quarantined() {
+		keys = append(keys, collectionKey{database: name, name: q.Name})
+	}
+
+	f.mutex.Lock()
+	for key := range f.collections {
+		if key.database == name {
+			keys = append(keys, key)
+		}
+	}
+	f.mutex.Unlock()
+
+	return keys
+}
+
+func (f *Follower) follow(ctx context.Context, db *database.Database, collections string, key collectionKey) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	fc := &followed{
+		CollectionStatus: CollectionStatus{Name: key.name},
+		db:               db,
+		collections:      collections,
+		cancel:           cancel,
+		done:             make(chan struct{}),
+	}
+	if key.database != database.DefaultDatabase {
+		fc.Database = key.database
+	}
+
+	// Resume from the local journal
+	col, err := db.GetCollection(key.name)
+	if err == nil {
+		head := col.Head()
+		fc.uuid = head.Uuid
+		fc.timestamp = head.Timestamp
+	}
+
+	f.mutex.Lock()
+	f.collections[key] = fc
+	f.mutex.Unlock()
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer close(fc.done)
+		for {
+			err := f.stream(ctx, fc)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == errResync {
+				err = f.reset(fc)
+				if err == nil {
+					continue
+				}
+			}
+			if err != nil {
+				slog.Warn("replication", "database", key.database, "collection", key.name, "error", err)
+				f.mutex.Lock()
+				fc.Error = err.Error()
+				f.mutex.Unlock()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(f.Interval):
+			}
+		}
+	}()
+}
+
+func (f *Follower) unfollow(key collectionKey) {
+	f.mutex.Lock()
+	fc, exists := f.collections[key]
+	delete(f.collections, key)
+	f.mutex.Unlock()
+
+	if exists {
+		fc.cancel()
+		<-fc.done
+	}
+}
+
+// reset empties the local copy of a collection to copy it again from the
+// beginning of the leader journal
+func (f *Follower) reset(fc *followed) error {
+
+	slog.Info("replication: copying again from the beginning", "database", fc.db.Name(), "collection", fc.Name)
+
+	_, err := fc.db.GetCollection(fc.Name)
+	if err != database.ErrCollectionNotFound {
+		err = fc.db.DropCollection(fc.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	f.mutex.Lock()
+	fc.uuid = ""
+	fc.timestamp = 0
+	fc.Position = 0
+	f.mutex.Unlock()
+
+	return nil
+}
+
+type remoteDatabase struct {
+	Name string `json:"name"`
+	database.Settings
+}
+
+// listDatabases returns only the default database if the leader does not
+// have logical databases (older versions)
+func listDatabases(ctx context.Context, client *http.Client, base string) ([]*remoteDatabase, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/v1/databases", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotImplemented {
+		return []*remoteDatabase{{Name: database.DefaultDatabase}}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	databases := []*remoteDatabase{}
+	err = json.NewDecoder(resp.Body).Decode(&databases)
+	if err != nil {
+		return nil, err
+	}
+
+	return databases, nil
+}
+
+// collectionsUrl returns where the collections of a database are served
+func collectionsUrl(base, name string) string {
+	if name == database.DefaultDatabase {
+		return base + "/v1/collections"
+	}
+	return base + "/v1/databases/" + url.PathEscape(name) + "/collections"
+}
+
+type remoteCollection struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // empty if it is ready
+}
+
+// listCollections lists the collections of a database, see collectionsUrl
+func listCollections(ctx context.Context, client *http.Client, u string) ([]*remoteCollection, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	collections := []*remoteCollection{}
+	err = json.NewDecoder(resp.Body).Decode(&collections)
+	if err != nil {
+		return nil, err
+	}
+
+	return collections, nil
+}
+
+// stream applies the commands of the leader until an error happens
+func (f *Follower) stream(ctx context.Context, fc *followed) error {
+
+	col, err := fc.db.GetCollection(fc.Name)
+	if err == database.ErrCollectionNotFound {
+		col, err = fc.db.CreateCollection(fc.Name)
+		fc.uuid = ""
+	}
+	if err != nil {
+		return err
+	}
+
+	return streamJournal(ctx, f.Client, fc.collections, fc.Name, fc.uuid, func(entry *Entry) error {
+
+		if entry.Command != nil {
+			err := col.ApplyCommand(entry.Command)
+			if err != nil {
+				return fmt.Errorf("apply command '%s': %w", entry.Command.Uuid, err)
+			}
+		}
+
+		f.mutex.Lock()
+		fc.track(entry)
+		f.mutex.Unlock()
+		return nil
+	})
+}
+
+// track updates the status with an entry of the stream
+func (fc *followed) track(entry *Entry) {
+	fc.LastContact = time.Now().UTC()
+	fc.Error = ""
+	fc.Position = entry.Position
+	fc.LeaderPosition = max(fc.LeaderPosition, entry.Position)
+	if entry.Command != nil {
+		fc.uuid = entry.Command.Uuid
+		fc.timestamp = entry.Command.Timestamp
+		fc.leader = max(fc.leader, fc.timestamp)
+	}
+	if entry.Head != nil {
+		fc.LeaderPosition = entry.Head.Position
+		fc.leader = entry.Head.Timestamp
+	}
+}
+
+// streamJournal calls f with the entries of the journal of a collection of
+// another instance (collections is the url of its database, see
+// collectionsUrl) after the command uuid (from the beginning if empty) until
+// an error happens
+func streamJournal(ctx context.Context, client *http.Client, collections, name, uuid string, f func(entry *Entry) error) error {
+
+	query := url.Values{}
+	if uuid != "" {
+		query.Set("after_uuid", uuid)
+	} else {
+		query.Set("after_position", "0")
+	}
+	u := collections + "/" + url.PathEscape(name) + ":journal?" + query.Encode()
+
+	// The other instance sends heartbeats, a silent connection is a dead one
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timeout := 3*HeartbeatInterval + time.Second
+	watchdog := time.AfterFunc(timeout, cancel)
+	defer watchdog.Stop()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusGone {
+		return errResync
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		entry := &Entry{}
+		err := decoder.Decode(entry)
+		if err != nil {
+			return err
+		}
+		watchdog.Reset(timeout)
+
+		err = f(entry)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fulldump/inceptiondb/database"
+)
+
+// Primary merges the changes of other primaries into a database
+// (multi-primary mode). Every primary tails the journals of the others and
+// merges their commands with collection.Collection.Merge, so changes flow in
+// all directions and conflicting writes converge to the same documents.
+// Merging is idempotent: streams start from the beginning of the journals of
+// the peers after a restart, and changes coming back from a peer are no-ops.
+type Primary struct {
+	Peers []string // base urls of the other primaries
+	// Interval between discoveries of new collections, and between retries
+	// of failed streams
+	Interval time.Duration
+	Client   *http.Client
+
+	db     *database.Database
+	mutex  *sync.Mutex
+	peers  map[string]*peer
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
+}
+
+type peer struct {
+	url         string
+	err         string // listing the peer collections
+	collections map[string]*followed
+}
+
+func NewPrimary(db *database.Database, peers []string) *Primary {
+	p := &Primary{
+		Interval: time.Second,
+		Client:   &http.Client{},
+		db:       db,
+		mutex:    &sync.Mutex{},
+		peers:    map[string]*peer{},
+		wg:       &sync.WaitGroup{},
+	}
+	for _, u := range peers {
+		u = strings.TrimSuffix(strings.TrimSpace(u), "/")
+		if u == "" {
+			continue
+		}
+		p.Peers = append(p.Peers, u)
+		p.peers[u] = &peer{
+			url:         u,
+			collections: map[string]*followed{},
+		}
+	}
+	return p
+}
+
+// Start merges the changes of the peers in background until Stop is called
+func (p *Primary) Start() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	for _, u := range p.Peers {
+		p.wg.Add(1)
+		go p.run(ctx, p.peers[u])
+	}
+}
+
+func (p *Primary) Stop() {
+	if p.cancel != nil {
+		p.cancel()
+	}
+	p.wg.Wait()
+}
+
+func (p *Primary) Status() *Status {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	status := &Status{
+		Role:  RolePrimary,
+		Peers: make([]*PeerStatus, 0, len(p.Peers)),
+	}
+	for _, u := range p.Peers {
+		pr := p.peers[u]
+		ps := &PeerStatus{
+			Url:         pr.url,
+			Error:       pr.err,
+			Collections: make([]*CollectionStatus, 0, len(pr.collections)),
+		}
+		for _, fc := range pr.collections {
+			s := fc.CollectionStatus
+			s.LagCommands = max(0, s.LeaderPosition-s.Position)
+			s.Lag = time.Duration(max(0, fc.leader-fc.timestamp))
+			ps.Collections = append(ps.Collections, &s)
+		}
+		sort.Slice(ps.Collections, func(i, j int) bool {
+			return ps.Collections[i].Name < ps.Collections[j].Name
+		})
+		status.Peers = append(status.Peers, ps)
+	}
+
+	return status
+}
+
+func (p *Primary) run(ctx context.Context, pr *peer) {
+	defer p.wg.Done()
+
+	for {
+		if p.db.GetStatus() == database.StatusOperating {
+			err := p.discover(ctx, pr)
+			p.mutex.Lock()
+			pr.err = ""
+			if err != nil {
+				pr.err = err.Error()
+			}
+			p.mutex.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.Interval):
+		}
+	}
+}
+
+// discover starts merging the new collections of a peer. Drops are not
+// propagated, a collection dropped in a peer keeps its local copy.
+func (p *Primary) discover(ctx context.Context, pr *peer) error {
+
+	peerCollections, err := listCollections(ctx, p.Client, collectionsUrl(pr.url, database.DefaultDatabase))
+	if err != nil {
+		return fmt.Errorf("list peer collections: %w", err)
+	}
+
+	for _, c := range peerCollections {
+		if c.Status != "" {
+			continue // not available in the peer
+		}
+		p.mutex.Lock()
+		_, merging := pr.collections[c.Name]
+		p.mutex.Unlock()
+		if !merging {
+			p.merge(ctx, pr, c.Name)
+		}
+	}
+
+	return nil
+}
+
+func (p *Primary) merge(ctx context.Context, pr *peer, name string) {
+
+	fc := &followed{
+		CollectionStatus: CollectionStatus{Name: name},
+		done:             make(chan struct{}),
+	}
+
+	p.mutex.Lock()
+	pr.collections[name] = fc
+	p.mutex.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(fc.done)
+		for {
+			err := p.stream(ctx, pr, fc)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == errResync {
+				// Merge everything again, known changes are skipped
+				p.mutex.Lock()
+				fc.uuid = ""
+				fc.Position = 0
+				p.mutex.Unlock()
+				continue
+			}
+			if err != nil {
+				slog.Warn("merging", "collection", name, "peer", pr.url, "error", err)
+				p.mutex.Lock()
+				fc.Error = err.Error()
+				p.mutex.Unlock()
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(p.Interval):
+			}
+		}
+	}()
+}
+
+// stream merges the commands of a peer until an error happens
+func (p *Primary) stream(ctx context.Context, pr *peer, fc *followed) error {
+
+	col, err := p.db.GetCollection(fc.Name)
+	if err == database.ErrCollectionNotFound {
+		col, err = p.db.CreateCollection(fc.Name)
+		if err != nil {
+			// Another peer may have created it meanwhile
+			col, err = p.db.GetCollection(fc.Name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return streamJournal(ctx, p.Client, collectionsUrl(pr.url, database.DefaultDatabase), fc.Name, fc.uuid, func(entry *Entry) error {
+
+		if entry.Command != nil {
+			err := col.Merge(entry.Command)
+			if err != nil {
+				return fmt.Errorf("merge command '%s': %w", entry.Command.Uuid, err)
+			}
+		}
+
+		p.mutex.Lock()
+		fc.track(entry)
+		p.mutex.Unlock()
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+// Package replication keeps a follower instance up to date with a leader by
+// shipping journal commands over HTTP. The follower discovers the collections
+// of the leader with GET /v1/collections (and the ones of every logical
+// database listed by GET /v1/databases) and tails each one with
+// GET /v1/collections/{name}:journal, a stream of Entry as JSON lines. Commands
+// are applied through the same replay path used to open a collection and
+// appended to the local journal with the same uuid, so the follower can
+// resume after a restart.
+//
+// In multi-primary mode every instance tails the journals of the others in the
+// same way, but commands are merged instead of replayed (see Primary).
+package replication
+
+import (
+	"time"
+
+	"github.com/fulldump/inceptiondb/collection"
+)
+
+const (
+	RoleLeader   = "leader"
+	RoleFollower = "follower"
+	RolePrimary  = "primary"
+)
+
+// HeartbeatInterval is how often the leader reports its head when there are
+// no commands to send
+var HeartbeatInterval = time.Second
+
+// Entry is a line of the journal stream. Commands come with their position,
+// heartbeats with the position of the last command sent and the head of the
+// leader journal.
+type Entry struct {
+	Position int64                   `json:"position"`
+	Command  *collection.Command     `json:"command,omitempty"`
+	Head     *collection.JournalHead `json:"head,omitempty"`
+}
+
+type Status struct {
+	Role        string              `json:"role"`
+	Leader      string              `json:"leader,omitempty"`
+	Error       string              `json:"error,omitempty"` // listing the leader collections
+	Collections []*CollectionStatus `json:"collections,omitempty"`
+	Peers       []*PeerStatus       `json:"peers,omitempty"`
+}
+
+type PeerStatus struct {
+	Url         string              `json:"url"`
+	Error       string              `json:"error,omitempty"` // listing the peer collections
+	Collections []*CollectionStatus `json:"collections"`
+}
+
+type CollectionStatus struct {
+	Database       string        `json:"database,omitempty"` // empty for the default database
+	Name           string        `json:"name"`
+	Position       int64         `json:"position"`
+	LeaderPosition int64         `json:"leader_position"`
+	LagCommands    int64         `json:"lag_commands"`
+	Lag            time.Duration `json:"lag"`
+	LastContact    time.Time     `json:"last_contact"`
+	Error          string        `json:"error,omitempty"`
+}
@@ -2,7 +2,7 @@ package service
 
 import (
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"os"
 	"path"
 	"sort"
@@ -122,10 +122,10 @@ func writeFile(filename, text string) {
 	examplesPath := os.Getenv("API_EXAMPLES_PATH")
 	if examplesPath != "" {
 		p := path.Join(examplesPath, path.Clean(filename))
-		fmt.Println("Saving", p)
+		slog.Info("saving example", "file", p)
 		err := os.WriteFile(p, []byte(text), 0666)
 		if nil != err {
-			fmt.Println("Saving err:", err)
+			slog.Error("save example", "file", p, "error", err)
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"archive/tar"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -65,6 +66,54 @@ func Acceptance(a *biff.A, apiRequest func(method, path string) *apitest.Request
 			biff.AssertEqualJson(resp.BodyJson(), expectedBody)
 		})
 
+		a.Alternative("Set durability", func(a *biff.A) {
+			resp := apiRequest("POST", "/collections/my-collection:setDurability").
+				WithBodyJson(JSON{
+					"mode":     "interval",
+					"interval": "200ms",
+				}).Do()
+			Save(resp, "Set durability", `
+				Override the server durability policy for this collection:
+
+				* ´none´: the journal is written when the buffer is full or the collection is closed
+				* ´interval´: the journal is flushed and fsynced periodically
+				* ´sync´: responses wait for the fsync (shared by concurrent requests)
+
+				An empty ´mode´ restores the server default.
+			`)
+
+			biff.AssertEqual(resp.StatusCode, http.StatusOK)
+			biff.AssertEqualJson(resp.BodyJson(), JSON{
+				"mode":     "interval",
+				"interval": "200ms",
+			})
+
+			a.Alternative("Retrieve collection", func(a *biff.A) {
+				resp := apiRequest("GET", "/collections/my-collection").Do()
+
+				biff.AssertEqual(resp.StatusCode, http.StatusOK)
+				biff.AssertEqualJson(resp.BodyJson(), JSON{
+					"name":     "my-collection",
+					"total":    0,
+					"indexes":  0,
+					"defaults": map[string]any{"id": "uuid()"},
+					"durability": JSON{
+						"mode":     "interval",
+						"interval": "200ms",
+					},
+				})
+			})
+		})
+
+		a.Alternative("Set durability - invalid mode", func(a *biff.A) {
+			resp := apiRequest("POST", "/collections/my-collection:setDurability").
+				WithBodyJson(JSON{
+					"mode": "sometimes",
+				}).Do()
+
+			biff.AssertEqual(resp.StatusCode, http.StatusBadRequest)
+		})
+
 		a.Alternative("Drop collection", func(a *biff.A) {
 			resp := apiRequest("POST", "/collections/my-collection:dropCollection").
 				Do()
@@ -100,6 +149,83 @@ func Acceptance(a *biff.A, apiRequest func(method, path string) *apitest.Request
 			}
 			biff.AssertEqual(resp.BodyJson(), expectedBody)
 
+			a.Alternative("Changes", func(a *biff.A) {
+				resp := apiRequest("POST", "/collections/my-collection:changes").
+					WithBodyJson(JSON{
+						"since": 0,
+						"limit": 100,
+					}).Do()
+				Save(resp, "Changes", `
+					Return the documents inserted, updated or removed after a checkpoint, so
+					offline clients can pull only what changed. Every document comes once
+					with its last state: ´upsert´ with the whole document or ´delete´ (a
+					tombstone) with its id, ordered by the ´sequence´ of their last change.
+					Use the returned ´checkpoint´ as ´since´ in the next request (zero means
+					from the beginning) and keep asking while ´more´ is true. A checkpoint
+					older than the removals forgotten by a compaction gets ´410 Gone´: drop
+					the local copy and start again from the beginning.
+				`)
+
+				biff.AssertEqual(resp.StatusCode, http.StatusOK)
+				page := struct {
+					Changes []struct {
+						Type     string
+						Id       string
+						Document JSON
+					}
+					Checkpoint int64
+					More       bool
+				}{}
+				json.Unmarshal(resp.BodyBytes(), &page)
+				biff.AssertEqual(len(page.Changes), 1)
+				biff.AssertEqual(page.Changes[0].Type, "upsert")
+				biff.AssertEqual(page.Changes[0].Id, "my-id")
+				biff.AssertEqual(page.More, false)
+
+				a.Alternative("Push", func(a *biff.A) {
+					resp := apiRequest("POST", "/collections/my-collection:push").
+						WithBodyJson(JSON{
+							"changes": []JSON{
+								{"type": "upsert", "document": JSON{"id": "my-id", "name": "Fulano"}},
+								{"type": "upsert", "document": JSON{"id": "other-id", "name": "Mengano"}},
+								{"type": "delete", "id": "unknown-id"},
+							},
+						}).Do()
+					Save(resp, "Push", `
+						Apply a batch of changes made by an offline client, with the same format
+						returned by ´:changes´. An ´upsert´ replaces the document with the same
+						id or inserts it, a ´delete´ removes it. Changes are applied one by one
+						and the result of each one is returned in the same order.
+					`)
+
+					biff.AssertEqual(resp.StatusCode, http.StatusOK)
+					biff.AssertEqualJson(resp.BodyJson(), []JSON{
+						{"id": "my-id", "status": "updated"},
+						{"id": "other-id", "status": "inserted"},
+						{"id": "unknown-id", "status": "not_found"},
+					})
+
+					resp = apiRequest("POST", "/collections/my-collection:changes").
+						WithBodyJson(JSON{
+							"since": page.Checkpoint,
+						}).Do()
+					biff.AssertEqual(resp.StatusCode, http.StatusOK)
+					biff.AssertEqualJson(resp.BodyJson().(JSON)["changes"], []JSON{
+						{"sequence": 2, "type": "upsert", "id": "my-id", "document": JSON{"id": "my-id", "name": "Fulano"}},
+						{"sequence": 3, "type": "upsert", "id": "other-id", "document": JSON{"id": "other-id", "name": "Mengano"}},
+					})
+				})
+
+				a.Alternative("Changes - checkpoint expired", func(a *biff.A) {
+					resp := apiRequest("POST", "/collections/my-collection:changes").
+						WithBodyJson(JSON{
+							"since": page.Checkpoint + 100,
+						}).Do()
+
+					biff.AssertEqual(resp.StatusCode, http.StatusGone)
+				})
+			})
+
 			a.Alternative("Find with fullscan", func(a *biff.A) {
 				resp := apiRequest("POST", "/collections/my-collection:find").
 					WithBodyJson(JSON{
@@ -198,6 +324,80 @@ func Acceptance(a *biff.A, apiRequest func(method, path string) *apitest.Request
 						This will probably be removed, it is extremely inefficient.
 					`)
 				})
+				a.Alternative("Compact", func(a *biff.A) {
+					resp := apiRequest("POST", "/collections/my-collection:compact").Do()
+					Save(resp, "Compact", `
+						Rewrite the collection journal as a snapshot of the current state
+						(defaults, indexes and documents) and replace it atomically.
+						The collection keeps serving requests during the operation.
+					`)
+
+					biff.AssertEqual(resp.StatusCode, http.StatusOK)
+					stats := struct {
+						CommandsBefore int `json:"commands_before"`
+						CommandsAfter  int `json:"commands_after"`
+					}{}
+					json.Unmarshal(resp.BodyBytes(), &stats)
+					biff.AssertEqual(stats.CommandsBefore, 6)
+					biff.AssertEqual(stats.CommandsAfter, 6)
+				})
+
+				a.Alternative("Rotate key", func(a *biff.A) {
+					resp := apiRequest("POST", "/collections/my-collection:rotateKey").Do()
+					Save(resp, "Rotate key", `
+						Rewrite the journal segments that are not encrypted with the current
+						encryption key (the first one of ´EncryptionKey´ or ´EncryptionKeyFile´).
+						Once it finishes, previous keys can be removed from the configuration.
+						Without encryption configured, encrypted segments can not be read and
+						plain text segments are left untouched.
+					`)
+
+					biff.AssertEqual(resp.StatusCode, http.StatusOK)
+					stats := struct {
+						Key       string `json:"key"`
+						Segments  int    `json:"segments"`
+						Rewritten int    `json:"rewritten"`
+					}{}
+					json.Unmarshal(resp.BodyBytes(), &stats)
+					biff.AssertEqual(stats.Key, "")
+					biff.AssertEqual(stats.Segments, 1)
+					biff.AssertEqual(stats.Rewritten, 0)
+				})
+
+				a.Alternative("Backup", func(a *biff.A) {
+					resp := apiRequest("POST", "/backup").Do()
+
+					biff.AssertEqual(resp.StatusCode, http.StatusOK)
+					biff.AssertEqual(resp.Header.Get("Content-Type"), "application/x-tar")
+
+					files := map[string]int64{}
+					manifest := struct {
+						Collections []struct {
+							Name  string
+							Files []struct {
+								Name string
+								Size int64
+							}
+						}
+					}{}
+					tr := tar.NewReader(bytes.NewReader(resp.BodyBytes()))
+					for {
+						header, err := tr.Next()
+						if err != nil {
+							break
+						}
+						files[header.Name] = header.Size
+						if header.Name == "backup.json" {
+							json.NewDecoder(tr).Decode(&manifest)
+						}
+					}
+					biff.AssertEqual(len(manifest.Collections), 1)
+					biff.AssertEqual(manifest.Collections[0].Name, "my-collection")
+					for _, file := range manifest.Collections[0].Files {
+						biff.AssertEqual(files[file.Name], file.Size)
+					}
+					biff.AssertTrue(files["collections/my-collection"] > 0)
+				})
 
 			})
 
@@ -891,4 +1091,144 @@ func Acceptance(a *biff.A, apiRequest func(method, path string) *apitest.Request
 		biff.AssertEqual(resp.StatusCode, http.StatusInternalServerError)
 	})
 
+	a.Alternative("Load progress", func(a *biff.A) {
+
+		resp := apiRequest("GET", "/load").Do()
+		Save(resp, "Load progress", `
+			Collections are loaded concurrently at startup (´LoadWorkers´ at a time,
+			biggest first). Each collection is served as soon as it is ready, requests
+			to collections that are still loading get a ´503 Service Unavailable´.
+			Status is ´opening´ until all of them are loaded and then ´operating´.
+		`)
+
+		biff.AssertEqual(resp.StatusCode, http.StatusOK)
+		biff.AssertEqual(resp.BodyJson().(JSON)["status"], "operating")
+	})
+
+	a.Alternative("Create database", func(a *biff.A) {
+
+		resp := apiRequest("POST", "/databases").
+			WithBodyJson(JSON{
+				"name":            "my-team",
+				"defaults":        JSON{"id": "auto()"},
+				"max_collections": 1,
+				"max_documents":   2,
+			}).Do()
+		Save(resp, "Create database", `
+			Logical databases group collections with their own names, defaults and
+			limits, so several teams or environments can share an instance. Their
+			collections are served under ´/v1/databases/{name}/collections´ with the
+			same API as ´/v1/collections´, which is the ´default´ database.
+
+			* ´defaults´: defaults of the collections created without them
+			* ´max_collections´: maximum number of collections
+			* ´max_documents´: maximum number of documents per collection
+
+			Limits are optional, zero means no limit. Writes beyond a limit get
+			´403 Forbidden´.
+		`)
+
+		biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+		biff.AssertEqualJson(resp.BodyJson(), JSON{
+			"name":            "my-team",
+			"collections":     0,
+			"defaults":        JSON{"id": "auto()"},
+			"max_collections": 1,
+			"max_documents":   2,
+		})
+
+		a.Alternative("List databases", func(a *biff.A) {
+			resp := apiRequest("GET", "/databases").Do()
+			Save(resp, "List databases", ``)
+
+			biff.AssertEqual(resp.StatusCode, http.StatusOK)
+			biff.AssertEqualJson(resp.BodyJson(), []JSON{
+				{"name": "default", "collections": 0},
+				{"name": "my-team", "collections": 0, "defaults": JSON{"id": "auto()"}, "max_collections": 1, "max_documents": 2},
+			})
+		})
+
+		a.Alternative("Create database - already exists", func(a *biff.A) {
+			resp := apiRequest("POST", "/databases").
+				WithBodyJson(JSON{"name": "my-team"}).Do()
+
+			biff.AssertEqual(resp.StatusCode, http.StatusConflict)
+		})
+
+		a.Alternative("Insert into database", func(a *biff.A) {
+			resp := apiRequest("POST", "/databases/my-team/collections/my-collection:insert").
+				WithBodyJson(JSON{"name": "Fulanez"}).Do()
+			Save(resp, "Insert into database", `
+				Collections of a logical database are created like the ones of the
+				default database, taking the defaults of the database.
+			`)
+
+			biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+			biff.AssertEqualJson(resp.BodyJson(), JSON{"id": 1, "name": "Fulanez"})
+
+			// Names do not collide with the default database
+			resp = apiRequest("GET", "/collections/my-collection").Do()
+			biff.AssertEqual(resp.StatusCode, http.StatusNotFound)
+
+			a.Alternative("Document limit", func(a *biff.A) {
+				apiRequest("POST", "/databases/my-team/collections/my-collection:insert").
+					WithBodyJson(JSON{"name": "Menganez"}).Do()
+				resp := apiRequest("POST", "/databases/my-team/collections/my-collection:insert").
+					WithBodyJson(JSON{"name": "Zutanez"}).Do()
+
+				biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+			})
+
+			a.Alternative("Collection limit", func(a *biff.A) {
+				resp := apiRequest("POST", "/databases/my-team/collections").
+					WithBodyJson(JSON{"name": "other-collection"}).Do()
+
+				biff.AssertEqual(resp.StatusCode, http.StatusForbidden)
+			})
+
+			a.Alternative("Set database settings", func(a *biff.A) {
+				resp := apiRequest("POST", "/databases/my-team:setSettings").
+					WithBodyJson(JSON{
+						"defaults":        JSON{"id": "uuid()"},
+						"max_collections": 10,
+					}).Do()
+				Save(resp, "Set database settings", `
+					Replace the defaults and limits of a logical database. Existing
+					collections and documents are kept even if they are beyond the new
+					limits.
+				`)
+
+				biff.AssertEqual(resp.StatusCode, http.StatusOK)
+				biff.AssertEqualJson(resp.BodyJson(), JSON{
+					"name":            "my-team",
+					"collections":     1,
+					"defaults":        JSON{"id": "uuid()"},
+					"max_collections": 10,
+				})
+
+				resp = apiRequest("POST", "/databases/my-team/collections").
+					WithBodyJson(JSON{"name": "other-collection"}).Do()
+				biff.AssertEqual(resp.StatusCode, http.StatusCreated)
+			})
+
+			a.Alternative("Drop database", func(a *biff.A) {
+				resp := apiRequest("POST", "/databases/my-team:dropDatabase").Do()
+				Save(resp, "Drop database", `
+					Remove a logical database with all its collections.
+				`)
+
+				biff.AssertEqual(resp.StatusCode, http.StatusNoContent)
+
+				resp = apiRequest("GET", "/databases/my-team/collections").Do()
+				biff.AssertEqual(resp.StatusCode, http.StatusNotFound)
+			})
+		})
+	})
+
+	a.Alternative("Get database - not found", func(a *biff.A) {
+		resp := apiRequest("GET", "/databases/unknown").Do()
+
+		biff.AssertEqual(resp.StatusCode, http.StatusNotFound)
+	})
+
 }
@@ -2,15 +2,51 @@ package service
 
 import (
 	"errors"
+	"io"
 
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/cluster"
 	"github.com/fulldump/inceptiondb/collection"
+	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/replication"
 )
 
 var ErrorCollectionNotFound = errors.New("collection not found")
+var ErrorCollectionLoading = database.ErrCollectionLoading
+var ErrorCollectionQuarantined = database.ErrCollectionQuarantined
+var ErrorCollectionNotQuarantined = database.ErrCollectionNotQuarantined
+var ErrorNotFollower = replication.ErrNotFollower
+var ErrorClusterDisabled = errors.New("cluster mode is disabled")
+var ErrorDatabaseNotFound = database.ErrDatabaseNotFound
+var ErrorAuthDisabled = errors.New("authentication is disabled")
+var ErrorKeyNotFound = auth.ErrKeyNotFound
+var ErrorAuditDisabled = errors.New("audit log is disabled")
 
 type Servicer interface { // todo: review naming
 	CreateCollection(name string) (*collection.Collection, error)
 	GetCollection(name string) (*collection.Collection, error)
 	ListCollections() map[string]*collection.Collection
 	DeleteCollection(name string) error
+	Backup(w io.Writer) (*database.BackupManifest, error)
+	LoadProgress() *database.LoadProgress
+	ListQuarantined() []*database.QuarantinedCollection
+	RetryCollection(name string) error
+	RepairCollection(name string) (*collection.RepairStats, error)
+	ReplicationStatus() *replication.Status
+	Promote() error
+	ClusterStatus() (*cluster.Status, error)
+	ClusterVote(request *cluster.VoteRequest) (*cluster.VoteResponse, error)
+	ClusterAppend(request *cluster.AppendRequest) (*cluster.AppendResponse, error)
+	ListDatabases() map[string]*database.Database
+	CreateDatabase(name string, settings *database.Settings) (*database.Database, error)
+	GetDatabase(name string) (*database.Database, error)
+	DropDatabase(name string) error
+	Database(name string) (Servicer, error)
+	DatabaseSettings() *database.Settings
+	ListKeys() ([]*auth.Key, error)
+	CreateKey(name, subject string, admin bool, scopes []auth.Scope) (*auth.Key, string, error)
+	GetKey(id string) (*auth.Key, error)
+	RevokeKey(id string) error
+	QueryAudit(filter *audit.Filter) ([]*audit.Entry, error)
 }
@@ -5,70 +5,181 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"path"
+	"log/slog"
 
+	"github.com/fulldump/inceptiondb/audit"
+	"github.com/fulldump/inceptiondb/auth"
+	"github.com/fulldump/inceptiondb/cluster"
 	"github.com/fulldump/inceptiondb/collection"
 	"github.com/fulldump/inceptiondb/database"
+	"github.com/fulldump/inceptiondb/replication"
 )
 
 type Service struct {
-	db          *database.Database
-	collections map[string]*collection.Collection
+	db       *database.Database
+	follower *replication.Follower // nil if this instance is not a follower
+	primary  *replication.Primary  // nil if multi-primary mode is disabled
+	node     *cluster.Node         // nil if cluster mode is disabled
+	keys     *auth.Keys            // nil if authentication is disabled
+	audit    *audit.Log            // nil if the audit log is disabled
 }
 
 func NewService(db *database.Database) *Service {
 	return &Service{
-		db:          db,
-		collections: db.Collections, // todo: remove from here
+		db: db,
 	}
 }
 
 var ErrorCollectionAlreadyExists = errors.New("collection already exists")
+var ErrorCollectionReserved = fmt.Errorf("collection names starting with '%s' are reserved", database.SystemCollectionPrefix)
 
 func (s *Service) CreateCollection(name string) (*collection.Collection, error) {
-	_, exist := s.collections[name]
-	if exist {
+	if database.IsSystemCollection(name) {
+		return nil, ErrorCollectionReserved
+	}
+	_, err := s.db.GetCollection(name)
+	if err == nil {
 		return nil, ErrorCollectionAlreadyExists
 	}
-
-	filename := path.Join(s.db.Config.Dir, name)
-
-	collection, err := collection.OpenCollection(filename)
-	if err != nil {
-		return nil, err
+	if err == database.ErrCollectionLoading {
+		return nil, ErrorCollectionLoading
+	}
+	if errors.Is(err, database.ErrCollectionQuarantined) {
+		return nil, ErrorCollectionAlreadyExists
 	}
 
-	s.collections[name] = collection
-
-	return collection, nil
+	return s.db.CreateCollection(name)
 }
 
 func (s *Service) GetCollection(name string) (*collection.Collection, error) {
-	collection, exist := s.collections[name]
-	if !exist {
+	if database.IsSystemCollection(name) {
 		return nil, ErrorCollectionNotFound
 	}
+	collection, err := s.db.GetCollection(name)
+	if err == database.ErrCollectionNotFound {
+		return nil, ErrorCollectionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	return collection, nil
 }
 
 func (s *Service) ListCollections() map[string]*collection.Collection {
-	return s.collections
+	result := s.db.ListCollections()
+	for name := range result {
+		if database.IsSystemCollection(name) {
+			delete(result, name)
+		}
+	}
+	return result
 }
 
 func (s *Service) DeleteCollection(name string) error {
+	if database.IsSystemCollection(name) {
+		return ErrorCollectionNotFound
+	}
 	return s.db.DropCollection(name)
 }
 
+func (s *Service) Backup(w io.Writer) (*database.BackupManifest, error) {
+	return s.db.Backup(w)
+}
+
+func (s *Service) LoadProgress() *database.LoadProgress {
+	return s.db.LoadProgress()
+}
+
+// SetFollower is needed to report the replication status and to promote the
+// instance
+func (s *Service) SetFollower(f *replication.Follower) {
+	s.follower = f
+}
+
+// SetPrimary is needed to report the status of the peers in multi-primary mode
+func (s *Service) SetPrimary(p *replication.Primary) {
+	s.primary = p
+}
+
+func (s *Service) ReplicationStatus() *replication.Status {
+	if s.primary != nil {
+		return s.primary.Status()
+	}
+	if s.follower == nil {
+		return &replication.Status{Role: replication.RoleLeader}
+	}
+	return s.follower.Status()
+}
+
+func (s *Service) Promote() error {
+	if s.follower == nil {
+		return ErrorNotFollower
+	}
+	return s.follower.Promote()
+}
+
+// SetClusterNode enables the cluster endpoints
+func (s *Service) SetClusterNode(node *cluster.Node) {
+	s.node = node
+}
+
+func (s *Service) ClusterStatus() (*cluster.Status, error) {
+	if s.node == nil {
+		return nil, ErrorClusterDisabled
+	}
+	return s.node.Status(), nil
+}
+
+func (s *Service) ClusterVote(request *cluster.VoteRequest) (*cluster.VoteResponse, error) {
+	if s.node == nil {
+		return nil, ErrorClusterDisabled
+	}
+	return s.node.HandleVote(request)
+}
+
+func (s *Service) ClusterAppend(request *cluster.AppendRequest) (*cluster.AppendResponse, error) {
+	if s.node == nil {
+		return nil, ErrorClusterDisabled
+	}
+	return s.node.HandleAppend(request)
+}
+
+func (s *Service) ListQuarantined() []*database.QuarantinedCollection {
+	return s.db.Quarantined()
+}
+
+func (s *Service) RetryCollection(name string) error {
+	if database.IsSystemCollection(name) {
+		return ErrorCollectionNotFound
+	}
+	_, err := s.db.RetryCollection(name)
+	if err == database.ErrCollectionNotFound {
+		return ErrorCollectionNotFound
+	}
+	return err
+}
+
+func (s *Service) RepairCollection(name string) (*collection.RepairStats, error) {
+	if database.IsSystemCollection(name) {
+		return nil, ErrorCollectionNotFound
+	}
+	stats, err := s.db.RepairCollection(name)
+	if err == database.ErrCollectionNotFound {
+		return nil, ErrorCollectionNotFound
+	}
+	return stats, err
+}
+
 var ErrorInsertBadJson = errors.New("insert bad json")
 var ErrorInsertConflict = errors.New("insert conflict")
 
 func (s *Service) Insert(name string, data io.Reader) error {
 
-	collection, exists := s.db.Collections[name]
-	if !exists {
+	collection, err := s.GetCollection(name)
+	if err != nil {
 		// TODO: here create collection :D
-		return ErrorCollectionNotFound
+		return err
 	}
 
 	jsonReader := json.NewDecoder(data)
@@ -81,7 +192,7 @@ func (s *Service) Insert(name string, data io.Reader) error {
 		}
 		if err != nil {
 			// TODO: handle error properly
-			fmt.Println("ERROR:", err.Error())
+			slog.Warn("insert: decode", "error", err)
 			return ErrorInsertBadJson
 		}
 		_, err = collection.Insert(item)
@@ -95,3 +206,87 @@ func (s *Service) Insert(name string, data io.Reader) error {
 
 	return nil
 }
+
+func (s *Service) ListDatabases() map[string]*database.Database {
+	return s.db.ListDatabases()
+}
+
+func (s *Service) CreateDatabase(name string, settings *database.Settings) (*database.Database, error) {
+	return s.db.CreateDatabase(name, settings)
+}
+
+func (s *Service) GetDatabase(name string) (*database.Database, error) {
+	return s.db.GetDatabase(name)
+}
+
+func (s *Service) DropDatabase(name string) error {
+	return s.db.DropDatabase(name)
+}
+
+// Database returns a Servicer for the collections of a logical database
+func (s *Service) Database(name string) (Servicer, error) {
+	db, err := s.db.GetDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+	if db == s.db {
+		return s, nil
+	}
+	return NewService(db), nil
+}
+
+// DatabaseSettings returns nil for the default database
+func (s *Service) DatabaseSettings() *database.Settings {
+	return s.db.Settings()
+}
+
+// SetKeys enables the API key endpoints
+func (s *Service) SetKeys(keys *auth.Keys) {
+	s.keys = keys
+}
+
+func (s *Service) ListKeys() ([]*auth.Key, error) {
+	if s.keys == nil {
+		return nil, ErrorAuthDisabled
+	}
+	return s.keys.List()
+}
+
+// CreateKey returns the secret of the new key, or an empty one for keys bound
+// to a certificate subject
+func (s *Service) CreateKey(name, subject string, admin bool, scopes []auth.Scope) (*auth.Key, string, error) {
+	if s.keys == nil {
+		return nil, "", ErrorAuthDisabled
+	}
+	if subject != "" {
+		key, err := s.keys.CreateForSubject(name, subject, admin, scopes)
+		return key, "", err
+	}
+	return s.keys.Create(name, admin, scopes)
+}
+
+func (s *Service) GetKey(id string) (*auth.Key, error) {
+	if s.keys == nil {
+		return nil, ErrorAuthDisabled
+	}
+	return s.keys.Get(id)
+}
+
+func (s *Service) RevokeKey(id string) error {
+	if s.keys == nil {
+		return ErrorAuthDisabled
+	}
+	return s.keys.Revoke(id)
+}
+
+// SetAudit enables the audit log endpoint
+func (s *Service) SetAudit(l *audit.Log) {
+	s.audit = l
+}
+
+func (s *Service) QueryAudit(filter *audit.Filter) ([]*audit.Entry, error) {
+	if s.audit == nil {
+		return nil, ErrorAuditDisabled
+	}
+	return s.audit.Query(filter)
+}